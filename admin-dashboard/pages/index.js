@@ -1,16 +1,112 @@
 import Head from 'next/head';
 import { useState, useEffect } from 'react';
 
+// 入力をモデルに渡した方法 (problems.input_method) の表示名
+const inputMethodLabels = {
+  text: 'テキスト',
+  pdf_text: 'PDF→テキスト',
+  pdf_mixed: 'PDF→テキスト+画像ページ',
+  pdf_blob: 'PDFファイル',
+  docx_text: 'Word→テキスト',
+  pptx_text: 'PowerPoint→テキスト',
+  markdown: 'Markdown',
+  tex: 'LaTeX',
+  text_file: 'テキストファイル',
+  blob: 'ファイル',
+};
+
+// 再利用した過去の結果 (problems.cache_hit) の表示名
+const cacheHitLabels = {
+  structure: '構成を再利用',
+  full: '結果を再利用',
+};
+
+// APIの認証トークン (OIDCプロバイダが発行したトークンまたはAPIキー) はブラウザに保存する
+const TOKEN_STORAGE_KEY = 'edumint_api_token';
+const loadToken = () => (typeof window === 'undefined' ? '' : window.localStorage.getItem(TOKEN_STORAGE_KEY) || '');
+
+// 保存したトークンをAuthorizationヘッダーに付けてAPIを呼び出す
+const apiFetch = (url, options = {}) => {
+  const token = loadToken();
+  const headers = token ? { ...options.headers, Authorization: `Bearer ${token}` } : options.headers;
+  return fetch(url, { ...options, headers });
+};
+
+// EventSourceはヘッダーを付けられないため、1分間・1回だけ使えるチケットを発行してURLで渡す
+// (トークン自体はURLに載せない)
+const openEventStream = async (path) => {
+  const response = await apiFetch('http://localhost:8080/api/v1/stream-tickets', {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ path }),
+  });
+  if (!response.ok) {
+    throw new Error(`HTTP error! status: ${response.status}`);
+  }
+  const { ticket } = await response.json();
+  return new EventSource(`http://localhost:8080${path}?ticket=${encodeURIComponent(ticket)}`);
+};
+
 export default function AdminHome() {
   const [history, setHistory] = useState([]);
   const [isLoading, setIsLoading] = useState(true);
   const [error, setError] = useState('');
+  const [deadLetters, setDeadLetters] = useState([]);
+  const [usage, setUsage] = useState([]); // コースごとのジョブ数とトークン使用量
+  const [courseFilter, setCourseFilter] = useState(''); // 履歴を絞り込むコースのID (空はすべて)
+  const [apiToken, setApiToken] = useState(null); // 保存済みのトークンを読み込むまでは null
+  const [tokenInput, setTokenInput] = useState('');
+
+  useEffect(() => {
+    setApiToken(loadToken());
+    setTokenInput(loadToken());
+  }, []);
+
+  // 入力されたトークンを保存して履歴を取り直す (空にすると削除)
+  const saveToken = (e) => {
+    e.preventDefault();
+    if (tokenInput) {
+      window.localStorage.setItem(TOKEN_STORAGE_KEY, tokenInput);
+    } else {
+      window.localStorage.removeItem(TOKEN_STORAGE_KEY);
+    }
+    setError('');
+    setApiToken(tokenInput);
+  };
+
+  const fetchDeadLetters = async () => {
+    try {
+      const response = await apiFetch('http://localhost:8080/api/v1/admin/dead-letters');
+      if (!response.ok) {
+        throw new Error(`HTTP error! status: ${response.status}`);
+      }
+      setDeadLetters(await response.json());
+    } catch (err) {
+      setError(err.message);
+    }
+  };
+
+  const fetchUsage = async () => {
+    try {
+      const response = await apiFetch('http://localhost:8080/api/v1/admin/usage');
+      if (!response.ok) {
+        throw new Error(`HTTP error! status: ${response.status}`);
+      }
+      setUsage(await response.json());
+    } catch (err) {
+      setError(err.message);
+    }
+  };
 
   useEffect(() => {
+    if (apiToken === null) {
+      return;
+    }
     const fetchHistory = async () => {
       try {
         setIsLoading(true);
-        const response = await fetch('http://localhost:8080/api/v1/admin/history');
+        const query = courseFilter ? `?course_id=${courseFilter}` : '';
+        const response = await apiFetch(`http://localhost:8080/api/v1/admin/history${query}`);
         if (!response.ok) {
           throw new Error(`HTTP error! status: ${response.status}`);
         }
@@ -23,11 +119,69 @@ export default function AdminHome() {
       }
     };
 
-    fetchHistory();
-    // Poll for updates every 10 seconds
-    const intervalId = setInterval(fetchHistory, 10000);
-    return () => clearInterval(intervalId);
-  }, []);
+    const refresh = () => {
+      fetchHistory();
+      fetchDeadLetters();
+      fetchUsage();
+    };
+
+    refresh();
+
+    // ジョブの状態が変わるたびにSSEで通知を受けて再取得する。
+    // 短時間に続く通知はまとめて1回の再取得にする。
+    let timeoutId = null;
+    let intervalId = null;
+    let source = null;
+    let closed = false;
+    // ストリームが使えない場合は10秒ごとのポーリングに戻す
+    const startPolling = () => {
+      if (!closed && !intervalId) {
+        intervalId = setInterval(refresh, 10000);
+      }
+    };
+    openEventStream('/api/v1/admin/events')
+      .then((s) => {
+        if (closed) {
+          s.close();
+          return;
+        }
+        source = s;
+        source.addEventListener('status', () => {
+          clearTimeout(timeoutId);
+          timeoutId = setTimeout(refresh, 500);
+        });
+        source.onerror = () => {
+          source.close();
+          startPolling();
+        };
+      })
+      .catch(startPolling);
+    return () => {
+      closed = true;
+      if (source) source.close();
+      clearTimeout(timeoutId);
+      clearInterval(intervalId);
+    };
+  }, [apiToken, courseFilter]);
+
+  // デッドレターキューのジョブを再投入する (problemIdを省略すると全件)
+  const replayDeadLetters = async (problemId) => {
+    try {
+      const response = await apiFetch('http://localhost:8080/api/v1/admin/dead-letters/replay', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(problemId ? { problem_id: problemId } : {}),
+      });
+      if (!response.ok) {
+        throw new Error(`HTTP error! status: ${response.status}`);
+      }
+      await fetchDeadLetters();
+    } catch (err) {
+      setError(err.message);
+    }
+  };
+
+  const totalSavedTokens = history.reduce((sum, item) => sum + (item.saved_tokens || 0), 0);
 
   const getStatusClass = (status) => {
     switch (status) {
@@ -35,6 +189,8 @@ export default function AdminHome() {
       case 'failed': return 'status-failed';
       case 'processing': return 'status-processing';
       case 'pending': return 'status-pending';
+      case 'cancelled': return 'status-cancelled';
+      case 'awaiting_review': return 'status-awaiting-review';
       default: return '';
     }
   };
@@ -47,10 +203,24 @@ export default function AdminHome() {
       <header className="header">
         <h1>管理者ダッシュボード</h1>
         <p>生成ジョブの履歴 (自動更新)</p>
+        <form className="token-form" onSubmit={saveToken}>
+          <input type="password" value={tokenInput} onChange={(e) => setTokenInput(e.target.value)} placeholder="APIキーまたはIDトークン" />
+          <button type="submit">保存</button>
+        </form>
       </header>
       <main>
         {isLoading && history.length === 0 && <p>履歴を読み込み中...</p>}
         {error && <p className="error">エラー: {error}</p>}
+        <label className="course-filter">
+          コース:
+          <select value={courseFilter} onChange={(e) => setCourseFilter(e.target.value)}>
+            <option value="">すべて</option>
+            {usage.map((u) => <option key={u.course_id} value={u.course_id}>{u.course_code} {u.course_name}</option>)}
+          </select>
+        </label>
+        {totalSavedTokens > 0 && (
+          <p className="savings">キャッシュの再利用で節約したトークン (直近100件): {totalSavedTokens.toLocaleString()}</p>
+        )}
         <div className="table-container">
           <table>
             <thead>
@@ -60,6 +230,7 @@ export default function AdminHome() {
                 <th>作成日時</th>
                 <th>ステータス</th>
                 <th>合計トークン</th>
+                <th>節約トークン</th>
                 <th>エラー</th>
               </tr>
             </thead>
@@ -67,16 +238,91 @@ export default function AdminHome() {
               {history.map((item) => (
                 <tr key={item.id}>
                   <td>{item.id}</td>
-                  <td>{item.exam_title || '(タイトル未設定)'}</td>
+                  <td>
+                    {item.exam_title || '(タイトル未設定)'}
+                    {item.input_method && <small> ({item.input_method.split('+').map((m) => inputMethodLabels[m] || m).join(' + ')})</small>}
+                  </td>
                   <td>{new Date(item.created_at).toLocaleString()}</td>
                   <td><span className={`status-badge ${getStatusClass(item.processing_status)}`}>{item.processing_status}</span></td>
-                  <td>{item.total_tokens.toLocaleString()}</td>
+                  <td>
+                    {item.total_tokens.toLocaleString()}
+                    {item.regeneration_tokens > 0 && <small> (再生成: {item.regeneration_tokens.toLocaleString()})</small>}
+                  </td>
+                  <td>
+                    {item.saved_tokens > 0 ? item.saved_tokens.toLocaleString() : '-'}
+                    {item.cache_hit && <small> ({cacheHitLabels[item.cache_hit] || item.cache_hit}: #{item.cached_from_problem_id})</small>}
+                  </td>
                   <td title={item.error_message}>{item.error_message.substring(0, 50)}{item.error_message.length > 50 ? '...' : ''}</td>
                 </tr>
               ))}
             </tbody>
           </table>
         </div>
+
+        {usage.length > 0 && (
+          <>
+            <h2 className="section-title">コース別の利用状況</h2>
+            <div className="table-container">
+              <table>
+                <thead>
+                  <tr>
+                    <th>コース</th>
+                    <th>ジョブ数</th>
+                    <th>入力トークン</th>
+                    <th>出力トークン</th>
+                    <th>再生成トークン</th>
+                    <th>合計トークン</th>
+                    <th>節約トークン</th>
+                  </tr>
+                </thead>
+                <tbody>
+                  {usage.map((u) => (
+                    <tr key={u.course_id}>
+                      <td>{u.course_code} {u.course_name}</td>
+                      <td>{u.problems}</td>
+                      <td>{u.prompt_tokens.toLocaleString()}</td>
+                      <td>{u.candidates_tokens.toLocaleString()}</td>
+                      <td>{u.regeneration_tokens.toLocaleString()}</td>
+                      <td>{u.total_tokens.toLocaleString()}</td>
+                      <td>{u.saved_tokens.toLocaleString()}</td>
+                    </tr>
+                  ))}
+                </tbody>
+              </table>
+            </div>
+          </>
+        )}
+
+        <h2 className="section-title">デッドレターキュー ({deadLetters.length})</h2>
+        {deadLetters.length === 0 ? (
+          <p>失敗して再試行を打ち切られたジョブはありません。</p>
+        ) : (
+          <div className="table-container">
+            <button className="replay-button" onClick={() => replayDeadLetters()}>すべて再投入</button>
+            <table>
+              <thead>
+                <tr>
+                  <th>問題ID</th>
+                  <th>リトライ回数</th>
+                  <th>移動日時</th>
+                  <th>最後のエラー</th>
+                  <th></th>
+                </tr>
+              </thead>
+              <tbody>
+                {deadLetters.map((letter, index) => (
+                  <tr key={`${letter.problem_id}-${index}`}>
+                    <td>{letter.problem_id || '(不明)'}</td>
+                    <td>{letter.retry_count}</td>
+                    <td>{letter.dead_lettered_at ? new Date(letter.dead_lettered_at).toLocaleString() : ''}</td>
+                    <td title={letter.last_error}>{letter.last_error.substring(0, 80)}{letter.last_error.length > 80 ? '...' : ''}</td>
+                    <td>{letter.problem_id > 0 && <button className="replay-button" onClick={() => replayDeadLetters(letter.problem_id)}>再投入</button>}</td>
+                  </tr>
+                ))}
+              </tbody>
+            </table>
+          </div>
+        )}
       </main>
 
       <style jsx>{`
@@ -88,12 +334,18 @@ export default function AdminHome() {
         th { background-color: #f8f9fa; }
         tr:nth-child(even) { background-color: #f8f9fa; }
         .error { color: #dc3545; }
+        .savings { color: #28a745; font-weight: 600; }
         .status-badge { display: inline-block; padding: 0.25em 0.6em; font-size: 75%; font-weight: 700; line-height: 1; text-align: center; white-space: nowrap; vertical-align: baseline; border-radius: 0.375rem; color: #fff; }
         .status-completed { background-color: #28a745; }
         .status-failed { background-color: #dc3545; }
         .status-processing { background-color: #007bff; }
         .status-pending { background-color: #6c757d; }
+        .status-cancelled { background-color: #adb5bd; }
+        .status-awaiting-review { background-color: #6f42c1; }
+        .section-title { margin-top: 2rem; }
+        .course-filter { display: inline-block; margin-bottom: 1rem; }
+        .replay-button { margin-bottom: 0.5rem; padding: 0.25rem 0.75rem; border: 1px solid #007bff; background: #fff; color: #007bff; border-radius: 0.25rem; cursor: pointer; }
       `}</style>
     </div>
   );
-}
\ No newline at end of file
+}
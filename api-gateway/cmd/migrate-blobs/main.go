@@ -0,0 +1,157 @@
+// Command migrate-blobs moves uploaded files that older versions kept in the
+// database into the blob store: problems.raw_input_file becomes the first
+// problem_sources row of its problem, and problem_sources.content is replaced
+// by the key of the stored blob. The legacy columns are dropped once every
+// row has been moved. It is safe to run again after an interruption and does
+// nothing on a database created from the current db/init.sql.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	_ "github.com/lib/pq"
+	"github.com/your-username/edumint/api-gateway/internal/api"
+	"github.com/your-username/edumint/api-gateway/internal/blobstore"
+	"github.com/your-username/edumint/api-gateway/internal/storage"
+)
+
+func main() {
+	ctx := context.Background()
+	db := storage.ConnectDB()
+	defer db.Close()
+
+	blobs, err := blobstore.FromEnv(ctx)
+	if err != nil {
+		log.Fatalf("Failed to initialize blob store: %v", err)
+	}
+
+	if err := migrateSourceContent(ctx, db, blobs); err != nil {
+		log.Fatalf("Failed to migrate problem_sources.content: %v", err)
+	}
+	if err := migrateRawInputFiles(ctx, db, blobs); err != nil {
+		log.Fatalf("Failed to migrate problems.raw_input_file: %v", err)
+	}
+	log.Println("All uploaded files are in the blob store.")
+}
+
+// hasColumn reports whether table still has the legacy column.
+func hasColumn(ctx context.Context, db *sql.DB, table, column string) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (
+		SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2)`,
+		table, column).Scan(&exists)
+	return exists, err
+}
+
+// migrateSourceContent stores the content of every problem_sources row in the
+// blob store, records its key and size, and drops the content column.
+func migrateSourceContent(ctx context.Context, db *sql.DB, blobs blobstore.Store) error {
+	if ok, err := hasColumn(ctx, db, "problem_sources", "content"); err != nil || !ok {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, `ALTER TABLE problem_sources
+		ADD COLUMN IF NOT EXISTS content_sha256 CHAR(64),
+		ADD COLUMN IF NOT EXISTS size_bytes INT`); err != nil {
+		return err
+	}
+
+	ids, err := queryIDs(ctx, db, `SELECT id FROM problem_sources WHERE content_sha256 IS NULL ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		var content []byte
+		if err := db.QueryRowContext(ctx, `SELECT content FROM problem_sources WHERE id = $1`, id).Scan(&content); err != nil {
+			return err
+		}
+		key, err := blobs.Put(ctx, content)
+		if err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, `UPDATE problem_sources SET content_sha256 = $1, size_bytes = $2 WHERE id = $3`,
+			key, len(content), id); err != nil {
+			return err
+		}
+	}
+	log.Printf("Moved the content of %d problem sources into the blob store.", len(ids))
+
+	_, err = db.ExecContext(ctx, `ALTER TABLE problem_sources
+		ALTER COLUMN content_sha256 SET NOT NULL,
+		ALTER COLUMN size_bytes SET NOT NULL,
+		DROP COLUMN content`)
+	return err
+}
+
+// migrateRawInputFiles stores the file of every problem that has no
+// problem_sources row yet as its first lecture notes, and drops the
+// raw_input_file and raw_input_mime_type columns.
+func migrateRawInputFiles(ctx context.Context, db *sql.DB, blobs blobstore.Store) error {
+	if ok, err := hasColumn(ctx, db, "problems", "raw_input_file"); err != nil || !ok {
+		return err
+	}
+	hasMIMEType, err := hasColumn(ctx, db, "problems", "raw_input_mime_type")
+	if err != nil {
+		return err
+	}
+
+	ids, err := queryIDs(ctx, db, `SELECT id FROM problems p
+		WHERE raw_input_file IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM problem_sources s WHERE s.problem_id = p.id)
+		ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		var content []byte
+		var mimeType sql.NullString
+		if err := db.QueryRowContext(ctx, `SELECT raw_input_file FROM problems WHERE id = $1`, id).Scan(&content); err != nil {
+			return err
+		}
+		if hasMIMEType {
+			if err := db.QueryRowContext(ctx, `SELECT raw_input_mime_type FROM problems WHERE id = $1`, id).Scan(&mimeType); err != nil {
+				return err
+			}
+		}
+		// Before the type was recorded, PDF was the only file type accepted.
+		if !mimeType.Valid || mimeType.String == "" {
+			mimeType.String = api.MIMEPDF
+		}
+		key, err := blobs.Put(ctx, content)
+		if err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, `INSERT INTO problem_sources (problem_id, position, role, mime_type, content_sha256, size_bytes)
+			VALUES ($1, 0, $2, $3, $4, $5)`, id, api.SourceRoleLectureNotes, mimeType.String, key, len(content)); err != nil {
+			return err
+		}
+	}
+	log.Printf("Moved the uploaded files of %d problems into the blob store.", len(ids))
+
+	_, err = db.ExecContext(ctx, `ALTER TABLE problems
+		DROP COLUMN raw_input_file,
+		DROP COLUMN IF EXISTS raw_input_mime_type`)
+	return err
+}
+
+// queryIDs returns the IDs selected by query. The rows are read one by one
+// afterwards so that large files are not all held in memory at once.
+func queryIDs(ctx context.Context, db *sql.DB, query string) ([]int, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
 	"github.com/rs/cors"
 	"github.com/your-username/edumint/api-gateway/internal/api"
+	"github.com/your-username/edumint/api-gateway/internal/auth"
+	"github.com/your-username/edumint/api-gateway/internal/blobstore"
+	"github.com/your-username/edumint/api-gateway/internal/events"
 	"github.com/your-username/edumint/api-gateway/internal/queue"
 	"github.com/your-username/edumint/api-gateway/internal/storage"
 )
@@ -19,23 +24,112 @@ func main() {
 	defer db.Close()
 	defer queueClient.Close()
 
+	// Listen for status changes published by the database; without it the
+	// streaming endpoints are unavailable but polling keeps working.
+	hub, err := events.NewHub(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		log.Printf("Warning: event streaming disabled: %v", err)
+	} else {
+		defer hub.Close()
+	}
+
+	// Uploaded files are kept in the blob store; the database only references them.
+	blobs, err := blobstore.FromEnv(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to initialize blob store: %v", err)
+	}
+
+	// Every API request must carry an OIDC token or an API key.
+	authenticator, err := auth.FromEnv(context.Background(), db)
+	if err != nil {
+		log.Fatalf("Failed to initialize authentication: %v", err)
+	}
+	if key := os.Getenv("AUTH_BOOTSTRAP_API_KEY"); key != "" {
+		if err := authenticator.EnsureBootstrapKey(context.Background(), key); err != nil {
+			log.Fatalf("Failed to register bootstrap API key: %v", err)
+		}
+	}
+
 	// Create the main handler which holds the DB and Queue clients
-	handler := &api.Handler{DB: db, QueueClient: queueClient}
+	handler := &api.Handler{DB: db, QueueClient: queueClient, Events: hub, Blobs: blobs}
 
 	// Configure the main router using gorilla/mux
 	router := mux.NewRouter()
 
 	// Group all API routes under the /api/v1 path prefix
 	apiV1 := router.PathPrefix("/api/v1").Subrouter()
+	apiV1.Use(authenticator.Middleware)
 
 	// ============================================================================
 	// !! 修正箇所 !!
 	// 各エンドポイントで、POSTやGETに加えて、CORSプリフライトリクエスト用の
 	// http.MethodOptionsを許可するように設定します。
 	// ============================================================================
-	apiV1.HandleFunc("/generate", handler.GenerateProblemHandler).Methods(http.MethodPost, http.MethodOptions)
-	apiV1.HandleFunc("/problems/{id:[0-9]+}/status", handler.GetProblemStatusHandler).Methods(http.MethodGet, http.MethodOptions)
-	apiV1.HandleFunc("/admin/history", handler.GetHistoryHandler).Methods(http.MethodGet, http.MethodOptions)
+	requireCreate := auth.RequirePermission(auth.PermCreateProblems)
+	requireBlueprints := auth.RequirePermission(auth.PermCreateBlueprints)
+	apiV1.Handle("/generate", requireCreate(http.HandlerFunc(handler.GenerateProblemHandler))).Methods(http.MethodPost, http.MethodOptions)
+	apiV1.Handle("/generate/blueprint", requireBlueprints(http.HandlerFunc(handler.CreateFromBlueprintHandler))).Methods(http.MethodPost, http.MethodOptions)
+	apiV1.HandleFunc("/me", handler.GetMeHandler).Methods(http.MethodGet, http.MethodOptions)
+	apiV1.HandleFunc("/api-keys", handler.ListAPIKeysHandler).Methods(http.MethodGet, http.MethodOptions)
+	apiV1.HandleFunc("/api-keys", handler.CreateAPIKeyHandler).Methods(http.MethodPost, http.MethodOptions)
+	apiV1.HandleFunc("/api-keys/{id:[0-9]+}", handler.RevokeAPIKeyHandler).Methods(http.MethodDelete, http.MethodOptions)
+	apiV1.HandleFunc("/stream-tickets", handler.CreateStreamTicketHandler).Methods(http.MethodPost, http.MethodOptions)
+	apiV1.HandleFunc("/courses", handler.GetMyCoursesHandler).Methods(http.MethodGet, http.MethodOptions)
+	apiV1.HandleFunc("/invitations/accept", handler.AcceptInvitationHandler).Methods(http.MethodPost, http.MethodOptions)
+	apiV1.HandleFunc("/budgets", handler.GetMyBudgetsHandler).Methods(http.MethodGet, http.MethodOptions)
+
+	// A problem is only visible to its owner, the staff of its course and callers allowed to see every problem.
+	problems := apiV1.PathPrefix("/problems/{id:[0-9]+}").Subrouter()
+	problems.Use(handler.RequireProblemAccess)
+	problems.HandleFunc("/status", handler.GetProblemStatusHandler).Methods(http.MethodGet, http.MethodOptions)
+	problems.HandleFunc("/sources", handler.GetProblemSourcesHandler).Methods(http.MethodGet, http.MethodOptions)
+	problems.HandleFunc("/events", handler.StreamProblemEventsHandler).Methods(http.MethodGet, http.MethodOptions)
+	problems.HandleFunc("/structure", handler.GetStructureHandler).Methods(http.MethodGet, http.MethodOptions)
+	problems.HandleFunc("/structure", handler.UpdateStructureHandler).Methods(http.MethodPut, http.MethodOptions)
+	problems.HandleFunc("/structure/approve", handler.ApproveStructureHandler).Methods(http.MethodPost, http.MethodOptions)
+	problems.HandleFunc("/questions/{question_index}/regenerate", handler.RegenerateQuestionHandler).Methods(http.MethodPost, http.MethodOptions)
+	problems.HandleFunc("/regenerations", handler.GetRegenerationsHandler).Methods(http.MethodGet, http.MethodOptions)
+	problems.HandleFunc("/cancel", handler.CancelProblemHandler).Methods(http.MethodPost, http.MethodOptions)
+	problems.HandleFunc("", handler.CancelProblemHandler).Methods(http.MethodDelete, http.MethodOptions)
+
+	// Course staff see the jobs and token usage of their course.
+	courses := apiV1.PathPrefix("/courses/{course_id:[0-9]+}").Subrouter()
+	courses.Use(handler.RequireCourseStaff)
+	courses.HandleFunc("/history", handler.GetCourseHistoryHandler).Methods(http.MethodGet, http.MethodOptions)
+	courses.HandleFunc("/usage", handler.GetCourseUsageHandler).Methods(http.MethodGet, http.MethodOptions)
+	courses.HandleFunc("/members", handler.GetCourseMembersHandler).Methods(http.MethodGet, http.MethodOptions)
+
+	// Organization admins manage the members and courses of their organization.
+	organizations := apiV1.PathPrefix("/organizations/{org_id:[0-9]+}").Subrouter()
+	organizations.Use(handler.RequireOrganizationAdmin)
+	organizations.HandleFunc("/members", handler.GetOrganizationMembersHandler).Methods(http.MethodGet, http.MethodOptions)
+	organizations.HandleFunc("/members/{user_id:[0-9]+}", handler.PutOrganizationMemberHandler).Methods(http.MethodPut, http.MethodOptions)
+	organizations.HandleFunc("/members/{user_id:[0-9]+}", handler.DeleteOrganizationMemberHandler).Methods(http.MethodDelete, http.MethodOptions)
+	organizations.HandleFunc("/invitations", handler.GetOrganizationInvitationsHandler).Methods(http.MethodGet, http.MethodOptions)
+	organizations.HandleFunc("/invitations", handler.CreateOrganizationInvitationHandler).Methods(http.MethodPost, http.MethodOptions)
+	organizations.HandleFunc("/invitations/{id:[0-9]+}", handler.DeleteOrganizationInvitationHandler).Methods(http.MethodDelete, http.MethodOptions)
+	organizations.HandleFunc("/courses", handler.GetOrganizationCoursesHandler).Methods(http.MethodGet, http.MethodOptions)
+	organizations.HandleFunc("/courses", handler.CreateCourseHandler).Methods(http.MethodPost, http.MethodOptions)
+	organizations.HandleFunc("/courses/{course_id:[0-9]+}/members/{user_id:[0-9]+}", handler.PutCourseMemberHandler).Methods(http.MethodPut, http.MethodOptions)
+	organizations.HandleFunc("/courses/{course_id:[0-9]+}/members/{user_id:[0-9]+}", handler.DeleteCourseMemberHandler).Methods(http.MethodDelete, http.MethodOptions)
+
+	// The admin endpoints expose every job and user.
+	admin := apiV1.PathPrefix("/admin").Subrouter()
+	admin.Use(auth.RequirePermission(auth.PermAdmin))
+	admin.HandleFunc("/history", handler.GetHistoryHandler).Methods(http.MethodGet, http.MethodOptions)
+	admin.HandleFunc("/events", handler.StreamAdminEventsHandler).Methods(http.MethodGet, http.MethodOptions)
+	admin.HandleFunc("/problems/{id:[0-9]+}/attempts", handler.GetProblemAttemptsHandler).Methods(http.MethodGet, http.MethodOptions)
+	admin.HandleFunc("/problems/{id:[0-9]+}/sections", handler.GetProblemSectionsHandler).Methods(http.MethodGet, http.MethodOptions)
+	admin.HandleFunc("/dead-letters", handler.GetDeadLettersHandler).Methods(http.MethodGet, http.MethodOptions)
+	admin.HandleFunc("/dead-letters/replay", handler.ReplayDeadLettersHandler).Methods(http.MethodPost, http.MethodOptions)
+	admin.HandleFunc("/users", handler.GetUsersHandler).Methods(http.MethodGet, http.MethodOptions)
+	admin.HandleFunc("/users/{id:[0-9]+}/role", handler.UpdateUserRoleHandler).Methods(http.MethodPut, http.MethodOptions)
+	admin.HandleFunc("/usage", handler.GetUsageHandler).Methods(http.MethodGet, http.MethodOptions)
+	admin.HandleFunc("/organizations", handler.GetOrganizationsHandler).Methods(http.MethodGet, http.MethodOptions)
+	admin.HandleFunc("/organizations", handler.CreateOrganizationHandler).Methods(http.MethodPost, http.MethodOptions)
+	admin.HandleFunc("/budgets", handler.GetBudgetsHandler).Methods(http.MethodGet, http.MethodOptions)
+	admin.HandleFunc("/budgets", handler.PutBudgetHandler).Methods(http.MethodPut, http.MethodOptions)
+	admin.HandleFunc("/budgets/{id:[0-9]+}", handler.DeleteBudgetHandler).Methods(http.MethodDelete, http.MethodOptions)
 
 	// Configure CORS middleware using rs/cors
 	c := cors.New(cors.Options{
@@ -43,7 +137,7 @@ func main() {
 		AllowedOrigins: []string{"http://localhost:3000", "http://localhost:3001"},
 
 		// 許可するHTTPメソッド
-		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodOptions},
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions},
 
 		// 許可するHTTPヘッダー
 		AllowedHeaders: []string{"Content-Type", "Authorization"},
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/your-username/edumint/api-gateway/internal/auth"
+)
+
+// RequireProblemAccess is the middleware of the /problems/{id} routes. It lets
+// through the problem's owner, the staff of its course and callers allowed to
+// see every problem, and answers 404 to everyone else so that problem IDs
+// cannot be probed.
+func (h *Handler) RequireProblemAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid Problem ID", http.StatusBadRequest)
+			return
+		}
+
+		caller := auth.FromContext(r.Context())
+		var ownerID sql.NullInt64
+		var courseStaff bool
+		err = h.DB.QueryRow(`SELECT owner_id, course_id IS NOT NULL AND is_course_staff(course_id, $2) FROM problems WHERE id = $1`,
+			id, caller.UserID).Scan(&ownerID, &courseStaff)
+		if err == sql.ErrNoRows || (err == nil && !courseStaff && !canAccessProblem(caller, ownerID)) {
+			http.Error(w, "Problem not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error checking access to problem %d: %v", id, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// canAccessProblem reports whether caller may see a problem owned by ownerID.
+// Problems created before owners were recorded are only visible to admins.
+func canAccessProblem(caller *auth.Identity, ownerID sql.NullInt64) bool {
+	if caller.Can(auth.PermReadAllProblems) {
+		return true
+	}
+	return ownerID.Valid && int(ownerID.Int64) == caller.UserID
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/your-username/edumint/api-gateway/internal/auth"
+)
+
+// maxAPIKeyNameLength limits the label users give their API keys.
+const maxAPIKeyNameLength = 100
+
+// APIKey is an API key of the caller. The key itself is only returned once, on creation.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// streamPathRegex matches the event stream endpoints that stream tickets can open.
+var streamPathRegex = regexp.MustCompile(`^/api/v1/(problems/[0-9]+|admin)/events$`)
+
+// GetMeHandler returns the authenticated caller.
+func (h *Handler) GetMeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(auth.FromContext(r.Context()))
+}
+
+// ListAPIKeysHandler lists the caller's unrevoked API keys.
+func (h *Handler) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	caller := auth.FromContext(r.Context())
+	rows, err := h.DB.Query(`SELECT id, name, prefix, created_at, last_used_at FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC`, caller.UserID)
+	if err != nil {
+		log.Printf("Error listing API keys of user %d: %v", caller.UserID, err)
+		http.Error(w, "Failed to retrieve API keys", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &k.CreatedAt, &lastUsed); err != nil {
+			log.Printf("Error scanning API key row: %v", err)
+			continue
+		}
+		if lastUsed.Valid {
+			k.LastUsedAt = &lastUsed.Time
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over API key rows: %v", err)
+		http.Error(w, "Failed to process API keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// CreateAPIKeyHandler issues a new API key for the caller, e.g. for scripts.
+// An optional JSON body {"name": "..."} labels the key.
+func (h *Handler) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	caller := auth.FromContext(r.Context())
+	var req struct {
+		Name string `json:"name"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if len(req.Name) > maxAPIKeyNameLength {
+		http.Error(w, "invalid request: name must be at most 100 characters", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		req.Name = "default"
+	}
+
+	key, err := auth.NewAPIKey()
+	if err != nil {
+		log.Printf("Error creating API key: %v", err)
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+	created := APIKey{Name: req.Name, Prefix: auth.DisplayPrefix(key), Key: key}
+	err = h.DB.QueryRow(`INSERT INTO api_keys (user_id, name, prefix, key_hash) VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`, caller.UserID, created.Name, created.Prefix, auth.HashAPIKey(key),
+	).Scan(&created.ID, &created.CreatedAt)
+	if err != nil {
+		log.Printf("Error storing API key for user %d: %v", caller.UserID, err)
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %d created API key %d (%s...).", caller.UserID, created.ID, created.Prefix)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// RevokeAPIKeyHandler revokes one of the caller's API keys. Requests made with
+// it are rejected from then on.
+func (h *Handler) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	caller := auth.FromContext(r.Context())
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.DB.Exec(`UPDATE api_keys SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, id, caller.UserID)
+	if err != nil {
+		log.Printf("Error revoking API key %d: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("User %d revoked API key %d.", caller.UserID, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateStreamTicketHandler issues a single-use ticket, valid for one minute,
+// that opens the event stream given in the JSON body {"path": "/api/v1/problems/42/events"}
+// as the caller. Browsers pass it as ?ticket= because EventSource cannot set
+// the Authorization header; the stream itself still checks the caller's access.
+func (h *Handler) CreateStreamTicketHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !streamPathRegex.MatchString(req.Path) {
+		http.Error(w, "invalid request: path must be the path of an event stream, e.g. /api/v1/problems/42/events", http.StatusBadRequest)
+		return
+	}
+
+	caller := auth.FromContext(r.Context())
+	ticket, expiresAt, err := auth.NewStreamTicket(r.Context(), h.DB, caller, req.Path)
+	if err != nil {
+		log.Printf("Error creating stream ticket for user %d: %v", caller.UserID, err)
+		http.Error(w, "Failed to create stream ticket", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"ticket": ticket, "expires_at": expiresAt})
+}
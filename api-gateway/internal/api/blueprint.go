@@ -0,0 +1,125 @@
+package api
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// The blueprint types mirror models.ProblemStructure in the worker, which reads
+// the stored structure back when it generates the problems.
+
+// ProblemStructure is an exam blueprint: the layout of the exam to generate.
+type ProblemStructure struct {
+	ExamMeta  ExamMeta         `json:"exam_meta"`
+	Structure StructureSection `json:"structure"`
+}
+type ExamMeta struct {
+	ExamTitle             string   `json:"exam_title"`
+	ExamDuration          *int     `json:"exam_duration,omitempty"`
+	OpenBook              bool     `json:"open_book"`
+	AllowedMaterials      []string `json:"allowed_materials,omitempty"`
+	QuestionFormatIsLatex bool     `json:"question_format_is_latex"`
+	AnswerFormatIsLatex   bool     `json:"answer_format_is_latex"`
+}
+type StructureSection struct {
+	MajorSections []MajorSection `json:"major_sections"`
+}
+type MajorSection struct {
+	SectionIndex string        `json:"section_index,omitempty"`
+	SectionTitle string        `json:"section_title,omitempty"`
+	SubQuestions []SubQuestion `json:"sub_questions"`
+}
+type SubQuestion struct {
+	QuestionIndex string   `json:"question_index,omitempty"`
+	Topic         string   `json:"topic,omitempty"`
+	Keywords      []string `json:"keywords,omitempty"`
+	Difficulty    string   `json:"difficulty,omitempty"`
+}
+
+// BlueprintRequest is the JSON body accepted by CreateFromBlueprintHandler: a
+// ProblemStructure document plus optional generation options and course.
+type BlueprintRequest struct {
+	ProblemStructure
+	Options  GenerationOptions `json:"options"`
+	CourseID int               `json:"course_id,omitempty"`
+}
+
+// maxExamTitleLength matches the exam_title column.
+const maxExamTitleLength = 255
+
+var validBlueprintDifficulties = []string{"easy", "medium", "hard"}
+
+// assignIndices numbers the sections and sub-questions that have no index the
+// way the worker numbers extracted structures: sections "1", "2", ... and
+// sub-questions "<section>-1", "<section>-2", ... by position. Indices given
+// in the blueprint are kept, so Validate still rejects duplicates.
+func (ps *ProblemStructure) assignIndices() {
+	for i := range ps.Structure.MajorSections {
+		section := &ps.Structure.MajorSections[i]
+		if section.SectionIndex == "" {
+			section.SectionIndex = fmt.Sprint(i + 1)
+		}
+		for j := range section.SubQuestions {
+			if section.SubQuestions[j].QuestionIndex == "" {
+				section.SubQuestions[j].QuestionIndex = fmt.Sprintf("%s-%d", section.SectionIndex, j+1)
+			}
+		}
+	}
+}
+
+// Validate checks the blueprint and returns a *ValidationError if it is unusable.
+func (ps *ProblemStructure) Validate() error {
+	verr := &ValidationError{}
+	if ps.ExamMeta.ExamTitle == "" {
+		verr.add("exam_meta.exam_title is required")
+	} else if utf8.RuneCountInString(ps.ExamMeta.ExamTitle) > maxExamTitleLength {
+		verr.add("exam_meta.exam_title must be at most %d characters", maxExamTitleLength)
+	}
+	if d := ps.ExamMeta.ExamDuration; d != nil && *d <= 0 {
+		verr.add("exam_meta.exam_duration must be a positive number of minutes")
+	}
+	if len(ps.Structure.MajorSections) == 0 {
+		verr.add("structure.major_sections must contain at least one section")
+	}
+
+	total := 0
+	seen := map[string]bool{}
+	seenSections := map[string]bool{}
+	for i, section := range ps.Structure.MajorSections {
+		if section.SectionIndex == "" {
+			verr.add("structure.major_sections[%d].section_index is required", i)
+		} else if seenSections[section.SectionIndex] {
+			verr.add("structure.major_sections[%d].section_index '%s' is used more than once", i, section.SectionIndex)
+		}
+		seenSections[section.SectionIndex] = true
+		if len(section.SubQuestions) == 0 {
+			verr.add("structure.major_sections[%d].sub_questions must contain at least one question", i)
+		}
+		for j, sq := range section.SubQuestions {
+			total++
+			field := func(name string) string {
+				return fmt.Sprintf("structure.major_sections[%d].sub_questions[%d].%s", i, j, name)
+			}
+			if sq.Topic == "" {
+				verr.add("%s is required", field("topic"))
+			}
+			if sq.Difficulty != "" && !contains(validBlueprintDifficulties, sq.Difficulty) {
+				verr.add("%s must be one of easy, medium, hard", field("difficulty"))
+			}
+			if sq.QuestionIndex == "" {
+				verr.add("%s is required", field("question_index"))
+			} else if seen[sq.QuestionIndex] {
+				verr.add("%s '%s' is used more than once", field("question_index"), sq.QuestionIndex)
+			}
+			seen[sq.QuestionIndex] = true
+		}
+	}
+	if total > maxNumQuestions {
+		verr.add("a blueprint may contain at most %d sub-questions, got %d", maxNumQuestions, total)
+	}
+
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssignIndices(t *testing.T) {
+	ps := &ProblemStructure{Structure: StructureSection{MajorSections: []MajorSection{
+		{SubQuestions: []SubQuestion{{Topic: "a"}, {QuestionIndex: "A-9", Topic: "b"}}},
+		{SectionIndex: "II", SubQuestions: []SubQuestion{{Topic: "c"}}},
+	}}}
+	ps.assignIndices()
+
+	var got []string
+	for _, section := range ps.Structure.MajorSections {
+		got = append(got, section.SectionIndex)
+		for _, sq := range section.SubQuestions {
+			got = append(got, sq.QuestionIndex)
+		}
+	}
+	if want := []string{"1", "1-1", "A-9", "II", "II-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("indices = %v, want %v", got, want)
+	}
+}
+
+func TestProblemStructureValidate(t *testing.T) {
+	valid := func() *ProblemStructure {
+		return &ProblemStructure{
+			ExamMeta: ExamMeta{ExamTitle: "線形代数"},
+			Structure: StructureSection{MajorSections: []MajorSection{
+				{SectionIndex: "1", SubQuestions: []SubQuestion{{QuestionIndex: "1-1", Topic: "行列の積", Difficulty: "easy"}}},
+				{SectionIndex: "2", SubQuestions: []SubQuestion{{QuestionIndex: "2-1", Topic: "固有値"}}},
+			}},
+		}
+	}
+	zero := 0
+
+	tests := []struct {
+		name   string
+		modify func(ps *ProblemStructure)
+		// want lists a substring of each expected problem, in order.
+		want []string
+	}{
+		{name: "valid", modify: func(ps *ProblemStructure) {}},
+		{
+			name:   "missing title",
+			modify: func(ps *ProblemStructure) { ps.ExamMeta.ExamTitle = "" },
+			want:   []string{"exam_meta.exam_title is required"},
+		},
+		{
+			name:   "title too long",
+			modify: func(ps *ProblemStructure) { ps.ExamMeta.ExamTitle = strings.Repeat("題", maxExamTitleLength+1) },
+			want:   []string{"exam_meta.exam_title must be at most"},
+		},
+		{
+			name:   "non-positive duration",
+			modify: func(ps *ProblemStructure) { ps.ExamMeta.ExamDuration = &zero },
+			want:   []string{"exam_meta.exam_duration"},
+		},
+		{
+			name:   "no sections",
+			modify: func(ps *ProblemStructure) { ps.Structure.MajorSections = nil },
+			want:   []string{"structure.major_sections must contain"},
+		},
+		{
+			name:   "missing section index",
+			modify: func(ps *ProblemStructure) { ps.Structure.MajorSections[1].SectionIndex = "" },
+			want:   []string{"major_sections[1].section_index is required"},
+		},
+		{
+			name:   "duplicate section index",
+			modify: func(ps *ProblemStructure) { ps.Structure.MajorSections[1].SectionIndex = "1" },
+			want:   []string{"major_sections[1].section_index '1' is used more than once"},
+		},
+		{
+			name: "duplicate question index across sections",
+			modify: func(ps *ProblemStructure) {
+				ps.Structure.MajorSections[1].SubQuestions[0].QuestionIndex = "1-1"
+			},
+			want: []string{"major_sections[1].sub_questions[0].question_index '1-1' is used more than once"},
+		},
+		{
+			name: "question problems",
+			modify: func(ps *ProblemStructure) {
+				sq := &ps.Structure.MajorSections[0].SubQuestions[0]
+				sq.QuestionIndex, sq.Topic, sq.Difficulty = "", "", "impossible"
+			},
+			want: []string{
+				"sub_questions[0].topic is required",
+				"sub_questions[0].difficulty must be one of",
+				"sub_questions[0].question_index is required",
+			},
+		},
+		{
+			name: "too many questions",
+			modify: func(ps *ProblemStructure) {
+				section := &ps.Structure.MajorSections[0]
+				for len(section.SubQuestions) < maxNumQuestions {
+					section.SubQuestions = append(section.SubQuestions, SubQuestion{Topic: "t"})
+				}
+				ps.assignIndices()
+			},
+			want: []string{"at most"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := valid()
+			tt.modify(ps)
+			err := ps.Validate()
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Validate() error = %v, want a *ValidationError", err)
+			}
+			if len(verr.Problems) != len(tt.want) {
+				t.Fatalf("Validate() problems = %q, want %d matching %q", verr.Problems, len(tt.want), tt.want)
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(verr.Problems[i], want) {
+					t.Errorf("problem %d = %q, want it to contain %q", i, verr.Problems[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/your-username/edumint/api-gateway/internal/auth"
+)
+
+// Scopes and periods of token_budgets. A budget with ScopeID 0 is the default
+// for every user, course or organization without a budget of its own.
+const (
+	BudgetScopeUser         = "user"
+	BudgetScopeCourse       = "course"
+	BudgetScopeOrganization = "organization"
+	BudgetPeriodDaily       = "daily"
+	BudgetPeriodMonthly     = "monthly"
+)
+
+// Rough token estimates for the pre-flight budget check. They only need to be
+// of the right magnitude: the worker checks the real usage again after
+// extraction, before any generation tokens are spent.
+const (
+	// textBytesPerToken errs on the high side: Japanese text is about one
+	// token per character, which is three bytes in UTF-8.
+	textBytesPerToken = 3
+	// documentBytesPerToken applies to PDF, DOCX and PPTX, which are
+	// compressed and often mostly images.
+	documentBytesPerToken = 30
+	// tokensPerQuestion covers the prompt share and the output of one generated question.
+	tokensPerQuestion = 1000
+	// defaultEstimatedQuestions is assumed when the model decides the number of questions.
+	defaultEstimatedQuestions = 10
+)
+
+// TokenBudget is a daily or monthly token limit of a user, course or organization.
+type TokenBudget struct {
+	ID         int       `json:"id"`
+	Scope      string    `json:"scope"`
+	ScopeID    int       `json:"scope_id"`
+	Period     string    `json:"period"`
+	TokenLimit int64     `json:"token_limit"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// BudgetUsage is a budget that applies to a job, with the tokens used in the current period.
+type BudgetUsage struct {
+	BudgetID        int       `json:"budget_id"`
+	Scope           string    `json:"scope"`
+	ScopeID         int       `json:"scope_id"`
+	Period          string    `json:"period"`
+	TokenLimit      int64     `json:"token_limit"`
+	UsedTokens      int64     `json:"used_tokens"`
+	RemainingTokens int64     `json:"remaining_tokens"`
+	ResetsAt        time.Time `json:"resets_at"`
+}
+
+// estimateJobTokens estimates the tokens a generation job will use for its
+// input and numQuestions questions (0 when the model decides).
+func estimateJobTokens(text string, sources []SourceUpload, numQuestions int) int64 {
+	estimate := int64(len(text) / textBytesPerToken)
+	for _, src := range sources {
+		switch src.MIMEType {
+		case MIMEPDF, MIMEDOCX, MIMEPPTX:
+			estimate += int64(len(src.Data) / documentBytesPerToken)
+		default:
+			estimate += int64(len(src.Data) / textBytesPerToken)
+		}
+	}
+	if numQuestions == 0 {
+		numQuestions = defaultEstimatedQuestions
+	}
+	return estimate + int64(numQuestions*tokensPerQuestion)
+}
+
+// checkTokenBudget writes a 429 response and returns false if a job of userID
+// in courseID (either may be 0 for none) estimated to use estimate tokens does
+// not fit in one of the budgets of the user, the course or its organization.
+func (h *Handler) checkTokenBudget(w http.ResponseWriter, userID, courseID int, estimate int64) bool {
+	var b BudgetUsage
+	err := h.DB.QueryRow(`SELECT scope, scope_id, period, token_limit, used_tokens, resets_at
+		FROM token_budget_usage(NULLIF($1, 0), NULLIF($2, 0))
+		WHERE used_tokens + $3 > token_limit
+		ORDER BY resets_at DESC LIMIT 1`, userID, courseID, estimate,
+	).Scan(&b.Scope, &b.ScopeID, &b.Period, &b.TokenLimit, &b.UsedTokens, &b.ResetsAt)
+	if err == sql.ErrNoRows {
+		return true
+	}
+	if err != nil {
+		log.Printf("Error checking token budgets of user %d in course %d: %v", userID, courseID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+
+	var msg string
+	if b.UsedTokens >= b.TokenLimit {
+		msg = fmt.Sprintf("Token budget exhausted: the %s budget of %d tokens for %s %d is used up",
+			b.Period, b.TokenLimit, b.Scope, b.ScopeID)
+	} else {
+		msg = fmt.Sprintf("Token budget exceeded: this job is estimated to use about %d tokens, but only %d of the %s budget of %d tokens for %s %d are left",
+			estimate, b.TokenLimit-b.UsedTokens, b.Period, b.TokenLimit, b.Scope, b.ScopeID)
+	}
+	retryAfter := int(math.Ceil(time.Until(b.ResetsAt).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	http.Error(w, fmt.Sprintf("%s. It resets at %s.", msg, b.ResetsAt.UTC().Format(time.RFC3339)), http.StatusTooManyRequests)
+	return false
+}
+
+// GetMyBudgetsHandler reports the budgets that apply to the caller's jobs and
+// how much of each is used, including those of the course given by the
+// optional course_id query parameter and of its organization.
+func (h *Handler) GetMyBudgetsHandler(w http.ResponseWriter, r *http.Request) {
+	caller := auth.FromContext(r.Context())
+	courseID := 0
+	if v := r.URL.Query().Get("course_id"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid course_id", http.StatusBadRequest)
+			return
+		}
+		courseID = n
+	}
+	if !h.checkCourseMember(w, caller, courseID) {
+		return
+	}
+
+	rows, err := h.DB.Query(`SELECT budget_id, scope, scope_id, period, token_limit, used_tokens, resets_at
+		FROM token_budget_usage($1, NULLIF($2, 0))`, caller.UserID, courseID)
+	if err != nil {
+		log.Printf("Error querying token budgets of user %d: %v", caller.UserID, err)
+		http.Error(w, "Failed to retrieve budgets", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	usage := []BudgetUsage{}
+	for rows.Next() {
+		var b BudgetUsage
+		if err := rows.Scan(&b.BudgetID, &b.Scope, &b.ScopeID, &b.Period, &b.TokenLimit, &b.UsedTokens, &b.ResetsAt); err != nil {
+			log.Printf("Error scanning budget usage row: %v", err)
+			continue
+		}
+		b.RemainingTokens = b.TokenLimit - b.UsedTokens
+		if b.RemainingTokens < 0 {
+			b.RemainingTokens = 0
+		}
+		usage = append(usage, b)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over budget usage rows: %v", err)
+		http.Error(w, "Failed to process budgets", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
+
+// GetBudgetsHandler lists every configured token budget.
+func (h *Handler) GetBudgetsHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(`SELECT id, scope, scope_id, period, token_limit, updated_at
+		FROM token_budgets ORDER BY scope, scope_id, period`)
+	if err != nil {
+		log.Printf("Error listing token budgets: %v", err)
+		http.Error(w, "Failed to retrieve budgets", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	budgets := []TokenBudget{}
+	for rows.Next() {
+		var b TokenBudget
+		if err := rows.Scan(&b.ID, &b.Scope, &b.ScopeID, &b.Period, &b.TokenLimit, &b.UpdatedAt); err != nil {
+			log.Printf("Error scanning budget row: %v", err)
+			continue
+		}
+		budgets = append(budgets, b)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over budget rows: %v", err)
+		http.Error(w, "Failed to process budgets", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(budgets)
+}
+
+// PutBudgetHandler sets the token limit of a budget from a JSON body
+// {"scope": "user", "scope_id": 3, "period": "daily", "token_limit": 200000},
+// replacing the limit if that budget already exists.
+func (h *Handler) PutBudgetHandler(w http.ResponseWriter, r *http.Request) {
+	var req TokenBudget
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	verr := &ValidationError{}
+	if !contains([]string{BudgetScopeUser, BudgetScopeCourse, BudgetScopeOrganization}, req.Scope) {
+		verr.add("scope must be user, course or organization")
+	}
+	if req.ScopeID < 0 {
+		verr.add("scope_id must be an ID, or 0 for the default budget")
+	}
+	if req.Period != BudgetPeriodDaily && req.Period != BudgetPeriodMonthly {
+		verr.add("period must be daily or monthly")
+	}
+	if req.TokenLimit < 0 {
+		verr.add("token_limit must not be negative")
+	}
+	if len(verr.Problems) > 0 {
+		http.Error(w, verr.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := h.DB.QueryRow(`INSERT INTO token_budgets (scope, scope_id, period, token_limit) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (scope, scope_id, period) DO UPDATE SET token_limit = EXCLUDED.token_limit, updated_at = NOW()
+		RETURNING id, updated_at`, req.Scope, req.ScopeID, req.Period, req.TokenLimit).Scan(&req.ID, &req.UpdatedAt)
+	if err != nil {
+		log.Printf("Error setting %s budget of %s %d: %v", req.Period, req.Scope, req.ScopeID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	caller := auth.FromContext(r.Context())
+	log.Printf("User %d set the %s budget of %s %d to %d tokens.", caller.UserID, req.Period, req.Scope, req.ScopeID, req.TokenLimit)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
+
+// DeleteBudgetHandler removes a token budget; a specific budget falls back to the default.
+func (h *Handler) DeleteBudgetHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid Budget ID", http.StatusBadRequest)
+		return
+	}
+	res, err := h.DB.Exec(`DELETE FROM token_budgets WHERE id = $1`, id)
+	if err != nil {
+		log.Printf("Error deleting token budget %d: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Budget not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEstimateJobTokens(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		sources      []SourceUpload
+		numQuestions int
+		want         int64
+	}{
+		{name: "empty input uses the default question count", want: defaultEstimatedQuestions * tokensPerQuestion},
+		{name: "text", text: "0123456789", numQuestions: 2, want: 10/textBytesPerToken + 2*tokensPerQuestion},
+		{
+			name: "documents are denser than text",
+			sources: []SourceUpload{
+				{MIMEType: MIMEPDF, Data: bytes.Repeat([]byte("x"), 300)},
+				{MIMEType: MIMEDOCX, Data: bytes.Repeat([]byte("x"), 60)},
+				{MIMEType: MIMEPPTX, Data: bytes.Repeat([]byte("x"), 90)},
+			},
+			numQuestions: 1,
+			want:         450/documentBytesPerToken + tokensPerQuestion,
+		},
+		{
+			name: "text files count like text",
+			sources: []SourceUpload{
+				{MIMEType: MIMEMarkdown, Data: bytes.Repeat([]byte("x"), 30)},
+				{MIMEType: MIMETeX, Data: bytes.Repeat([]byte("x"), 30)},
+			},
+			numQuestions: 1,
+			want:         60/textBytesPerToken + tokensPerQuestion,
+		},
+		{
+			name:         "text and files are added up",
+			text:         "012345",
+			sources:      []SourceUpload{{MIMEType: MIMEPDF, Data: bytes.Repeat([]byte("x"), 60)}},
+			numQuestions: 3,
+			want:         6/textBytesPerToken + 60/documentBytesPerToken + 3*tokensPerQuestion,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimateJobTokens(tt.text, tt.sources, tt.numQuestions); got != tt.want {
+				t.Errorf("estimateJobTokens() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
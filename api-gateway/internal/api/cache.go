@@ -0,0 +1,150 @@
+package api
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/your-username/edumint/api-gateway/internal/blobstore"
+)
+
+// Cache modes accepted by GenerateProblemHandler in the "cache" field. Jobs
+// with the same input hash are only reused when the caller opts in.
+const (
+	// CacheNone always runs the whole pipeline (the default).
+	CacheNone = "none"
+	// CacheStructure reuses the extracted structure of an earlier job, so only
+	// problem generation runs.
+	CacheStructure = "structure"
+	// CacheFull reuses the complete result of an earlier job and falls back to
+	// CacheStructure when no completed result exists. Jobs in review mode only
+	// reuse the structure, since their questions must come from the approved one.
+	CacheFull = "full"
+)
+
+// validateCacheMode checks the "cache" field of a generate request.
+func validateCacheMode(mode string) error {
+	switch mode {
+	case "", CacheNone, CacheStructure, CacheFull:
+		return nil
+	}
+	return fmt.Errorf("invalid request: cache must be one of %s, %s, %s", CacheNone, CacheStructure, CacheFull)
+}
+
+// inputHash identifies the input of a job for deduplication: the normalized
+// text or the ordered source files (role, type and content hash) plus the
+// generation options that change the output. The review flag only pauses the
+// job, so it is left out.
+func inputHash(text string, sources []SourceUpload, opts GenerationOptions) string {
+	h := sha256.New()
+	h.Write([]byte("v1\n"))
+	if len(sources) == 0 {
+		h.Write([]byte("text\n"))
+		h.Write([]byte(normalizeText(text)))
+	} else {
+		h.Write([]byte("files\n"))
+		for _, src := range sources {
+			fmt.Fprintf(h, "%s\x00%s\x00%s\n", src.Role, src.MIMEType, blobstore.Key(src.Data))
+		}
+	}
+
+	opts.Review = false
+	opts.QuestionTypes = append([]string(nil), opts.QuestionTypes...)
+	sort.Strings(opts.QuestionTypes)
+	optionsJSON, _ := json.Marshal(opts)
+	h.Write([]byte("\noptions\n"))
+	h.Write(optionsJSON)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeText removes differences that do not change the meaning of pasted
+// text: line endings, trailing spaces and leading or trailing blank lines.
+func normalizeText(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// sameTenant restricts the jobs a cache lookup may reuse to those of the same
+// course as the new job p, or to the owner's own personal jobs, so results are
+// never shared across courses or organizations.
+const sameTenant = `(src.course_id = p.course_id OR (src.course_id IS NULL AND p.course_id IS NULL AND src.owner_id = p.owner_id))`
+
+// applyCache copies the result of the most recent earlier job with the same
+// input hash and tenant into the new job, according to mode. It returns the
+// cache hit ("full", "structure" or "" for none) and the ID of the reused job.
+// The tokens the reused job spent on the copied stages are recorded as saved.
+// In review mode a reused structure puts the job straight into
+// 'awaiting_review', so it still has to be approved before generation.
+func (h *Handler) applyCache(problemID int, hash, mode string, review bool) (string, int, error) {
+	if mode == CacheFull && !review {
+		// Tokens saved by the reused job itself (a cached structure) are saved again.
+		query := `
+			UPDATE problems p SET
+				exam_title = src.exam_title, duration_minutes = src.duration_minutes, is_open_book = src.is_open_book,
+				allowed_materials = src.allowed_materials, question_format_is_latex = src.question_format_is_latex,
+				answer_format_is_latex = src.answer_format_is_latex, major_sections = src.major_sections,
+				generated_questions = src.generated_questions, coverage_report = src.coverage_report,
+				input_method = src.input_method,
+				structure_prompt_tokens = 0, structure_candidates_tokens = 0,
+				generation_prompt_tokens = 0, generation_candidates_tokens = 0,
+				extraction_status = 'skipped', generation_status = 'skipped', processing_status = 'completed',
+				cache_hit = 'full', cached_from_problem_id = src.id,
+				saved_prompt_tokens = COALESCE(src.structure_prompt_tokens, 0) + COALESCE(src.generation_prompt_tokens, 0) + src.saved_prompt_tokens,
+				saved_candidates_tokens = COALESCE(src.structure_candidates_tokens, 0) + COALESCE(src.generation_candidates_tokens, 0) + src.saved_candidates_tokens
+			FROM (
+				SELECT src.* FROM problems src JOIN problems p ON p.id = $2
+				WHERE src.input_hash = $1 AND src.id <> $2 AND src.processing_status = 'completed' AND src.generation_status = 'completed'
+					AND ` + sameTenant + `
+				ORDER BY src.created_at DESC LIMIT 1
+			) src
+			WHERE p.id = $2
+			RETURNING src.id`
+		var sourceID int
+		err := h.DB.QueryRow(query, hash, problemID).Scan(&sourceID)
+		if err == nil {
+			return CacheFull, sourceID, nil
+		}
+		if err != sql.ErrNoRows {
+			return "", 0, err
+		}
+	}
+	if mode == CacheFull || mode == CacheStructure {
+		// Only structures that were actually extracted, so the saved tokens are real.
+		query := `
+			UPDATE problems p SET
+				exam_title = src.exam_title, duration_minutes = src.duration_minutes, is_open_book = src.is_open_book,
+				allowed_materials = src.allowed_materials, question_format_is_latex = src.question_format_is_latex,
+				answer_format_is_latex = src.answer_format_is_latex, major_sections = src.major_sections,
+				input_method = src.input_method,
+				structure_prompt_tokens = 0, structure_candidates_tokens = 0,
+				extraction_status = 'skipped',
+				processing_status = CASE WHEN $3 THEN 'awaiting_review'::processing_status ELSE p.processing_status END,
+				cache_hit = 'structure', cached_from_problem_id = src.id,
+				saved_prompt_tokens = COALESCE(src.structure_prompt_tokens, 0),
+				saved_candidates_tokens = COALESCE(src.structure_candidates_tokens, 0)
+			FROM (
+				SELECT src.* FROM problems src JOIN problems p ON p.id = $2
+				WHERE src.input_hash = $1 AND src.id <> $2 AND src.extraction_status = 'completed' AND src.major_sections IS NOT NULL
+					AND ` + sameTenant + `
+				ORDER BY src.created_at DESC LIMIT 1
+			) src
+			WHERE p.id = $2
+			RETURNING src.id`
+		var sourceID int
+		err := h.DB.QueryRow(query, hash, problemID, review).Scan(&sourceID)
+		if err == nil {
+			return CacheStructure, sourceID, nil
+		}
+		if err != sql.ErrNoRows {
+			return "", 0, err
+		}
+	}
+	return "", 0, nil
+}
@@ -0,0 +1,98 @@
+package api
+
+import "testing"
+
+func TestInputHash(t *testing.T) {
+	notes := SourceUpload{FileName: "notes.pdf", MIMEType: MIMEPDF, Role: SourceRoleLectureNotes, Data: []byte("%PDF-notes")}
+	exam := SourceUpload{FileName: "exam.pdf", MIMEType: MIMEPDF, Role: SourceRoleSampleExam, Data: []byte("%PDF-exam")}
+	base := inputHash("問題文", nil, GenerationOptions{NumQuestions: 5, QuestionTypes: []string{"calculation", "proof"}})
+	files := inputHash("", []SourceUpload{notes, exam}, GenerationOptions{})
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+		same bool
+	}{
+		{
+			name: "review flag is ignored",
+			got:  inputHash("問題文", nil, GenerationOptions{NumQuestions: 5, QuestionTypes: []string{"calculation", "proof"}, Review: true}),
+			want: base, same: true,
+		},
+		{
+			name: "question type order is ignored",
+			got:  inputHash("問題文", nil, GenerationOptions{NumQuestions: 5, QuestionTypes: []string{"proof", "calculation"}}),
+			want: base, same: true,
+		},
+		{
+			name: "line endings and trailing spaces are ignored",
+			got:  inputHash("\r\n問題文  \r\n\r\n", nil, GenerationOptions{NumQuestions: 5, QuestionTypes: []string{"calculation", "proof"}}),
+			want: base, same: true,
+		},
+		{
+			name: "options change the hash",
+			got:  inputHash("問題文", nil, GenerationOptions{NumQuestions: 6, QuestionTypes: []string{"calculation", "proof"}}),
+			want: base,
+		},
+		{
+			name: "text changes the hash",
+			got:  inputHash("別の問題文", nil, GenerationOptions{NumQuestions: 5, QuestionTypes: []string{"calculation", "proof"}}),
+			want: base,
+		},
+		{
+			name: "file names are ignored",
+			got:  inputHash("", []SourceUpload{{FileName: "a.pdf", MIMEType: MIMEPDF, Role: SourceRoleLectureNotes, Data: notes.Data}, exam}, GenerationOptions{}),
+			want: files, same: true,
+		},
+		{
+			name: "text is ignored when files are given",
+			got:  inputHash("問題文", []SourceUpload{notes, exam}, GenerationOptions{}),
+			want: files, same: true,
+		},
+		{
+			name: "file order changes the hash",
+			got:  inputHash("", []SourceUpload{exam, notes}, GenerationOptions{}),
+			want: files,
+		},
+		{
+			name: "file roles change the hash",
+			got:  inputHash("", []SourceUpload{{MIMEType: MIMEPDF, Role: SourceRoleSampleExam, Data: notes.Data}, exam}, GenerationOptions{}),
+			want: files,
+		},
+		{
+			name: "file content changes the hash",
+			got:  inputHash("", []SourceUpload{{MIMEType: MIMEPDF, Role: SourceRoleLectureNotes, Data: []byte("%PDF-other")}, exam}, GenerationOptions{}),
+			want: files,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if (tt.got == tt.want) != tt.same {
+				t.Errorf("hashes equal = %t, want %t", tt.got == tt.want, tt.same)
+			}
+		})
+	}
+}
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "unchanged", text: "a\nb", want: "a\nb"},
+		{name: "CRLF line endings", text: "a\r\nb\r\n", want: "a\nb"},
+		{name: "trailing spaces and tabs", text: "a \t\nb  ", want: "a\nb"},
+		{name: "leading and trailing blank lines", text: "\n\n  \na\nb\n\n", want: "a\nb"},
+		{name: "inner blank lines and indentation are kept", text: "a\n\n  b", want: "a\n\n  b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeText(tt.text); got != tt.want {
+				t.Errorf("normalizeText(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
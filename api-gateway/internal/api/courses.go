@@ -0,0 +1,461 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/your-username/edumint/api-gateway/internal/auth"
+)
+
+// Roles of organization_members and course_members. They are independent of
+// the global roles of users: a student may be an instructor in one course.
+const (
+	OrganizationRoleMember = "member"
+	OrganizationRoleAdmin  = "admin"
+	CourseRoleStudent      = "student"
+	CourseRoleInstructor   = "instructor"
+)
+
+// slugRegex restricts organization slugs to URL-friendly identifiers.
+var slugRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,99}$`)
+
+// Organization is a tenant, typically a university.
+type Organization struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Course belongs to one organization and scopes the problems created in it.
+type Course struct {
+	ID             int       `json:"id"`
+	OrganizationID int       `json:"organization_id"`
+	Code           string    `json:"code"`
+	Name           string    `json:"name"`
+	Role           string    `json:"role,omitempty"` // the caller's role in the course, when listing their courses
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Member is a user's membership in an organization or course.
+type Member struct {
+	UserID    int       `json:"user_id"`
+	Email     string    `json:"email,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RequireOrganizationAdmin is the middleware of the /organizations/{org_id}
+// routes. It lets through the organization's admins and global admins, and
+// answers 404 to everyone else.
+func (h *Handler) RequireOrganizationAdmin(next http.Handler) http.Handler {
+	return h.requireMembership("org_id", "Organization not found",
+		`SELECT EXISTS (SELECT 1 FROM organization_members WHERE organization_id = $1 AND user_id = $2 AND role = 'admin')`, next)
+}
+
+// RequireCourseStaff is the middleware of the /courses/{course_id} routes. It
+// lets through the course's instructors, the admins of its organization and
+// global admins, and answers 404 to everyone else.
+func (h *Handler) RequireCourseStaff(next http.Handler) http.Handler {
+	return h.requireMembership("course_id", "Course not found", `SELECT is_course_staff($1, $2)`, next)
+}
+
+// requireMembership runs query with the route variable and the caller's user
+// ID and only lets the request through if it returns true.
+func (h *Handler) requireMembership(variable, notFound, query string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, err := strconv.Atoi(mux.Vars(r)[variable])
+		if err != nil {
+			http.Error(w, notFound, http.StatusNotFound)
+			return
+		}
+		caller := auth.FromContext(r.Context())
+		if caller.Can(auth.PermAdmin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var allowed bool
+		if err := h.DB.QueryRow(query, id, caller.UserID).Scan(&allowed); err != nil {
+			log.Printf("Error checking membership of user %d for %s %d: %v", caller.UserID, variable, id, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, notFound, http.StatusNotFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkCourseMember writes a 404 response and returns false unless the caller
+// may create problems in courseID: members of the course, its staff and global
+// admins may. A courseID of 0 creates a personal problem and is always allowed.
+func (h *Handler) checkCourseMember(w http.ResponseWriter, caller *auth.Identity, courseID int) bool {
+	if courseID == 0 {
+		return true
+	}
+	var exists, allowed bool
+	err := h.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM courses WHERE id = $1),
+			EXISTS (SELECT 1 FROM course_members WHERE course_id = $1 AND user_id = $2) OR is_course_staff($1, $2)`,
+		courseID, caller.UserID).Scan(&exists, &allowed)
+	if err != nil {
+		log.Printf("Error checking membership of user %d in course %d: %v", caller.UserID, courseID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if !exists || !(allowed || caller.Can(auth.PermAdmin)) {
+		http.Error(w, "Course not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// GetMyCoursesHandler lists the courses the caller is a member of, with their role in each.
+func (h *Handler) GetMyCoursesHandler(w http.ResponseWriter, r *http.Request) {
+	caller := auth.FromContext(r.Context())
+	rows, err := h.DB.Query(`SELECT c.id, c.organization_id, c.code, c.name, m.role, c.created_at
+		FROM course_members m JOIN courses c ON c.id = m.course_id
+		WHERE m.user_id = $1 ORDER BY c.organization_id, c.code`, caller.UserID)
+	if err != nil {
+		log.Printf("Error listing courses of user %d: %v", caller.UserID, err)
+		http.Error(w, "Failed to retrieve courses", http.StatusInternalServerError)
+		return
+	}
+	writeCourses(w, rows)
+}
+
+// GetOrganizationsHandler lists every organization.
+func (h *Handler) GetOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(`SELECT id, name, slug, created_at FROM organizations ORDER BY name`)
+	if err != nil {
+		log.Printf("Error listing organizations: %v", err)
+		http.Error(w, "Failed to retrieve organizations", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	organizations := []Organization{}
+	for rows.Next() {
+		var o Organization
+		if err := rows.Scan(&o.ID, &o.Name, &o.Slug, &o.CreatedAt); err != nil {
+			log.Printf("Error scanning organization row: %v", err)
+			continue
+		}
+		organizations = append(organizations, o)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over organization rows: %v", err)
+		http.Error(w, "Failed to process organizations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(organizations)
+}
+
+// CreateOrganizationHandler creates an organization from a JSON body {"name": "...", "slug": "..."}.
+func (h *Handler) CreateOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	var o Organization
+	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	o.Name = strings.TrimSpace(o.Name)
+	if o.Name == "" || len(o.Name) > 255 || !slugRegex.MatchString(o.Slug) {
+		http.Error(w, "invalid request: name is required and slug must consist of lower-case letters, digits and hyphens", http.StatusBadRequest)
+		return
+	}
+
+	err := h.DB.QueryRow(`INSERT INTO organizations (name, slug) VALUES ($1, $2)
+		ON CONFLICT (slug) DO NOTHING RETURNING id, created_at`, o.Name, o.Slug).Scan(&o.ID, &o.CreatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, fmt.Sprintf("Organization '%s' already exists", o.Slug), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating organization '%s': %v", o.Slug, err)
+		http.Error(w, "Failed to create organization", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Organization %d (%s) has been created.", o.ID, o.Slug)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(o)
+}
+
+// GetOrganizationMembersHandler lists the members of an organization.
+func (h *Handler) GetOrganizationMembersHandler(w http.ResponseWriter, r *http.Request) {
+	orgID, _ := strconv.Atoi(mux.Vars(r)["org_id"])
+	rows, err := h.DB.Query(`SELECT u.id, COALESCE(u.email, ''), COALESCE(u.name, ''), m.role, m.created_at
+		FROM organization_members m JOIN users u ON u.id = m.user_id
+		WHERE m.organization_id = $1 ORDER BY u.id`, orgID)
+	if err != nil {
+		log.Printf("Error listing members of organization %d: %v", orgID, err)
+		http.Error(w, "Failed to retrieve members", http.StatusInternalServerError)
+		return
+	}
+	writeMembers(w, rows)
+}
+
+// PutOrganizationMemberHandler changes the role of a member of an
+// organization, from a JSON body {"role": "member"} or {"role": "admin"}.
+// Organization admins bring in new users with invitations; only global admins
+// may add any user by ID, since that would otherwise expose users of other
+// organizations.
+func (h *Handler) PutOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orgID, _ := strconv.Atoi(vars["org_id"])
+	userID, _ := strconv.Atoi(vars["user_id"])
+	role, ok := decodeMemberRole(w, r, OrganizationRoleMember, OrganizationRoleAdmin)
+	if !ok {
+		return
+	}
+
+	query := `UPDATE organization_members SET role = $3 WHERE organization_id = $1 AND user_id = $2`
+	notFound := "Member not found: invite users to add them to the organization"
+	if auth.FromContext(r.Context()).Can(auth.PermAdmin) {
+		query = `INSERT INTO organization_members (organization_id, user_id, role)
+			SELECT $1, id, $3 FROM users WHERE id = $2
+			ON CONFLICT (organization_id, user_id) DO UPDATE SET role = EXCLUDED.role`
+		notFound = "User not found"
+	}
+	res, err := h.DB.Exec(query, orgID, userID, role)
+	if err != nil {
+		log.Printf("Error adding user %d to organization %d: %v", userID, orgID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, notFound, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"organization_id": orgID, "user_id": userID, "role": role})
+}
+
+// DeleteOrganizationMemberHandler removes a user from an organization and from its courses.
+func (h *Handler) DeleteOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orgID, _ := strconv.Atoi(vars["org_id"])
+	userID, _ := strconv.Atoi(vars["user_id"])
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		log.Printf("Error removing user %d from organization %d: %v", userID, orgID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2`, orgID, userID)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM course_members m USING courses c
+			WHERE c.id = m.course_id AND c.organization_id = $1 AND m.user_id = $2`, orgID, userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error removing user %d from organization %d: %v", userID, orgID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetOrganizationCoursesHandler lists the courses of an organization.
+func (h *Handler) GetOrganizationCoursesHandler(w http.ResponseWriter, r *http.Request) {
+	orgID, _ := strconv.Atoi(mux.Vars(r)["org_id"])
+	rows, err := h.DB.Query(`SELECT id, organization_id, code, name, '', created_at FROM courses
+		WHERE organization_id = $1 ORDER BY code`, orgID)
+	if err != nil {
+		log.Printf("Error listing courses of organization %d: %v", orgID, err)
+		http.Error(w, "Failed to retrieve courses", http.StatusInternalServerError)
+		return
+	}
+	writeCourses(w, rows)
+}
+
+// CreateCourseHandler creates a course in an organization from a JSON body {"code": "...", "name": "..."}.
+func (h *Handler) CreateCourseHandler(w http.ResponseWriter, r *http.Request) {
+	orgID, _ := strconv.Atoi(mux.Vars(r)["org_id"])
+	var c Course
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	c.Code, c.Name = strings.TrimSpace(c.Code), strings.TrimSpace(c.Name)
+	if c.Code == "" || len(c.Code) > 100 || c.Name == "" || len(c.Name) > 255 {
+		http.Error(w, "invalid request: code (at most 100 characters) and name (at most 255 characters) are required", http.StatusBadRequest)
+		return
+	}
+
+	c.OrganizationID = orgID
+	err := h.DB.QueryRow(`INSERT INTO courses (organization_id, code, name) VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id, code) DO NOTHING RETURNING id, created_at`,
+		orgID, c.Code, c.Name).Scan(&c.ID, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, fmt.Sprintf("Course '%s' already exists in this organization", c.Code), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating course '%s' in organization %d: %v", c.Code, orgID, err)
+		http.Error(w, "Failed to create course", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Course %d (%s) has been created in organization %d.", c.ID, c.Code, orgID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// PutCourseMemberHandler adds a member of the organization to one of its
+// courses or changes their role, from a JSON body {"role": "student"} or
+// {"role": "instructor"}.
+func (h *Handler) PutCourseMemberHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orgID, _ := strconv.Atoi(vars["org_id"])
+	courseID, _ := strconv.Atoi(vars["course_id"])
+	userID, _ := strconv.Atoi(vars["user_id"])
+	role, ok := decodeMemberRole(w, r, CourseRoleStudent, CourseRoleInstructor)
+	if !ok {
+		return
+	}
+
+	// Both the course and the user must belong to the organization in the URL.
+	res, err := h.DB.Exec(`
+		INSERT INTO course_members (course_id, user_id, role)
+		SELECT c.id, m.user_id, $4 FROM courses c
+		JOIN organization_members m ON m.organization_id = c.organization_id
+		WHERE c.id = $2 AND c.organization_id = $1 AND m.user_id = $3
+		ON CONFLICT (course_id, user_id) DO UPDATE SET role = EXCLUDED.role`,
+		orgID, courseID, userID, role)
+	if err != nil {
+		log.Printf("Error adding user %d to course %d: %v", userID, courseID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Course not found, or the user is not a member of the organization", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"course_id": courseID, "user_id": userID, "role": role})
+}
+
+// DeleteCourseMemberHandler removes a user from a course of the organization.
+func (h *Handler) DeleteCourseMemberHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orgID, _ := strconv.Atoi(vars["org_id"])
+	courseID, _ := strconv.Atoi(vars["course_id"])
+	userID, _ := strconv.Atoi(vars["user_id"])
+
+	res, err := h.DB.Exec(`DELETE FROM course_members m USING courses c
+		WHERE c.id = m.course_id AND c.organization_id = $1 AND m.course_id = $2 AND m.user_id = $3`, orgID, courseID, userID)
+	if err != nil {
+		log.Printf("Error removing user %d from course %d: %v", userID, courseID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetCourseMembersHandler lists the members of a course.
+func (h *Handler) GetCourseMembersHandler(w http.ResponseWriter, r *http.Request) {
+	courseID, _ := strconv.Atoi(mux.Vars(r)["course_id"])
+	rows, err := h.DB.Query(`SELECT u.id, COALESCE(u.email, ''), COALESCE(u.name, ''), m.role, m.created_at
+		FROM course_members m JOIN users u ON u.id = m.user_id
+		WHERE m.course_id = $1 ORDER BY m.role, u.id`, courseID)
+	if err != nil {
+		log.Printf("Error listing members of course %d: %v", courseID, err)
+		http.Error(w, "Failed to retrieve members", http.StatusInternalServerError)
+		return
+	}
+	writeMembers(w, rows)
+}
+
+// decodeMemberRole reads {"role": ...} from the request body and checks it
+// against the allowed roles. It writes the error response itself.
+func decodeMemberRole(w http.ResponseWriter, r *http.Request, allowed ...string) (string, bool) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return "", false
+	}
+	for _, role := range allowed {
+		if req.Role == role {
+			return role, true
+		}
+	}
+	http.Error(w, "invalid request: role must be one of "+strings.Join(allowed, ", "), http.StatusBadRequest)
+	return "", false
+}
+
+// writeCourses encodes rows of (id, organization_id, code, name, role, created_at).
+func writeCourses(w http.ResponseWriter, rows *sql.Rows) {
+	defer rows.Close()
+	courses := []Course{}
+	for rows.Next() {
+		var c Course
+		if err := rows.Scan(&c.ID, &c.OrganizationID, &c.Code, &c.Name, &c.Role, &c.CreatedAt); err != nil {
+			log.Printf("Error scanning course row: %v", err)
+			continue
+		}
+		courses = append(courses, c)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over course rows: %v", err)
+		http.Error(w, "Failed to process courses", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(courses)
+}
+
+// writeMembers encodes rows of (user_id, email, name, role, created_at).
+func writeMembers(w http.ResponseWriter, rows *sql.Rows) {
+	defer rows.Close()
+	members := []Member{}
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.UserID, &m.Email, &m.Name, &m.Role, &m.CreatedAt); err != nil {
+			log.Printf("Error scanning member row: %v", err)
+			continue
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over member rows: %v", err)
+		http.Error(w, "Failed to process members", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
@@ -1,75 +1,313 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/your-username/edumint/api-gateway/internal/auth"
+	"github.com/your-username/edumint/api-gateway/internal/blobstore"
+	"github.com/your-username/edumint/api-gateway/internal/events"
 	"github.com/your-username/edumint/api-gateway/internal/queue"
 )
 
 const GENERATION_QUEUE = "problem_generation_queue"
 
+// sseHeartbeatInterval keeps idle event streams from being closed by proxies.
+const sseHeartbeatInterval = 15 * time.Second
+
 type Handler struct {
 	DB          *sql.DB
 	QueueClient *queue.Client
+	Events      *events.Hub
+	Blobs       blobstore.Store
 }
 
 // ProblemHistoryItem defines the structure for the admin dashboard's history view.
 type ProblemHistoryItem struct {
 	ID                         int       `json:"id"`
+	CourseID                   int       `json:"course_id,omitempty"`
 	ExamTitle                  string    `json:"exam_title"`
 	CreatedAt                  time.Time `json:"created_at"`
 	ProcessingStatus           string    `json:"processing_status"`
 	ErrorMessage               string    `json:"error_message"`
+	InputMethod                string    `json:"input_method,omitempty"`
+	CacheHit                   string    `json:"cache_hit,omitempty"`
+	CachedFromProblemID        int       `json:"cached_from_problem_id,omitempty"`
 	StructurePromptTokens      int       `json:"structure_prompt_tokens"`
 	StructureCandidatesTokens  int       `json:"structure_candidates_tokens"`
 	GenerationPromptTokens     int       `json:"generation_prompt_tokens"`
 	GenerationCandidatesTokens int       `json:"generation_candidates_tokens"`
+	RegenerationTokens         int       `json:"regeneration_tokens"`
 	TotalTokens                int       `json:"total_tokens"`
+	SavedTokens                int       `json:"saved_tokens"`
+}
+
+// QuestionRegeneration is a request to generate one question of a problem again.
+type QuestionRegeneration struct {
+	ID               int             `json:"regeneration_id"`
+	ProblemID        int             `json:"problem_id"`
+	QuestionIndex    string          `json:"question_index"`
+	Status           string          `json:"status"`
+	Instructions     string          `json:"instructions,omitempty"`
+	ErrorMessage     string          `json:"error_message,omitempty"`
+	PreviousQuestion json.RawMessage `json:"previous_question,omitempty"`
+	NewQuestion      json.RawMessage `json:"new_question,omitempty"`
+	PromptTokens     int             `json:"prompt_tokens"`
+	CandidatesTokens int             `json:"candidates_tokens"`
+	CreatedAt        time.Time       `json:"created_at"`
+	CompletedAt      *time.Time      `json:"completed_at,omitempty"`
+}
+
+// maxInstructionsLength limits the feedback sent along with a regeneration request.
+const maxInstructionsLength = 2000
+
+// LLMAttempt is one model request made by the worker for a problem.
+type LLMAttempt struct {
+	Stage            string    `json:"stage"`
+	Model            string    `json:"model"`
+	Attempt          int       `json:"attempt"`
+	Outcome          string    `json:"outcome"`
+	ErrorMessage     string    `json:"error_message,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CandidatesTokens int       `json:"candidates_tokens"`
+	DurationMs       int       `json:"duration_ms"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// SectionGeneration is the generation result of one major section of a problem.
+type SectionGeneration struct {
+	Position         int        `json:"position"`
+	SectionIndex     string     `json:"section_index,omitempty"`
+	Status           string     `json:"status"`
+	ErrorMessage     string     `json:"error_message,omitempty"`
+	QuestionCount    int        `json:"question_count"`
+	PromptTokens     int        `json:"prompt_tokens"`
+	CandidatesTokens int        `json:"candidates_tokens"`
+	StartedAt        *time.Time `json:"started_at,omitempty"`
+	CompletedAt      *time.Time `json:"completed_at,omitempty"`
+}
+
+// ProblemSource describes one uploaded source file of a problem.
+type ProblemSource struct {
+	Position  int       `json:"position"`
+	Role      string    `json:"role"`
+	FileName  string    `json:"file_name"`
+	MIMEType  string    `json:"mime_type"`
+	SHA256    string    `json:"sha256"`
+	SizeBytes int       `json:"size_bytes"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // GenerateProblemHandler accepts a user request, creates a job entry in the DB, and queues it.
+// The input is either a JSON GenerateRequest or a multipart form with one or
+// more "file" fields (PDF, DOCX, PPTX, Markdown, LaTeX or plain text), their
+// "roles" and the generation options as form fields. See readSources.
 func (h *Handler) GenerateProblemHandler(w http.ResponseWriter, r *http.Request) {
-	var problemID int
+	caller := auth.FromContext(r.Context())
+	var problemID, courseID int
+	var hash, cacheMode string
+	var review bool
 	var err error
 
 	// Create a job entry in the database based on the input type.
-	if r.Header.Get("Content-Type") == "application/json" {
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		var req GenerateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON request body: expected an object with a \"text\" field", http.StatusBadRequest)
 			return
 		}
-		err = h.DB.QueryRow(`INSERT INTO problems (raw_input_text) VALUES ($1) RETURNING id`, string(body)).Scan(&problemID)
+		if strings.TrimSpace(req.Text) == "" {
+			http.Error(w, "invalid request: text must not be empty", http.StatusBadRequest)
+			return
+		}
+		if err := validateCacheMode(req.Cache); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		optionsJSON, ok := validatedOptions(w, req.GenerationOptions)
+		if !ok || !h.checkCourseMember(w, caller, req.CourseID) {
+			return
+		}
+		if !h.checkTokenBudget(w, caller.UserID, req.CourseID, estimateJobTokens(req.Text, nil, req.NumQuestions)) {
+			return
+		}
+		cacheMode, courseID, review = req.Cache, req.CourseID, req.Review
+		hash = inputHash(req.Text, nil, req.GenerationOptions)
+		err = h.DB.QueryRow(`INSERT INTO problems (owner_id, course_id, raw_input_text, generation_options, input_hash) VALUES ($1, NULLIF($2, 0), $3, $4, $5) RETURNING id`,
+			caller.UserID, courseID, req.Text, optionsJSON, hash).Scan(&problemID)
 	} else { // multipart/form-data
-		r.ParseMultipartForm(32 << 20) // 32MB limit
-		file, _, err := r.FormFile("pdfFile")
-		if err != nil {
+		if formErr := r.ParseMultipartForm(32 << 20); formErr != nil { // 32MB in memory, the rest in temporary files
 			http.Error(w, "Invalid file in form data", http.StatusBadRequest)
 			return
 		}
-		defer file.Close()
-		fileBytes, err := io.ReadAll(file)
-		if err != nil {
-			http.Error(w, "Failed to read uploaded file", http.StatusInternalServerError)
+		opts, optErr := optionsFromForm(r.MultipartForm.Value)
+		if optErr != nil {
+			http.Error(w, optErr.Error(), http.StatusBadRequest)
 			return
 		}
-		err = h.DB.QueryRow(`INSERT INTO problems (raw_input_file) VALUES ($1) RETURNING id`, fileBytes).Scan(&problemID)
+		cacheMode = strings.TrimSpace(r.FormValue("cache"))
+		if err := validateCacheMode(cacheMode); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if v := strings.TrimSpace(r.FormValue("course_id")); v != "" {
+			if courseID, err = strconv.Atoi(v); err != nil {
+				http.Error(w, "invalid request: course_id must be an integer", http.StatusBadRequest)
+				return
+			}
+		}
+		optionsJSON, ok := validatedOptions(w, opts)
+		if !ok || !h.checkCourseMember(w, caller, courseID) {
+			return
+		}
+		sources, ok := readSources(w, r.MultipartForm)
+		if !ok || !h.checkTokenBudget(w, caller.UserID, courseID, estimateJobTokens("", sources, opts.NumQuestions)) {
+			return
+		}
+		hash, review = inputHash("", sources, opts), opts.Review
+		problemID, err = h.createSourceJob(r.Context(), caller.UserID, courseID, sources, optionsJSON, hash)
 	}
 
 	if err != nil {
-		log.Printf("Error creating job in DB: %v", err)
+		log.Printf("Error creating job: %v", err)
 		http.Error(w, "Failed to create job", http.StatusInternalServerError)
 		return
 	}
 
-	// Publish the job ID to the RabbitMQ queue.
+	response := map[string]interface{}{"problem_id": problemID}
+	hit, sourceID, err := h.applyCache(problemID, hash, cacheMode, review)
+	if err != nil {
+		// The job simply runs without the cache.
+		log.Printf("Error looking up cached results for job %d: %v", problemID, err)
+	} else if hit != "" {
+		log.Printf("Job %d reuses the %s result of job %d", problemID, hit, sourceID)
+		response["cache_hit"] = hit
+		response["cached_from_problem_id"] = sourceID
+	}
+	// Reused results need no worker; a reused structure in review mode waits
+	// for approval, which queues the job.
+	reused := hit == CacheFull || (hit == CacheStructure && review)
+	if !reused && !h.publishJob(w, problemID) {
+		return
+	}
+	writeAccepted(w, response)
+}
+
+// createSourceJob creates a job of ownerID in courseID (0 for none) whose input
+// is the uploaded source files. The files go to the blob store and are
+// referenced in order from problem_sources by their SHA-256.
+func (h *Handler) createSourceJob(ctx context.Context, ownerID, courseID int, sources []SourceUpload, optionsJSON []byte, hash string) (int, error) {
+	keys := make([]string, len(sources))
+	for i, src := range sources {
+		key, err := h.Blobs.Put(ctx, src.Data)
+		if err != nil {
+			return 0, fmt.Errorf("failed to store '%s': %w", src.FileName, err)
+		}
+		keys[i] = key
+	}
+
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var problemID int
+	if err := tx.QueryRow(`INSERT INTO problems (owner_id, course_id, generation_options, input_hash) VALUES ($1, NULLIF($2, 0), $3, $4) RETURNING id`, ownerID, courseID, optionsJSON, hash).Scan(&problemID); err != nil {
+		return 0, err
+	}
+	for i, src := range sources {
+		_, err := tx.Exec(`INSERT INTO problem_sources (problem_id, position, role, file_name, mime_type, content_sha256, size_bytes) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			problemID, i, src.Role, src.FileName, src.MIMEType, keys[i], len(src.Data))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return problemID, tx.Commit()
+}
+
+// CreateFromBlueprintHandler accepts an exam blueprint (a ProblemStructure
+// document) and queues a job that only runs problem generation. The structure
+// is stored as if extraction had already happened, with the extraction stage
+// marked 'skipped', so no extraction call or tokens are spent.
+func (h *Handler) CreateFromBlueprintHandler(w http.ResponseWriter, r *http.Request) {
+	var req BlueprintRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid blueprint: %v", err), http.StatusBadRequest)
+		return
+	}
+	req.ProblemStructure.assignIndices()
+	if err := req.ProblemStructure.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The blueprint already fixes the questions and their formats.
+	if req.Options.NumQuestions != 0 || req.Options.Latex != nil {
+		http.Error(w, "invalid request: options.num_questions and options.latex are determined by the blueprint", http.StatusBadRequest)
+		return
+	}
+	caller := auth.FromContext(r.Context())
+	optionsJSON, ok := validatedOptions(w, req.Options)
+	if !ok || !h.checkCourseMember(w, caller, req.CourseID) {
+		return
+	}
+	numQuestions := 0
+	for _, section := range req.Structure.MajorSections {
+		numQuestions += len(section.SubQuestions)
+	}
+	if !h.checkTokenBudget(w, caller.UserID, req.CourseID, estimateJobTokens("", nil, numQuestions)) {
+		return
+	}
+
+	meta := req.ExamMeta
+	majorSectionsJSON, err := json.Marshal(req.Structure.MajorSections)
+	if err != nil {
+		http.Error(w, "Failed to encode blueprint", http.StatusInternalServerError)
+		return
+	}
+	var problemID int
+	query := `INSERT INTO problems (
+			owner_id, course_id, exam_title, duration_minutes, is_open_book, allowed_materials,
+			question_format_is_latex, answer_format_is_latex, major_sections,
+			structure_prompt_tokens, structure_candidates_tokens,
+			extraction_status, generation_options)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7, $8, $9, 0, 0, 'skipped', $10)
+		RETURNING id`
+	err = h.DB.QueryRow(query, caller.UserID, req.CourseID, meta.ExamTitle, meta.ExamDuration, meta.OpenBook, pq.StringArray(meta.AllowedMaterials),
+		meta.QuestionFormatIsLatex, meta.AnswerFormatIsLatex, majorSectionsJSON, optionsJSON).Scan(&problemID)
+	if err != nil {
+		log.Printf("Error creating blueprint job in DB: %v", err)
+		http.Error(w, "Failed to create job", http.StatusInternalServerError)
+		return
+	}
+
+	h.queueJob(w, problemID)
+}
+
+// queueJob publishes the job ID to the RabbitMQ queue and writes the 202 response.
+func (h *Handler) queueJob(w http.ResponseWriter, problemID int) {
+	if h.publishJob(w, problemID) {
+		writeAccepted(w, map[string]interface{}{"problem_id": problemID})
+	}
+}
+
+// publishJob publishes the job ID to the RabbitMQ queue. On failure it marks
+// the job as failed, writes an error response and returns false.
+func (h *Handler) publishJob(w http.ResponseWriter, problemID int) bool {
 	jobPayload := map[string]int{"problem_id": problemID}
 	jobBytes, _ := json.Marshal(jobPayload)
 	if err := h.QueueClient.Publish(GENERATION_QUEUE, jobBytes); err != nil {
@@ -77,13 +315,33 @@ func (h *Handler) GenerateProblemHandler(w http.ResponseWriter, r *http.Request)
 		// Attempt to mark the job as failed in the DB.
 		h.DB.Exec(`UPDATE problems SET processing_status = 'failed', error_message = $1 WHERE id = $2`, "Failed to queue job", problemID)
 		http.Error(w, "Failed to queue job for processing", http.StatusInternalServerError)
-		return
+		return false
 	}
 
 	log.Printf("Job with ID %d has been successfully queued.", problemID)
+	return true
+}
+
+// writeAccepted writes the 202 response for a created job.
+func writeAccepted(w http.ResponseWriter, response map[string]interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted) // 202 Accepted: Request received, processing will happen asynchronously.
-	json.NewEncoder(w).Encode(map[string]int{"problem_id": problemID})
+	json.NewEncoder(w).Encode(response)
+}
+
+// validatedOptions validates opts and returns them encoded for the
+// generation_options column. On failure it writes a 400 response.
+func validatedOptions(w http.ResponseWriter, opts GenerationOptions) ([]byte, bool) {
+	if err := opts.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	optionsJSON, err := json.Marshal(opts)
+	if err != nil {
+		http.Error(w, "Failed to encode generation options", http.StatusInternalServerError)
+		return nil, false
+	}
+	return optionsJSON, true
 }
 
 // GetProblemStatusHandler allows the frontend to poll for the result of a job.
@@ -95,12 +353,13 @@ func (h *Handler) GetProblemStatusHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	var status string
-	var errorMessage sql.NullString
+	var status, extractionStatus, generationStatus string
+	var errorMessage, inputMethod, cacheHit sql.NullString
 	var generatedQuestions []byte // JSONBをバイトスライスとして受け取る
+	var coverageReport []byte
 
-	query := `SELECT processing_status, error_message, generated_questions FROM problems WHERE id = $1`
-	err = h.DB.QueryRow(query, id).Scan(&status, &errorMessage, &generatedQuestions)
+	query := `SELECT processing_status, extraction_status, generation_status, error_message, input_method, cache_hit, generated_questions, coverage_report FROM problems WHERE id = $1`
+	err = h.DB.QueryRow(query, id).Scan(&status, &extractionStatus, &generationStatus, &errorMessage, &inputMethod, &cacheHit, &generatedQuestions, &coverageReport)
 
 	if err == sql.ErrNoRows {
 		http.Error(w, "Problem not found", http.StatusNotFound)
@@ -112,10 +371,26 @@ func (h *Handler) GetProblemStatusHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	response := map[string]interface{}{"problem_id": id, "status": status}
+	response := map[string]interface{}{
+		"problem_id": id,
+		"status":     status,
+		"stages": map[string]string{
+			"extract_structure": extractionStatus,
+			"generate_problem":  generationStatus,
+		},
+	}
+	if inputMethod.Valid {
+		response["input_method"] = inputMethod.String
+	}
+	if cacheHit.Valid {
+		response["cache_hit"] = cacheHit.String
+	}
 	if status == "completed" {
 		// バイトスライスをjson.RawMessageに変換して、JSONとしてそのままフロントに渡す
 		response["generated_output"] = json.RawMessage(generatedQuestions)
+		if len(coverageReport) > 0 {
+			response["coverage_report"] = json.RawMessage(coverageReport)
+		}
 	} else if status == "failed" {
 		response["error"] = errorMessage.String
 	}
@@ -124,26 +399,408 @@ func (h *Handler) GetProblemStatusHandler(w http.ResponseWriter, r *http.Request
 	json.NewEncoder(w).Encode(response)
 }
 
-// GetHistoryHandler provides data for the admin dashboard.
+// CancelProblemHandler cancels a job that has not finished yet. The worker
+// notices the new status before its next stage and aborts in-flight LLM requests.
+func (h *Handler) CancelProblemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid Problem ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `UPDATE problems SET processing_status = 'cancelled', error_message = NULL
+		WHERE id = $1 AND processing_status IN ('pending', 'processing', 'awaiting_review')
+		RETURNING id`
+	err = h.DB.QueryRow(query, id).Scan(&id)
+	if err == sql.ErrNoRows {
+		// Either the problem does not exist or it already finished.
+		h.writeStatusConflict(w, id, "cancelled")
+		return
+	}
+	if err != nil {
+		log.Printf("Error cancelling problem %d: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Job with ID %d has been cancelled.", id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"problem_id": id, "status": "cancelled"})
+}
+
+// GetStructureHandler returns the extracted structure of a problem in the
+// same format as the blueprint API, so it can be edited and sent back with
+// UpdateStructureHandler.
+func (h *Handler) GetStructureHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid Problem ID", http.StatusBadRequest)
+		return
+	}
+
+	var extractionStatus string
+	var examTitle sql.NullString
+	var duration sql.NullInt64
+	var openBook, questionLatex, answerLatex sql.NullBool
+	var allowedMaterials pq.StringArray
+	var majorSections []byte
+	query := `SELECT extraction_status, exam_title, duration_minutes, is_open_book, allowed_materials,
+		question_format_is_latex, answer_format_is_latex, major_sections
+		FROM problems WHERE id = $1`
+	err = h.DB.QueryRow(query, id).Scan(&extractionStatus, &examTitle, &duration, &openBook, &allowedMaterials,
+		&questionLatex, &answerLatex, &majorSections)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Problem not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying structure for problem %d: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if extractionStatus != "completed" && extractionStatus != "skipped" {
+		http.Error(w, "Structure has not been extracted yet (extraction status '"+extractionStatus+"')", http.StatusConflict)
+		return
+	}
+
+	ps := ProblemStructure{
+		ExamMeta: ExamMeta{
+			ExamTitle:             examTitle.String,
+			OpenBook:              openBook.Bool,
+			AllowedMaterials:      allowedMaterials,
+			QuestionFormatIsLatex: questionLatex.Bool,
+			AnswerFormatIsLatex:   answerLatex.Bool,
+		},
+	}
+	if duration.Valid {
+		d := int(duration.Int64)
+		ps.ExamMeta.ExamDuration = &d
+	}
+	if err := json.Unmarshal(majorSections, &ps.Structure.MajorSections); err != nil {
+		log.Printf("Stored major_sections of problem %d are invalid: %v", id, err)
+		http.Error(w, "Stored structure is invalid", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ps)
+}
+
+// UpdateStructureHandler replaces the structure of a job that is awaiting review.
+func (h *Handler) UpdateStructureHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid Problem ID", http.StatusBadRequest)
+		return
+	}
+
+	var ps ProblemStructure
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&ps); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid structure: %v", err), http.StatusBadRequest)
+		return
+	}
+	ps.assignIndices()
+	if err := ps.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	majorSectionsJSON, err := json.Marshal(ps.Structure.MajorSections)
+	if err != nil {
+		http.Error(w, "Failed to encode structure", http.StatusInternalServerError)
+		return
+	}
+
+	meta := ps.ExamMeta
+	query := `UPDATE problems SET
+		exam_title = $1, duration_minutes = $2, is_open_book = $3, allowed_materials = $4,
+		question_format_is_latex = $5, answer_format_is_latex = $6, major_sections = $7
+		WHERE id = $8 AND processing_status = 'awaiting_review'
+		RETURNING id`
+	err = h.DB.QueryRow(query, meta.ExamTitle, meta.ExamDuration, meta.OpenBook, pq.StringArray(meta.AllowedMaterials),
+		meta.QuestionFormatIsLatex, meta.AnswerFormatIsLatex, majorSectionsJSON, id).Scan(&id)
+	if err == sql.ErrNoRows {
+		h.writeStatusConflict(w, id, "edited")
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating structure of problem %d: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Structure of job %d was edited during review.", id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ps)
+}
+
+// ApproveStructureHandler approves the reviewed structure and queues the
+// job again so that the worker runs problem generation.
+func (h *Handler) ApproveStructureHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid Problem ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `UPDATE problems SET processing_status = 'pending', structure_approved_at = NOW()
+		WHERE id = $1 AND processing_status = 'awaiting_review'
+		RETURNING id`
+	err = h.DB.QueryRow(query, id).Scan(&id)
+	if err == sql.ErrNoRows {
+		h.writeStatusConflict(w, id, "approved")
+		return
+	}
+	if err != nil {
+		log.Printf("Error approving structure of problem %d: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Structure of job %d was approved.", id)
+	h.queueJob(w, id)
+}
+
+// writeStatusConflict answers a state transition that matched no row: 404 if
+// the problem does not exist, otherwise 409 naming its current status.
+func (h *Handler) writeStatusConflict(w http.ResponseWriter, id int, action string) {
+	var status string
+	err := h.DB.QueryRow(`SELECT processing_status FROM problems WHERE id = $1`, id).Scan(&status)
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "Problem not found", http.StatusNotFound)
+	case err != nil:
+		log.Printf("Error querying status of problem %d: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	default:
+		http.Error(w, "Problem cannot be "+action+" in status '"+status+"'", http.StatusConflict)
+	}
+}
+
+// RegenerateQuestionHandler queues the regeneration of a single question of
+// a completed problem. Only that question is sent to the model and replaced;
+// its token usage is recorded on the regeneration request. An optional JSON
+// body {"instructions": "..."} is passed to the model as feedback.
+func (h *Handler) RegenerateQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid Problem ID", http.StatusBadRequest)
+		return
+	}
+	questionIndex := vars["question_index"]
+
+	var req struct {
+		Instructions string `json:"instructions"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if len([]rune(req.Instructions)) > maxInstructionsLength {
+		http.Error(w, fmt.Sprintf("invalid request: instructions must be at most %d characters", maxInstructionsLength), http.StatusBadRequest)
+		return
+	}
+
+	var status string
+	var generatedQuestions []byte
+	var ownerID, courseID int
+	err = h.DB.QueryRow(`SELECT processing_status, generated_questions, COALESCE(owner_id, 0), COALESCE(course_id, 0) FROM problems WHERE id = $1`,
+		id).Scan(&status, &generatedQuestions, &ownerID, &courseID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Problem not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying problem %d: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if status != "completed" {
+		http.Error(w, "Questions can only be regenerated once the problem is completed (status '"+status+"')", http.StatusConflict)
+		return
+	}
+	var generated struct {
+		Questions []struct {
+			QuestionIndex string `json:"question_index"`
+		} `json:"questions"`
+	}
+	json.Unmarshal(generatedQuestions, &generated)
+	found := false
+	for _, q := range generated.Questions {
+		if q.QuestionIndex == questionIndex {
+			found = true
+			break
+		}
+	}
+	if !found {
+		http.Error(w, "Question not found", http.StatusNotFound)
+		return
+	}
+	// The tokens count against the budgets of the problem's owner and course.
+	if !h.checkTokenBudget(w, ownerID, courseID, estimateJobTokens("", nil, 1)) {
+		return
+	}
+
+	// Only one regeneration of a question may be in flight at a time.
+	var regenerationID int
+	query := `INSERT INTO question_regenerations (problem_id, question_index, instructions)
+		SELECT $1, $2, NULLIF($3, '')
+		WHERE NOT EXISTS (
+			SELECT 1 FROM question_regenerations
+			WHERE problem_id = $1 AND question_index = $2 AND status IN ('pending', 'running'))
+		RETURNING id`
+	err = h.DB.QueryRow(query, id, questionIndex, req.Instructions).Scan(&regenerationID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "This question is already being regenerated", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating regeneration for problem %d: %v", id, err)
+		http.Error(w, "Failed to create regeneration request", http.StatusInternalServerError)
+		return
+	}
+
+	jobBytes, _ := json.Marshal(map[string]interface{}{
+		"problem_id":      id,
+		"kind":            "regenerate_question",
+		"regeneration_id": regenerationID,
+	})
+	if err := h.QueueClient.Publish(GENERATION_QUEUE, jobBytes); err != nil {
+		log.Printf("Error publishing regeneration job to queue: %v", err)
+		h.DB.Exec(`UPDATE question_regenerations SET status = 'failed', error_message = $1 WHERE id = $2`, "Failed to queue job", regenerationID)
+		http.Error(w, "Failed to queue job for processing", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Regeneration %d of question '%s' of problem %d has been queued.", regenerationID, questionIndex, id)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(QuestionRegeneration{
+		ID:            regenerationID,
+		ProblemID:     id,
+		QuestionIndex: questionIndex,
+		Status:        "pending",
+		Instructions:  req.Instructions,
+		CreatedAt:     time.Now().UTC(),
+	})
+}
+
+// GetRegenerationsHandler lists the question regenerations of a problem, newest first.
+func (h *Handler) GetRegenerationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid Problem ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		SELECT id, question_index, status, instructions, error_message, previous_question, new_question,
+			prompt_tokens, candidates_tokens, created_at, completed_at
+		FROM question_regenerations
+		WHERE problem_id = $1
+		ORDER BY created_at DESC, id DESC`
+	rows, err := h.DB.Query(query, id)
+	if err != nil {
+		log.Printf("Error querying regenerations for problem %d: %v", id, err)
+		http.Error(w, "Failed to retrieve regenerations", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	regenerations := []QuestionRegeneration{}
+	for rows.Next() {
+		rg := QuestionRegeneration{ProblemID: id}
+		var instructions, errMsg sql.NullString
+		var previous, next []byte
+		var promptTokens, candidatesTokens sql.NullInt64
+		var completedAt sql.NullTime
+		if err := rows.Scan(&rg.ID, &rg.QuestionIndex, &rg.Status, &instructions, &errMsg, &previous, &next,
+			&promptTokens, &candidatesTokens, &rg.CreatedAt, &completedAt); err != nil {
+			log.Printf("Error scanning regeneration row: %v", err)
+			continue
+		}
+		rg.Instructions = instructions.String
+		rg.ErrorMessage = errMsg.String
+		if len(previous) > 0 {
+			rg.PreviousQuestion = previous
+		}
+		if len(next) > 0 {
+			rg.NewQuestion = next
+		}
+		rg.PromptTokens = int(promptTokens.Int64)
+		rg.CandidatesTokens = int(candidatesTokens.Int64)
+		if completedAt.Valid {
+			rg.CompletedAt = &completedAt.Time
+		}
+		regenerations = append(regenerations, rg)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Error iterating regeneration rows: %v", err)
+		http.Error(w, "Failed to process regenerations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(regenerations)
+}
+
+// GetHistoryHandler provides data for the admin dashboard. The optional
+// course_id query parameter restricts it to one course.
 func (h *Handler) GetHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	courseID := 0
+	if v := r.URL.Query().Get("course_id"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid course_id", http.StatusBadRequest)
+			return
+		}
+		courseID = n
+	}
+	h.writeHistory(w, courseID)
+}
+
+// GetCourseHistoryHandler provides the history of one course for its staff.
+func (h *Handler) GetCourseHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	courseID, _ := strconv.Atoi(mux.Vars(r)["course_id"])
+	h.writeHistory(w, courseID)
+}
+
+// writeHistory writes the 100 most recent jobs of courseID, or of every course if it is 0.
+func (h *Handler) writeHistory(w http.ResponseWriter, courseID int) {
 	query := `
 		SELECT 
 			id, 
+			course_id,
 			exam_title,
 			created_at,
 			processing_status,
 			error_message,
+			input_method,
+			cache_hit,
+			cached_from_problem_id,
 			structure_prompt_tokens,
 			structure_candidates_tokens,
 			generation_prompt_tokens,
 			generation_candidates_tokens,
+			regeneration_tokens,
 			(COALESCE(structure_prompt_tokens, 0) + COALESCE(structure_candidates_tokens, 0) +
-			 COALESCE(generation_prompt_tokens, 0) + COALESCE(generation_candidates_tokens, 0)) as total_tokens
+			 COALESCE(generation_prompt_tokens, 0) + COALESCE(generation_candidates_tokens, 0) +
+			 regeneration_tokens) as total_tokens,
+			saved_prompt_tokens + saved_candidates_tokens as saved_tokens
 		FROM problems
+		CROSS JOIN LATERAL (
+			SELECT COALESCE(SUM(COALESCE(prompt_tokens, 0) + COALESCE(candidates_tokens, 0)), 0) AS regeneration_tokens
+			FROM question_regenerations WHERE problem_id = problems.id
+		) regenerations
+		WHERE $1 = 0 OR course_id = $1
 		ORDER BY created_at DESC
 		LIMIT 100;
 	`
-	rows, err := h.DB.Query(query)
+	rows, err := h.DB.Query(query, courseID)
 	if err != nil {
 		http.Error(w, "Failed to retrieve history", http.StatusInternalServerError)
 		return
@@ -154,24 +811,29 @@ func (h *Handler) GetHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var item ProblemHistoryItem
 		// NULLを許容する型でDBからの値を受け取る
-		var examTitle, errMsg sql.NullString
-		var s_prompt, s_cand, g_prompt, g_cand, total sql.NullInt64
+		var examTitle, errMsg, inputMethod, cacheHit sql.NullString
+		var course, cachedFrom, s_prompt, s_cand, g_prompt, g_cand, regen, total sql.NullInt64
 
 		if err := rows.Scan(
-			&item.ID, &examTitle, &item.CreatedAt, &item.ProcessingStatus, &errMsg,
-			&s_prompt, &s_cand, &g_prompt, &g_cand, &total,
+			&item.ID, &course, &examTitle, &item.CreatedAt, &item.ProcessingStatus, &errMsg, &inputMethod, &cacheHit, &cachedFrom,
+			&s_prompt, &s_cand, &g_prompt, &g_cand, &regen, &total, &item.SavedTokens,
 		); err != nil {
 			log.Printf("Error scanning history row: %v", err)
 			continue // エラーが発生した行はスキップ
 		}
 
 		// 値を安全に代入
+		item.CourseID = int(course.Int64)
 		item.ExamTitle = examTitle.String
 		item.ErrorMessage = errMsg.String
+		item.InputMethod = inputMethod.String
+		item.CacheHit = cacheHit.String
+		item.CachedFromProblemID = int(cachedFrom.Int64)
 		item.StructurePromptTokens = int(s_prompt.Int64)
 		item.StructureCandidatesTokens = int(s_cand.Int64)
 		item.GenerationPromptTokens = int(g_prompt.Int64)
 		item.GenerationCandidatesTokens = int(g_cand.Int64)
+		item.RegenerationTokens = int(regen.Int64)
 		item.TotalTokens = int(total.Int64)
 
 		history = append(history, item)
@@ -186,3 +848,315 @@ func (h *Handler) GetHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(history)
 }
+
+// GetDeadLettersHandler lists jobs the worker gave up on, for inspection by an admin.
+func (h *Handler) GetDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	letters, err := h.QueueClient.ListDeadLetters(GENERATION_QUEUE, limit)
+	if err != nil {
+		log.Printf("Error listing dead-lettered jobs: %v", err)
+		http.Error(w, "Failed to retrieve dead-lettered jobs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(letters)
+}
+
+// ReplayDeadLettersHandler puts dead-lettered jobs back on the generation queue.
+// An optional JSON body {"problem_id": n} restricts the replay to one problem.
+func (h *Handler) ReplayDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ProblemID int `json:"problem_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	replayed, err := h.QueueClient.ReplayDeadLetters(GENERATION_QUEUE, req.ProblemID, 1000)
+	// Reset the status of whatever was replayed, even if the replay stopped half way.
+	for _, id := range replayed {
+		if _, uerr := h.DB.Exec(`UPDATE problems SET processing_status = 'pending', error_message = NULL WHERE id = $1 AND processing_status = 'failed'`, id); uerr != nil {
+			log.Printf("Error resetting status of replayed job %d: %v", id, uerr)
+		}
+	}
+	if err != nil {
+		log.Printf("Error replaying dead-lettered jobs: %v", err)
+		http.Error(w, "Failed to replay dead-lettered jobs", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Replayed %d dead-lettered job(s).", len(replayed))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"replayed": replayed})
+}
+
+// GetProblemAttemptsHandler lists every model attempt (including retries and fallbacks) made for a problem.
+func (h *Handler) GetProblemAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid Problem ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		SELECT stage, model, attempt, outcome, error_message, prompt_tokens, candidates_tokens, duration_ms, created_at
+		FROM llm_attempts
+		WHERE problem_id = $1
+		ORDER BY created_at, id`
+	rows, err := h.DB.Query(query, id)
+	if err != nil {
+		log.Printf("Error querying attempts for problem %d: %v", id, err)
+		http.Error(w, "Failed to retrieve attempts", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	attempts := []LLMAttempt{}
+	for rows.Next() {
+		var a LLMAttempt
+		var errMsg sql.NullString
+		var promptTokens, candidatesTokens, durationMs sql.NullInt64
+		if err := rows.Scan(&a.Stage, &a.Model, &a.Attempt, &a.Outcome, &errMsg,
+			&promptTokens, &candidatesTokens, &durationMs, &a.CreatedAt); err != nil {
+			log.Printf("Error scanning attempt row: %v", err)
+			continue
+		}
+		a.ErrorMessage = errMsg.String
+		a.PromptTokens = int(promptTokens.Int64)
+		a.CandidatesTokens = int(candidatesTokens.Int64)
+		a.DurationMs = int(durationMs.Int64)
+		attempts = append(attempts, a)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Error iterating attempt rows: %v", err)
+		http.Error(w, "Failed to process attempts", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attempts)
+}
+
+// GetProblemSectionsHandler returns the per-section generation results of a
+// problem, including the token usage of each section.
+func (h *Handler) GetProblemSectionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid Problem ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		SELECT position, section_index, status, error_message,
+			COALESCE(jsonb_array_length(generated_questions->'questions'), 0),
+			prompt_tokens, candidates_tokens, started_at, completed_at
+		FROM section_generations
+		WHERE problem_id = $1
+		ORDER BY position`
+	rows, err := h.DB.Query(query, id)
+	if err != nil {
+		log.Printf("Error querying sections for problem %d: %v", id, err)
+		http.Error(w, "Failed to retrieve sections", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	sections := []SectionGeneration{}
+	for rows.Next() {
+		var s SectionGeneration
+		var sectionIndex, errMsg sql.NullString
+		var startedAt, completedAt sql.NullTime
+		if err := rows.Scan(&s.Position, &sectionIndex, &s.Status, &errMsg, &s.QuestionCount,
+			&s.PromptTokens, &s.CandidatesTokens, &startedAt, &completedAt); err != nil {
+			log.Printf("Error scanning section row: %v", err)
+			continue
+		}
+		s.SectionIndex = sectionIndex.String
+		s.ErrorMessage = errMsg.String
+		if startedAt.Valid {
+			s.StartedAt = &startedAt.Time
+		}
+		if completedAt.Valid {
+			s.CompletedAt = &completedAt.Time
+		}
+		sections = append(sections, s)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Error iterating section rows: %v", err)
+		http.Error(w, "Failed to process sections", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sections)
+}
+
+// GetProblemSourcesHandler lists the uploaded source files of a problem in
+// the order they are passed to structure extraction. The file contents are
+// not returned.
+func (h *Handler) GetProblemSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid Problem ID", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		SELECT position, role, file_name, mime_type, content_sha256, size_bytes, created_at
+		FROM problem_sources
+		WHERE problem_id = $1
+		ORDER BY position`
+	rows, err := h.DB.Query(query, id)
+	if err != nil {
+		log.Printf("Error querying sources for problem %d: %v", id, err)
+		http.Error(w, "Failed to retrieve sources", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	sources := []ProblemSource{}
+	for rows.Next() {
+		var s ProblemSource
+		if err := rows.Scan(&s.Position, &s.Role, &s.FileName, &s.MIMEType, &s.SHA256, &s.SizeBytes, &s.CreatedAt); err != nil {
+			log.Printf("Error scanning source row: %v", err)
+			continue
+		}
+		sources = append(sources, s)
+	}
+	if err = rows.Err(); err != nil {
+		log.Printf("Error iterating source rows: %v", err)
+		http.Error(w, "Failed to process sources", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sources)
+}
+
+// StreamProblemEventsHandler pushes the status and stage transitions of one
+// problem as Server-Sent Events. The current state is sent first, and the
+// stream ends once the problem reaches a final status.
+func (h *Handler) StreamProblemEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid Problem ID", http.StatusBadRequest)
+		return
+	}
+
+	// Subscribe before reading the current state so no transition is missed in between.
+	ch, unsubscribe, ok := h.subscribe(w, id)
+	if !ok {
+		return
+	}
+	defer unsubscribe()
+
+	current := events.Event{ProblemID: id, Stages: map[string]string{}}
+	var extractionStatus, generationStatus string
+	query := `SELECT processing_status, extraction_status, generation_status, updated_at FROM problems WHERE id = $1`
+	err = h.DB.QueryRow(query, id).Scan(&current.Status, &extractionStatus, &generationStatus, &current.UpdatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Problem not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying problem status for ID %d: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	current.Stages["extract_structure"] = extractionStatus
+	current.Stages["generate_problem"] = generationStatus
+	current.Phase = events.PhaseOf(current.Status, current.Stages)
+
+	flusher := startEventStream(w)
+	writeEvent(w, flusher, current)
+	if current.Terminal() {
+		return
+	}
+	streamEvents(w, r, flusher, ch, true)
+}
+
+// StreamAdminEventsHandler pushes the transitions of every problem as
+// Server-Sent Events, so the admin dashboard can refresh on change.
+func (h *Handler) StreamAdminEventsHandler(w http.ResponseWriter, r *http.Request) {
+	ch, unsubscribe, ok := h.subscribe(w, 0)
+	if !ok {
+		return
+	}
+	defer unsubscribe()
+
+	streamEvents(w, r, startEventStream(w), ch, false)
+}
+
+func (h *Handler) subscribe(w http.ResponseWriter, problemID int) (<-chan events.Event, func(), bool) {
+	if h.Events == nil {
+		http.Error(w, "Event streaming is not available", http.StatusServiceUnavailable)
+		return nil, nil, false
+	}
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	ch, unsubscribe := h.Events.Subscribe(problemID)
+	return ch, unsubscribe, true
+}
+
+func startEventStream(w http.ResponseWriter) http.Flusher {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher := w.(http.Flusher)
+	flusher.Flush()
+	return flusher
+}
+
+// streamEvents relays events until the client disconnects, or, if
+// stopOnTerminal is set, until a final status has been sent.
+func streamEvents(w http.ResponseWriter, r *http.Request, flusher http.Flusher, ch <-chan events.Event, stopOnTerminal bool) {
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-ch:
+			if err := writeEvent(w, flusher, ev); err != nil {
+				return
+			}
+			if stopOnTerminal && ev.Terminal() {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, flusher http.Flusher, ev events.Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", data); err != nil {
+		return err
+	}
+	flusher.Flush()
+	return nil
+}
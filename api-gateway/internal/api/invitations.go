@@ -0,0 +1,179 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/your-username/edumint/api-gateway/internal/auth"
+)
+
+// invitationPrefix starts every invitation token.
+const invitationPrefix = "edi_"
+
+// invitationTTL is how long an invitation can be accepted.
+const invitationTTL = 7 * 24 * time.Hour
+
+// Invitation lets the user with Email join an organization. The token is only
+// returned when the invitation is created; the organization admin passes it on.
+type Invitation struct {
+	ID             int       `json:"id"`
+	OrganizationID int       `json:"organization_id"`
+	Email          string    `json:"email"`
+	Role           string    `json:"role"`
+	Token          string    `json:"token,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// GetOrganizationInvitationsHandler lists the pending invitations of an organization.
+func (h *Handler) GetOrganizationInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	orgID, _ := strconv.Atoi(mux.Vars(r)["org_id"])
+	rows, err := h.DB.Query(`SELECT id, organization_id, email, role, created_at, expires_at
+		FROM organization_invitations
+		WHERE organization_id = $1 AND accepted_at IS NULL AND expires_at > NOW()
+		ORDER BY created_at DESC`, orgID)
+	if err != nil {
+		log.Printf("Error listing invitations of organization %d: %v", orgID, err)
+		http.Error(w, "Failed to retrieve invitations", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	invitations := []Invitation{}
+	for rows.Next() {
+		var inv Invitation
+		if err := rows.Scan(&inv.ID, &inv.OrganizationID, &inv.Email, &inv.Role, &inv.CreatedAt, &inv.ExpiresAt); err != nil {
+			log.Printf("Error scanning invitation row: %v", err)
+			continue
+		}
+		invitations = append(invitations, inv)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over invitation rows: %v", err)
+		http.Error(w, "Failed to process invitations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// CreateOrganizationInvitationHandler invites a user by email from a JSON body
+// {"email": "...", "role": "member"}. Only a signed-in user with that verified
+// email can accept it, so organization admins never pick users by ID.
+func (h *Handler) CreateOrganizationInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	orgID, _ := strconv.Atoi(mux.Vars(r)["org_id"])
+	var inv Invitation
+	if err := json.NewDecoder(r.Body).Decode(&inv); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	inv.Email = strings.TrimSpace(inv.Email)
+	if inv.Role == "" {
+		inv.Role = OrganizationRoleMember
+	}
+	if !strings.Contains(inv.Email, "@") || len(inv.Email) > 255 ||
+		(inv.Role != OrganizationRoleMember && inv.Role != OrganizationRoleAdmin) {
+		http.Error(w, "invalid request: email must be an email address and role must be member or admin", http.StatusBadRequest)
+		return
+	}
+
+	token, err := auth.NewSecret(invitationPrefix)
+	if err != nil {
+		log.Printf("Error creating invitation token: %v", err)
+		http.Error(w, "Failed to create invitation", http.StatusInternalServerError)
+		return
+	}
+	caller := auth.FromContext(r.Context())
+	inv.OrganizationID, inv.Token = orgID, token
+	err = h.DB.QueryRow(`INSERT INTO organization_invitations (organization_id, email, role, token_hash, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NOW() + $6 * INTERVAL '1 second')
+		RETURNING id, created_at, expires_at`,
+		orgID, inv.Email, inv.Role, auth.HashSecret(token), caller.UserID, int(invitationTTL.Seconds()),
+	).Scan(&inv.ID, &inv.CreatedAt, &inv.ExpiresAt)
+	if err != nil {
+		log.Printf("Error creating invitation to organization %d: %v", orgID, err)
+		http.Error(w, "Failed to create invitation", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %d invited a new %s to organization %d (invitation %d).", caller.UserID, inv.Role, orgID, inv.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(inv)
+}
+
+// DeleteOrganizationInvitationHandler withdraws a pending invitation of the organization.
+func (h *Handler) DeleteOrganizationInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orgID, _ := strconv.Atoi(vars["org_id"])
+	id, _ := strconv.Atoi(vars["id"])
+
+	res, err := h.DB.Exec(`DELETE FROM organization_invitations
+		WHERE id = $1 AND organization_id = $2 AND accepted_at IS NULL`, id, orgID)
+	if err != nil {
+		log.Printf("Error deleting invitation %d: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptInvitationHandler adds the caller to the organization of the
+// invitation in the JSON body {"token": "..."}, if it is still pending and
+// addressed to the caller's verified email. An existing admin keeps their role.
+func (h *Handler) AcceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !strings.HasPrefix(req.Token, invitationPrefix) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	caller := auth.FromContext(r.Context())
+	if caller.Email == "" {
+		http.Error(w, "Accepting an invitation requires an email address verified by your identity provider", http.StatusForbidden)
+		return
+	}
+
+	var orgID int
+	var role string
+	err := h.DB.QueryRow(`
+		WITH i AS (
+			UPDATE organization_invitations SET accepted_at = NOW(), accepted_by = $2
+			WHERE token_hash = $1 AND accepted_at IS NULL AND expires_at > NOW()
+				AND $3 <> '' AND LOWER(email) = LOWER($3)
+			RETURNING organization_id, role
+		)
+		INSERT INTO organization_members (organization_id, user_id, role)
+		SELECT organization_id, $2, role FROM i
+		ON CONFLICT (organization_id, user_id) DO UPDATE SET
+			role = CASE WHEN EXCLUDED.role = 'admin' THEN 'admin' ELSE organization_members.role END
+		RETURNING organization_id, role`,
+		auth.HashSecret(req.Token), caller.UserID, caller.Email,
+	).Scan(&orgID, &role)
+	if err == sql.ErrNoRows {
+		// Unknown, used, expired and misaddressed invitations look the same.
+		http.Error(w, "Invitation not found or not addressed to your verified email address", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error accepting invitation for user %d: %v", caller.UserID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %d joined organization %d as %s.", caller.UserID, orgID, role)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"organization_id": orgID, "user_id": caller.UserID, "role": role})
+}
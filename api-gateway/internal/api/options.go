@@ -0,0 +1,160 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Limits and accepted values for per-request generation options.
+const maxNumQuestions = 50
+
+var (
+	validDifficulties  = []string{"easy", "medium", "hard", "mixed"}
+	validQuestionTypes = []string{"multiple_choice", "true_false", "short_answer", "essay", "calculation", "proof"}
+	languageRegex      = regexp.MustCompile(`^[a-z]{2}(-[A-Z]{2})?$`)
+	modelNameRegex     = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:/-]{0,127}$`)
+)
+
+// GenerationOptions are the caller's preferences for one job. They are stored
+// in problems.generation_options and injected into the worker's prompts.
+// Zero values mean "let the model decide".
+type GenerationOptions struct {
+	NumQuestions  int      `json:"num_questions,omitempty"`
+	Difficulty    string   `json:"difficulty,omitempty"`
+	QuestionTypes []string `json:"question_types,omitempty"`
+	Language      string   `json:"language,omitempty"`
+	Latex         *bool    `json:"latex,omitempty"`
+	Model         string   `json:"model,omitempty"`
+	// Review pauses the job in 'awaiting_review' after extraction until the
+	// user approves the (possibly edited) structure.
+	Review bool `json:"review,omitempty"`
+}
+
+// GenerateRequest is the JSON body accepted by GenerateProblemHandler.
+type GenerateRequest struct {
+	Text string `json:"text"`
+	// Cache selects whether an earlier job with the same input may be reused
+	// (CacheNone, CacheStructure or CacheFull).
+	Cache string `json:"cache,omitempty"`
+	// CourseID creates the problem in a course the caller is a member of; 0
+	// creates a personal problem.
+	CourseID int `json:"course_id,omitempty"`
+	GenerationOptions
+}
+
+// ValidationError lists every invalid field of a request.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid request: " + strings.Join(e.Problems, "; ")
+}
+
+func (e *ValidationError) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// Validate checks the options and returns a *ValidationError if any are invalid.
+func (o *GenerationOptions) Validate() error {
+	verr := &ValidationError{}
+	if o.NumQuestions < 0 || o.NumQuestions > maxNumQuestions {
+		verr.add("num_questions must be between 1 and %d", maxNumQuestions)
+	}
+	if o.Difficulty != "" && !contains(validDifficulties, o.Difficulty) {
+		verr.add("difficulty must be one of %s", strings.Join(validDifficulties, ", "))
+	}
+	for _, t := range o.QuestionTypes {
+		if !contains(validQuestionTypes, t) {
+			verr.add("unknown question type '%s' (expected one of %s)", t, strings.Join(validQuestionTypes, ", "))
+		}
+	}
+	if o.Language != "" && !languageRegex.MatchString(o.Language) {
+		verr.add("language must be a language code such as 'ja' or 'en-US'")
+	}
+	if o.Model != "" {
+		if !modelNameRegex.MatchString(o.Model) {
+			verr.add("model name is invalid")
+		} else if allowed := allowedModels(); len(allowed) > 0 && !contains(allowed, o.Model) {
+			verr.add("model must be one of %s", strings.Join(allowed, ", "))
+		}
+	}
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// optionsFromForm reads generation options from multipart form fields.
+// question_types may be repeated or given as a comma-separated list.
+func optionsFromForm(form map[string][]string) (GenerationOptions, error) {
+	var o GenerationOptions
+	verr := &ValidationError{}
+	get := func(key string) string {
+		if v := form[key]; len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+		return ""
+	}
+
+	if v := get("num_questions"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			verr.add("num_questions must be an integer")
+		}
+		o.NumQuestions = n
+	}
+	if v := get("latex"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			verr.add("latex must be true or false")
+		}
+		o.Latex = &b
+	}
+	if v := get("review"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			verr.add("review must be true or false")
+		}
+		o.Review = b
+	}
+	o.Difficulty = get("difficulty")
+	o.Language = get("language")
+	o.Model = get("model")
+	for _, v := range form["question_types"] {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				o.QuestionTypes = append(o.QuestionTypes, t)
+			}
+		}
+	}
+
+	if len(verr.Problems) > 0 {
+		return o, verr
+	}
+	return o, nil
+}
+
+// allowedModels reads the optional ALLOWED_MODELS allow-list (comma-separated).
+// When it is empty any well-formed model name is accepted.
+func allowedModels() []string {
+	var models []string
+	for _, m := range strings.Split(os.Getenv("ALLOWED_MODELS"), ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
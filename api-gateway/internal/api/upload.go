@@ -0,0 +1,142 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Content types of the uploads accepted by GenerateProblemHandler. The worker
+// converts each of them to text before structure extraction.
+const (
+	MIMEPDF      = "application/pdf"
+	MIMEDOCX     = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	MIMEPPTX     = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	MIMEMarkdown = "text/markdown"
+	MIMETeX      = "application/x-tex"
+	MIMEText     = "text/plain"
+)
+
+// detectUploadType sniffs the content type of an uploaded file. The file name
+// is only used to tell text formats apart. It returns false for unsupported
+// files.
+func detectUploadType(filename string, data []byte) (string, bool) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	sniffed, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	switch sniffed {
+	case MIMEPDF:
+		return MIMEPDF, true
+	case "application/zip":
+		// DOCX and PPTX are zip archives; look at their main part to tell them apart.
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return "", false
+		}
+		for _, f := range zr.File {
+			switch f.Name {
+			case "word/document.xml":
+				return MIMEDOCX, true
+			case "ppt/presentation.xml":
+				return MIMEPPTX, true
+			}
+		}
+		return "", false
+	case MIMEText:
+		switch ext {
+		case ".md", ".markdown":
+			return MIMEMarkdown, true
+		case ".tex":
+			return MIMETeX, true
+		}
+		if bytes.Contains(data, []byte(`\documentclass`)) || bytes.Contains(data, []byte(`\begin{document}`)) {
+			return MIMETeX, true
+		}
+		return MIMEText, true
+	case "text/html":
+		// Markdown may contain inline HTML and is sniffed as HTML when it starts with a tag.
+		if ext == ".md" || ext == ".markdown" {
+			return MIMEMarkdown, true
+		}
+	}
+	return "", false
+}
+
+// Roles of the source files of a job, stored in problem_sources.role. Lecture
+// notes define what the exam covers; a sample exam shows its format and level.
+const (
+	SourceRoleLectureNotes = "lecture_notes"
+	SourceRoleSampleExam   = "sample_exam"
+)
+
+// maxSources is the maximum number of files uploaded with one job.
+const maxSources = 10
+
+// SourceUpload is one uploaded source file of a job.
+type SourceUpload struct {
+	FileName string
+	MIMEType string
+	Role     string
+	Data     []byte
+}
+
+// readSources reads the files of a multipart form in upload order. Files are
+// sent as repeated "file" fields ("pdfFile" is accepted for older clients);
+// their roles are given by "roles" fields (repeated or comma-separated) in the
+// same order and default to lecture notes. On failure it writes an error
+// response and returns false.
+func readSources(w http.ResponseWriter, form *multipart.Form) ([]SourceUpload, bool) {
+	headers := append(form.File["file"], form.File["pdfFile"]...)
+	if len(headers) == 0 {
+		http.Error(w, "Invalid file in form data", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(headers) > maxSources {
+		http.Error(w, fmt.Sprintf("invalid request: at most %d files can be uploaded per job", maxSources), http.StatusBadRequest)
+		return nil, false
+	}
+	var roles []string
+	for _, v := range form.Value["roles"] {
+		for _, role := range strings.Split(v, ",") {
+			roles = append(roles, strings.TrimSpace(role))
+		}
+	}
+	if len(roles) > len(headers) {
+		http.Error(w, fmt.Sprintf("invalid request: %d roles given for %d files", len(roles), len(headers)), http.StatusBadRequest)
+		return nil, false
+	}
+
+	sources := make([]SourceUpload, len(headers))
+	for i, header := range headers {
+		role := SourceRoleLectureNotes
+		if i < len(roles) && roles[i] != "" {
+			role = roles[i]
+		}
+		if role != SourceRoleLectureNotes && role != SourceRoleSampleExam {
+			http.Error(w, fmt.Sprintf("invalid request: role '%s' must be one of %s, %s", role, SourceRoleLectureNotes, SourceRoleSampleExam), http.StatusBadRequest)
+			return nil, false
+		}
+		file, err := header.Open()
+		if err != nil {
+			http.Error(w, "Invalid file in form data", http.StatusBadRequest)
+			return nil, false
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			http.Error(w, "Failed to read uploaded file", http.StatusInternalServerError)
+			return nil, false
+		}
+		mimeType, ok := detectUploadType(header.Filename, data)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unsupported file type of '%s': upload a PDF, DOCX, PPTX, Markdown, LaTeX or text file", header.Filename), http.StatusUnsupportedMediaType)
+			return nil, false
+		}
+		sources[i] = SourceUpload{FileName: header.Filename, MIMEType: mimeType, Role: role, Data: data}
+	}
+	return sources, true
+}
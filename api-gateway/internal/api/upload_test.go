@@ -0,0 +1,56 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+)
+
+// zipWith returns a zip archive containing empty files with the given names.
+func zipWith(t *testing.T, names ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		if _, err := zw.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectUploadType(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		data     []byte
+		want     string
+		ok       bool
+	}{
+		{name: "pdf", filename: "notes.pdf", data: []byte("%PDF-1.7\n"), want: MIMEPDF, ok: true},
+		{name: "pdf regardless of extension", filename: "notes.txt", data: []byte("%PDF-1.4\n"), want: MIMEPDF, ok: true},
+		{name: "docx", filename: "notes.docx", data: zipWith(t, "[Content_Types].xml", "word/document.xml"), want: MIMEDOCX, ok: true},
+		{name: "pptx", filename: "slides.pptx", data: zipWith(t, "[Content_Types].xml", "ppt/presentation.xml"), want: MIMEPPTX, ok: true},
+		{name: "other zip archives", filename: "notes.docx", data: zipWith(t, "notes.txt")},
+		{name: "markdown", filename: "notes.md", data: []byte("# 第1回\n本文"), want: MIMEMarkdown, ok: true},
+		{name: "markdown extension is case-insensitive", filename: "NOTES.MARKDOWN", data: []byte("本文"), want: MIMEMarkdown, ok: true},
+		{name: "markdown starting with HTML", filename: "notes.md", data: []byte("<div align=\"center\">\n\n# 第1回\n</div>"), want: MIMEMarkdown, ok: true},
+		{name: "tex by extension", filename: "exam.tex", data: []byte("問1 $x^2$"), want: MIMETeX, ok: true},
+		{name: "tex by content", filename: "exam.txt", data: []byte("\\documentclass{article}\n\\begin{document}\n\\end{document}"), want: MIMETeX, ok: true},
+		{name: "plain text", filename: "notes.txt", data: []byte("講義ノート"), want: MIMEText, ok: true},
+		{name: "HTML", filename: "page.txt", data: []byte("<html><body>講義</body></html>")},
+		{name: "image", filename: "scan.png", data: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := detectUploadType(tt.filename, tt.data)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("detectUploadType(%q) = %q, %t, want %q, %t", tt.filename, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
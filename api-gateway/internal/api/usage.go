@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// CourseUsage sums up the jobs of a course and the tokens they used.
+type CourseUsage struct {
+	CourseID           int    `json:"course_id"`
+	OrganizationID     int    `json:"organization_id"`
+	CourseCode         string `json:"course_code"`
+	CourseName         string `json:"course_name"`
+	Problems           int    `json:"problems"`
+	PromptTokens       int    `json:"prompt_tokens"`
+	CandidatesTokens   int    `json:"candidates_tokens"`
+	RegenerationTokens int    `json:"regeneration_tokens"`
+	TotalTokens        int    `json:"total_tokens"`
+	SavedTokens        int    `json:"saved_tokens"`
+}
+
+// GetUsageHandler reports the token usage of every course, or of the course
+// given by the optional course_id query parameter.
+func (h *Handler) GetUsageHandler(w http.ResponseWriter, r *http.Request) {
+	courseID := 0
+	if v := r.URL.Query().Get("course_id"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid course_id", http.StatusBadRequest)
+			return
+		}
+		courseID = n
+	}
+	h.writeUsage(w, courseID)
+}
+
+// GetCourseUsageHandler reports the token usage of one course for its staff.
+func (h *Handler) GetCourseUsageHandler(w http.ResponseWriter, r *http.Request) {
+	courseID, _ := strconv.Atoi(mux.Vars(r)["course_id"])
+	h.writeUsage(w, courseID)
+}
+
+// writeUsage writes the usage of courseID, or of every course if it is 0.
+func (h *Handler) writeUsage(w http.ResponseWriter, courseID int) {
+	query := `
+		SELECT
+			c.id, c.organization_id, c.code, c.name,
+			COUNT(p.id),
+			COALESCE(SUM(COALESCE(p.structure_prompt_tokens, 0) + COALESCE(p.generation_prompt_tokens, 0)), 0),
+			COALESCE(SUM(COALESCE(p.structure_candidates_tokens, 0) + COALESCE(p.generation_candidates_tokens, 0)), 0),
+			COALESCE(SUM(regenerations.tokens), 0),
+			COALESCE(SUM(p.saved_prompt_tokens + p.saved_candidates_tokens), 0)
+		FROM courses c
+		LEFT JOIN problems p ON p.course_id = c.id
+		LEFT JOIN LATERAL (
+			SELECT COALESCE(SUM(COALESCE(prompt_tokens, 0) + COALESCE(candidates_tokens, 0)), 0) AS tokens
+			FROM question_regenerations WHERE problem_id = p.id
+		) regenerations ON true
+		WHERE $1 = 0 OR c.id = $1
+		GROUP BY c.id
+		ORDER BY c.organization_id, c.code`
+	rows, err := h.DB.Query(query, courseID)
+	if err != nil {
+		log.Printf("Error querying course usage: %v", err)
+		http.Error(w, "Failed to retrieve usage", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	usage := []CourseUsage{}
+	for rows.Next() {
+		var u CourseUsage
+		if err := rows.Scan(&u.CourseID, &u.OrganizationID, &u.CourseCode, &u.CourseName, &u.Problems,
+			&u.PromptTokens, &u.CandidatesTokens, &u.RegenerationTokens, &u.SavedTokens); err != nil {
+			log.Printf("Error scanning usage row: %v", err)
+			continue
+		}
+		u.TotalTokens = u.PromptTokens + u.CandidatesTokens + u.RegenerationTokens
+		usage = append(usage, u)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over usage rows: %v", err)
+		http.Error(w, "Failed to process usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/your-username/edumint/api-gateway/internal/auth"
+)
+
+// User is a caller known to the gateway, as listed for admins.
+type User struct {
+	ID         int       `json:"id"`
+	Issuer     string    `json:"issuer"`
+	Subject    string    `json:"subject"`
+	Email      string    `json:"email,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Role       string    `json:"role"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// GetUsersHandler lists every user with their role.
+func (h *Handler) GetUsersHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.DB.Query(`SELECT id, issuer, subject, COALESCE(email, ''), COALESCE(name, ''), role, created_at, last_seen_at
+		FROM users ORDER BY id`)
+	if err != nil {
+		log.Printf("Error listing users: %v", err)
+		http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Issuer, &u.Subject, &u.Email, &u.Name, &u.Role, &u.CreatedAt, &u.LastSeenAt); err != nil {
+			log.Printf("Error scanning user row: %v", err)
+			continue
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over user rows: %v", err)
+		http.Error(w, "Failed to process users", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// UpdateUserRoleHandler changes the role of a user from a JSON body {"role": "instructor"}.
+// Admins cannot change their own role, so there is always at least one admin left.
+func (h *Handler) UpdateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid User ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !auth.ValidRole(req.Role) {
+		http.Error(w, "invalid request: role must be student, instructor or admin", http.StatusBadRequest)
+		return
+	}
+	caller := auth.FromContext(r.Context())
+	if id == caller.UserID {
+		http.Error(w, "Admins cannot change their own role", http.StatusConflict)
+		return
+	}
+
+	err = h.DB.QueryRow(`UPDATE users SET role = $1 WHERE id = $2 RETURNING id`, req.Role, id).Scan(&id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating role of user %d: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %d changed the role of user %d to %s.", caller.UserID, id, req.Role)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"user_id": id, "role": req.Role})
+}
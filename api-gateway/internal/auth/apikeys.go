@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// APIKeyPrefix starts every API key, telling them apart from JWTs.
+const APIKeyPrefix = "edm_"
+
+// displayPrefixLength is how much of a key is kept in clear text so that
+// users can recognize their keys in listings.
+const displayPrefixLength = 12
+
+// Owner of the key configured with AUTH_BOOTSTRAP_API_KEY.
+const (
+	bootstrapIssuer  = "edumint"
+	bootstrapSubject = "bootstrap"
+)
+
+// NewAPIKey returns a new random API key. Only its hash is stored; the key
+// itself is shown to the user once.
+func NewAPIKey() (string, error) {
+	return NewSecret(APIKeyPrefix)
+}
+
+// HashAPIKey returns the hex SHA-256 under which key is stored.
+func HashAPIKey(key string) string {
+	return HashSecret(key)
+}
+
+// NewSecret returns prefix followed by 32 random bytes in base64url, for
+// bearer secrets such as API keys and invitation tokens.
+func NewSecret(prefix string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashSecret returns the hex SHA-256 under which a secret is stored.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// DisplayPrefix returns the leading part of key that is stored in clear text.
+func DisplayPrefix(key string) string {
+	if len(key) > displayPrefixLength {
+		return key[:displayPrefixLength]
+	}
+	return key
+}
+
+// authenticateAPIKey looks up an unrevoked key by its hash and records its use.
+func (a *Authenticator) authenticateAPIKey(ctx context.Context, key string) (*Identity, error) {
+	id := &Identity{Method: MethodAPIKey}
+	err := a.DB.QueryRowContext(ctx, `
+		WITH k AS (
+			UPDATE api_keys SET last_used_at = NOW()
+			WHERE key_hash = $1 AND revoked_at IS NULL
+			RETURNING id, user_id
+		)
+		SELECT k.id, u.id, u.issuer, u.subject, COALESCE(u.email, ''), COALESCE(u.name, ''), u.role
+		FROM k JOIN users u ON u.id = k.user_id`,
+		HashAPIKey(key),
+	).Scan(&id.APIKeyID, &id.UserID, &id.Issuer, &id.Subject, &id.Email, &id.Name, &id.Role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up API key: %w", err)
+	}
+	return id, nil
+}
+
+// EnsureBootstrapKey registers key, typically from AUTH_BOOTSTRAP_API_KEY, for
+// a built-in admin so that scripts and fresh deployments can call the API
+// before anyone has signed in. Registering the same key again does nothing.
+func (a *Authenticator) EnsureBootstrapKey(ctx context.Context, key string) error {
+	if !strings.HasPrefix(key, APIKeyPrefix) || len(key) < len(APIKeyPrefix)+16 {
+		return fmt.Errorf("bootstrap API key must start with %q and be at least %d characters long", APIKeyPrefix, len(APIKeyPrefix)+16)
+	}
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO users (issuer, subject, name, role) VALUES ($1, $2, 'Bootstrap', $3)
+		ON CONFLICT (issuer, subject) DO UPDATE SET role = EXCLUDED.role
+		RETURNING id`,
+		bootstrapIssuer, bootstrapSubject, RoleAdmin,
+	).Scan(&userID)
+	if err != nil {
+		return fmt.Errorf("failed to create bootstrap user: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash) VALUES ($1, 'bootstrap', $2, $3)
+		ON CONFLICT (key_hash) DO NOTHING`,
+		userID, DisplayPrefix(key), HashAPIKey(key),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register bootstrap API key: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Registered bootstrap API key %s...", DisplayPrefix(key))
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,159 @@
+// Package auth identifies the caller of every API request. Browsers send a JWT
+// issued by an OpenID Connect provider, scripts send an API key; either way the
+// caller is recorded in the users table and attached to the request context.
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// Authentication methods reported in Identity.Method.
+const (
+	MethodOIDC   = "oidc"
+	MethodAPIKey = "api_key"
+)
+
+// ErrUnauthenticated is returned for missing, malformed, expired or revoked credentials.
+var ErrUnauthenticated = errors.New("invalid or expired credentials")
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	UserID  int    `json:"user_id"`
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+	Email   string `json:"email,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Role    string `json:"role"`
+	Method  string `json:"method"`
+	// APIKeyID is the key used for the request when Method is MethodAPIKey.
+	APIKeyID int `json:"api_key_id,omitempty"`
+}
+
+type contextKey struct{}
+
+// WithIdentity returns a copy of ctx carrying id.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the caller attached by the middleware, or nil.
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(contextKey{}).(*Identity)
+	return id
+}
+
+// Authenticator checks the credentials of incoming requests.
+type Authenticator struct {
+	DB *sql.DB
+	// Verifier validates OIDC tokens; nil when no issuer is configured, in
+	// which case only API keys are accepted.
+	Verifier *oidc.IDTokenVerifier
+}
+
+// FromEnv builds an authenticator from OIDC_ISSUER_URL (the expected "iss" of
+// tokens) and OIDC_AUDIENCE (the expected "aud"; not checked when empty).
+// OIDC_DISCOVERY_URL fetches the provider metadata from another address than
+// the issuer, e.g. a local issuer reached through the Docker network.
+func FromEnv(ctx context.Context, db *sql.DB) (*Authenticator, error) {
+	a := &Authenticator{DB: db}
+	issuer := os.Getenv("OIDC_ISSUER_URL")
+	if issuer == "" {
+		log.Println("OIDC_ISSUER_URL is not set; only API keys are accepted")
+		return a, nil
+	}
+
+	discovery := os.Getenv("OIDC_DISCOVERY_URL")
+	if discovery == "" {
+		discovery = issuer
+	} else {
+		ctx = oidc.InsecureIssuerURLContext(ctx, issuer)
+	}
+
+	// The provider may still be starting alongside the gateway.
+	var provider *oidc.Provider
+	var err error
+	for i := 0; i < 5; i++ {
+		if provider, err = oidc.NewProvider(ctx, discovery); err == nil {
+			break
+		}
+		log.Printf("OIDC discovery failed: %v. Retrying...", err)
+		time.Sleep(3 * time.Second)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider %s: %w", discovery, err)
+	}
+
+	audience := os.Getenv("OIDC_AUDIENCE")
+	a.Verifier = provider.Verifier(&oidc.Config{ClientID: audience, SkipClientIDCheck: audience == ""})
+	log.Printf("Accepting OIDC tokens issued by %s", issuer)
+	return a, nil
+}
+
+// Authenticate resolves a bearer token to the caller it belongs to.
+func (a *Authenticator) Authenticate(ctx context.Context, token string) (*Identity, error) {
+	if strings.HasPrefix(token, APIKeyPrefix) {
+		return a.authenticateAPIKey(ctx, token)
+	}
+	if a.Verifier == nil {
+		return nil, ErrUnauthenticated
+	}
+	return a.authenticateOIDC(ctx, token)
+}
+
+// Middleware rejects requests without valid credentials and attaches the
+// caller's Identity to the context of the others. Event streams may be opened
+// with a stream ticket instead (see NewStreamTicket). CORS preflight requests
+// carry no credentials and are passed through.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var id *Identity
+		var err error
+		if token := bearerToken(r); token != "" {
+			id, err = a.Authenticate(r.Context(), token)
+		} else if ticket := streamTicket(r); ticket != "" {
+			id, err = a.redeemStreamTicket(r.Context(), ticket, r.URL.Path)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="edumint"`)
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, ErrUnauthenticated) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="edumint", error="invalid_token"`)
+			http.Error(w, "Invalid or expired credentials", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("Error authenticating request to %s: %v", r.URL.Path, err)
+			http.Error(w, "Failed to authenticate request", http.StatusInternalServerError)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+	})
+}
+
+// bearerToken returns the token of the Authorization header. Tokens are never
+// read from the URL, where they would end up in logs and browser history.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		accept string
+		target string
+		want   string
+	}{
+		{name: "bearer", header: "Bearer abc", target: "/", want: "abc"},
+		{name: "scheme is case-insensitive", header: "bearer  abc ", target: "/", want: "abc"},
+		{name: "other scheme", header: "Basic abc", target: "/"},
+		{name: "no scheme", header: "abc", target: "/"},
+		{name: "header wins over the query", header: "Bearer abc", accept: "text/event-stream", target: "/?access_token=xyz", want: "abc"},
+		// Event streams authenticate with stream tickets, never with tokens in the URL.
+		{name: "query token of an event stream is ignored", accept: "text/event-stream", target: "/?access_token=abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			r.Header.Set("Accept", tt.accept)
+			if got := bearerToken(r); got != tt.want {
+				t.Errorf("bearerToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package auth
+
+import "testing"
+
+func TestVerifiedEmail(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name     string
+		verified *bool
+		want     string
+	}{
+		{name: "verified", verified: &yes, want: "user@example.com"},
+		{name: "unverified", verified: &no},
+		{name: "claim missing", verified: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := oidcClaims{Email: "user@example.com", EmailVerified: tt.verified}
+			if got := c.verifiedEmail(); got != tt.want {
+				t.Errorf("verifiedEmail() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
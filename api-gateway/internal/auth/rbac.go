@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"net/http"
+)
+
+// Roles of users (users.role). New users are students until an admin promotes them.
+const (
+	RoleStudent    = "student"
+	RoleInstructor = "instructor"
+	RoleAdmin      = "admin"
+)
+
+// Permission is an action that routes can require with RequirePermission.
+type Permission string
+
+const (
+	// PermCreateProblems allows generating problems from one's own material.
+	PermCreateProblems Permission = "problems:create"
+	// PermCreateBlueprints allows creating exams directly from a blueprint.
+	PermCreateBlueprints Permission = "blueprints:create"
+	// PermReadAllProblems allows viewing and managing problems of any owner.
+	PermReadAllProblems Permission = "problems:read_all"
+	// PermAdmin allows the /admin endpoints: job history, dead letters and users.
+	PermAdmin Permission = "admin"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleStudent:    {PermCreateProblems},
+	RoleInstructor: {PermCreateProblems, PermCreateBlueprints},
+	RoleAdmin:      {PermCreateProblems, PermCreateBlueprints, PermReadAllProblems, PermAdmin},
+}
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Can reports whether the caller's role grants p.
+func (id *Identity) Can(p Permission) bool {
+	if id == nil {
+		return false
+	}
+	for _, granted := range rolePermissions[id.Role] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission returns a middleware that rejects callers without p. It
+// must run after Authenticator.Middleware.
+func RequirePermission(p Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodOptions && !FromContext(r.Context()).Can(p) {
+				http.Error(w, "Permission denied: requires "+string(p), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
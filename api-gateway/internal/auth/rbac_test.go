@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCan(t *testing.T) {
+	all := []Permission{PermCreateProblems, PermCreateBlueprints, PermReadAllProblems, PermAdmin}
+
+	tests := []struct {
+		name string
+		id   *Identity
+		want []Permission
+	}{
+		{name: "no identity", id: nil},
+		{name: "unknown role", id: &Identity{Role: "guest"}},
+		{name: "student", id: &Identity{Role: RoleStudent}, want: []Permission{PermCreateProblems}},
+		{name: "instructor", id: &Identity{Role: RoleInstructor}, want: []Permission{PermCreateProblems, PermCreateBlueprints}},
+		{name: "admin", id: &Identity{Role: RoleAdmin}, want: all},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			granted := map[Permission]bool{}
+			for _, p := range tt.want {
+				granted[p] = true
+			}
+			for _, p := range all {
+				if got := tt.id.Can(p); got != granted[p] {
+					t.Errorf("Can(%s) = %t, want %t", p, got, granted[p])
+				}
+			}
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		id     *Identity
+		want   int
+	}{
+		{name: "granted", method: http.MethodPost, id: &Identity{Role: RoleInstructor}, want: http.StatusOK},
+		{name: "denied", method: http.MethodPost, id: &Identity{Role: RoleStudent}, want: http.StatusForbidden},
+		{name: "unauthenticated", method: http.MethodPost, want: http.StatusForbidden},
+		{name: "preflight passes", method: http.MethodOptions, want: http.StatusOK},
+	}
+
+	handler := RequirePermission(PermCreateBlueprints)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/v1/problems/blueprint", nil)
+			if tt.id != nil {
+				r = r.WithContext(WithIdentity(r.Context(), tt.id))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// StreamTicketPrefix starts every stream ticket, telling them apart from API keys and JWTs.
+const StreamTicketPrefix = "est_"
+
+// StreamTicketTTL is how long a stream ticket can be used after it was issued.
+const StreamTicketTTL = time.Minute
+
+// NewStreamTicket issues a ticket that opens the event stream at path once, as
+// the caller id. EventSource cannot set headers, so browsers pass the ticket
+// as ?ticket= instead of their token, which then never appears in URLs or logs.
+func NewStreamTicket(ctx context.Context, db *sql.DB, id *Identity, path string) (string, time.Time, error) {
+	ticket, err := NewSecret(StreamTicketPrefix)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	// Tickets are only useful for a minute; drop the expired ones on the way.
+	if _, err := db.ExecContext(ctx, `DELETE FROM stream_tickets WHERE expires_at < NOW()`); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to delete expired stream tickets: %w", err)
+	}
+	var expiresAt time.Time
+	err = db.QueryRowContext(ctx, `
+		INSERT INTO stream_tickets (token_hash, user_id, api_key_id, method, path, expires_at)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5, NOW() + $6 * INTERVAL '1 second')
+		RETURNING expires_at`,
+		HashSecret(ticket), id.UserID, id.APIKeyID, id.Method, path, int(StreamTicketTTL.Seconds()),
+	).Scan(&expiresAt)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to store stream ticket: %w", err)
+	}
+	return ticket, expiresAt, nil
+}
+
+// redeemStreamTicket resolves an unused, unexpired ticket issued for path to
+// the caller it was issued to, and marks it as used. Tickets issued with an
+// API key stop working when the key is revoked.
+func (a *Authenticator) redeemStreamTicket(ctx context.Context, ticket, path string) (*Identity, error) {
+	id := &Identity{}
+	err := a.DB.QueryRowContext(ctx, `
+		WITH t AS (
+			UPDATE stream_tickets SET used_at = NOW()
+			WHERE token_hash = $1 AND path = $2 AND used_at IS NULL AND expires_at > NOW()
+			RETURNING user_id, api_key_id, method
+		)
+		SELECT t.method, COALESCE(t.api_key_id, 0), u.id, u.issuer, u.subject, COALESCE(u.email, ''), COALESCE(u.name, ''), u.role
+		FROM t JOIN users u ON u.id = t.user_id
+		LEFT JOIN api_keys k ON k.id = t.api_key_id
+		WHERE t.api_key_id IS NULL OR k.revoked_at IS NULL`,
+		HashSecret(ticket), path,
+	).Scan(&id.Method, &id.APIKeyID, &id.UserID, &id.Issuer, &id.Subject, &id.Email, &id.Name, &id.Role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up stream ticket: %w", err)
+	}
+	return id, nil
+}
+
+// streamTicket returns the ?ticket= of an event stream request. Other
+// requests, and query values that are not stream tickets (such as API keys
+// or JWTs), are ignored.
+func streamTicket(r *http.Request) string {
+	if r.Method != http.MethodGet || !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return ""
+	}
+	if ticket := r.URL.Query().Get("ticket"); strings.HasPrefix(ticket, StreamTicketPrefix) {
+		return ticket
+	}
+	return ""
+}
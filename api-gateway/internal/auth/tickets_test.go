@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStreamTicket(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		accept string
+		target string
+		want   string
+	}{
+		{name: "event stream", method: http.MethodGet, accept: "text/event-stream", target: "/api/v1/admin/events?ticket=est_abc", want: "est_abc"},
+		{name: "accept list", method: http.MethodGet, accept: "text/html, text/event-stream", target: "/events?ticket=est_abc", want: "est_abc"},
+		{name: "not an event stream", method: http.MethodGet, accept: "application/json", target: "/events?ticket=est_abc"},
+		{name: "not a GET", method: http.MethodPost, accept: "text/event-stream", target: "/events?ticket=est_abc"},
+		{name: "API key in the query", method: http.MethodGet, accept: "text/event-stream", target: "/events?ticket=" + APIKeyPrefix + "abc"},
+		{name: "old access_token parameter", method: http.MethodGet, accept: "text/event-stream", target: "/events?access_token=est_abc"},
+		{name: "no ticket", method: http.MethodGet, accept: "text/event-stream", target: "/events"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			r.Header.Set("Accept", tt.accept)
+			if got := streamTicket(r); got != tt.want {
+				t.Errorf("streamTicket() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Package events fans out problem status changes, published by Postgres
+// LISTEN/NOTIFY, to streaming HTTP clients.
+package events
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Channel is the Postgres notification channel written by the problems trigger in db/init.sql.
+const Channel = "problem_events"
+
+// Event is a status or stage transition of one problem.
+type Event struct {
+	ProblemID int               `json:"problem_id"`
+	Status    string            `json:"status"`
+	Phase     string            `json:"phase"`
+	Stages    map[string]string `json:"stages"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// Terminal reports whether no further events will follow for the problem.
+func (e Event) Terminal() bool {
+	switch e.Status {
+	case "completed", "failed", "cancelled":
+		return true
+	}
+	return false
+}
+
+// PhaseOf summarizes a problem's status and stage statuses as a single phase:
+// pending, extracting, generating, completed, failed or cancelled.
+func PhaseOf(status string, stages map[string]string) string {
+	if status == "processing" {
+		if stages["extract_structure"] == "running" {
+			return "extracting"
+		}
+		if stages["generate_problem"] == "running" {
+			return "generating"
+		}
+	}
+	return status
+}
+
+type subscriber struct {
+	problemID int // 0 subscribes to every problem
+	ch        chan Event
+}
+
+// Hub listens for notifications and delivers them to subscribers.
+type Hub struct {
+	listener *pq.Listener
+
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+// NewHub starts listening on Channel using a dedicated connection to dsn.
+func NewHub(dsn string) (*Hub, error) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Event listener connection problem: %v", err)
+		}
+	})
+	if err := listener.Listen(Channel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	h := &Hub{listener: listener, subs: map[*subscriber]struct{}{}}
+	go h.run()
+	return h, nil
+}
+
+func (h *Hub) run() {
+	for {
+		select {
+		case n, ok := <-h.listener.Notify:
+			if !ok {
+				return
+			}
+			if n == nil {
+				// The connection was re-established; notifications in between are lost.
+				continue
+			}
+			var ev Event
+			if err := json.Unmarshal([]byte(n.Extra), &ev); err != nil {
+				log.Printf("Ignoring malformed event payload: %v", err)
+				continue
+			}
+			ev.Phase = PhaseOf(ev.Status, ev.Stages)
+			h.broadcast(ev)
+		case <-time.After(90 * time.Second):
+			// Make sure a silently dropped connection is detected.
+			go h.listener.Ping()
+		}
+	}
+}
+
+func (h *Hub) broadcast(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if s.problemID != 0 && s.problemID != ev.ProblemID {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+			// Slow consumers miss intermediate events rather than blocking everyone.
+		}
+	}
+}
+
+// Subscribe returns a channel of events for problemID (0 for all problems) and
+// a function that must be called to unsubscribe.
+func (h *Hub) Subscribe(problemID int) (<-chan Event, func()) {
+	s := &subscriber{problemID: problemID, ch: make(chan Event, 16)}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+
+	return s.ch, func() {
+		h.mu.Lock()
+		delete(h.subs, s)
+		h.mu.Unlock()
+	}
+}
+
+// Close stops listening for notifications.
+func (h *Hub) Close() error {
+	return h.listener.Close()
+}
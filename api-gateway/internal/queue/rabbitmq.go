@@ -1,6 +1,7 @@
 package queue
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -9,6 +10,22 @@ import (
 	"github.com/streadway/amqp"
 )
 
+// Header names set by the worker on retried and dead-lettered jobs.
+const (
+	retryCountHeader     = "x-retry-count"
+	lastErrorHeader      = "x-last-error"
+	deadLetteredAtHeader = "x-dead-lettered-at"
+)
+
+// DeadLetter is a job the worker gave up on and moved to the dead-letter queue.
+type DeadLetter struct {
+	ProblemID      int             `json:"problem_id"`
+	RetryCount     int             `json:"retry_count"`
+	LastError      string          `json:"last_error"`
+	DeadLetteredAt string          `json:"dead_lettered_at"`
+	Body           json.RawMessage `json:"body"`
+}
+
 type Client struct {
 	conn *amqp.Connection
 	ch   *amqp.Channel
@@ -62,6 +79,105 @@ func (c *Client) Publish(queueName string, body []byte) error {
 	)
 }
 
+// deadLetterQueue returns the name of the queue the worker dead-letters jobs of queueName into.
+func deadLetterQueue(queueName string) string { return queueName + ".dead" }
+
+// ListDeadLetters returns up to limit dead-lettered jobs without removing them.
+// Messages are fetched on a dedicated channel and returned to the queue when it closes.
+func (c *Client) ListDeadLetters(queueName string, limit int) ([]DeadLetter, error) {
+	ch, err := c.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer ch.Close()
+
+	dlq := deadLetterQueue(queueName)
+	if _, err := ch.QueueDeclare(dlq, true, false, false, false, nil); err != nil {
+		return nil, fmt.Errorf("failed to declare dead-letter queue: %w", err)
+	}
+
+	letters := []DeadLetter{}
+	for len(letters) < limit {
+		d, ok, err := ch.Get(dlq, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dead-letter queue: %w", err)
+		}
+		if !ok {
+			break
+		}
+		letters = append(letters, toDeadLetter(d))
+	}
+	return letters, nil
+}
+
+// ReplayDeadLetters moves dead-lettered jobs back onto queueName with a fresh retry count.
+// When problemID is non-zero only jobs for that problem are replayed. It returns
+// the problem IDs of the replayed jobs.
+func (c *Client) ReplayDeadLetters(queueName string, problemID, limit int) ([]int, error) {
+	ch, err := c.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer ch.Close()
+
+	dlq := deadLetterQueue(queueName)
+	if _, err := ch.QueueDeclare(dlq, true, false, false, false, nil); err != nil {
+		return nil, fmt.Errorf("failed to declare dead-letter queue: %w", err)
+	}
+
+	replayed := []int{}
+	for seen := 0; seen < limit; seen++ {
+		d, ok, err := ch.Get(dlq, false)
+		if err != nil {
+			return replayed, fmt.Errorf("failed to read dead-letter queue: %w", err)
+		}
+		if !ok {
+			break
+		}
+		letter := toDeadLetter(d)
+		if problemID != 0 && letter.ProblemID != problemID {
+			continue // left unacknowledged, requeued when the channel closes
+		}
+		err = ch.Publish("", queueName, false, false, amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			ContentType:  "application/json",
+			Body:         d.Body,
+		})
+		if err != nil {
+			return replayed, fmt.Errorf("failed to republish dead-lettered job: %w", err)
+		}
+		if err := d.Ack(false); err != nil {
+			return replayed, fmt.Errorf("failed to ack dead-lettered job: %w", err)
+		}
+		replayed = append(replayed, letter.ProblemID)
+	}
+	return replayed, nil
+}
+
+func toDeadLetter(d amqp.Delivery) DeadLetter {
+	letter := DeadLetter{Body: json.RawMessage("null")}
+	var job struct {
+		ProblemID int `json:"problem_id"`
+	}
+	if json.Unmarshal(d.Body, &job) == nil {
+		letter.ProblemID = job.ProblemID
+		letter.Body = json.RawMessage(d.Body)
+	} else {
+		// Not valid JSON: expose the raw body as a string instead.
+		raw, _ := json.Marshal(string(d.Body))
+		letter.Body = raw
+	}
+	switch v := d.Headers[retryCountHeader].(type) {
+	case int32:
+		letter.RetryCount = int(v)
+	case int64:
+		letter.RetryCount = int(v)
+	}
+	letter.LastError, _ = d.Headers[lastErrorHeader].(string)
+	letter.DeadLetteredAt, _ = d.Headers[deadLetteredAtHeader].(string)
+	return letter
+}
+
 // Close gracefully closes the channel and connection.
 func (c *Client) Close() {
 	if c.ch != nil {
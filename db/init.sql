@@ -1,17 +1,114 @@
 -- db/init.sql
 
-CREATE TYPE processing_status AS ENUM ('pending', 'processing', 'completed', 'failed');
+CREATE TYPE processing_status AS ENUM ('pending', 'processing', 'awaiting_review', 'completed', 'failed', 'cancelled');
+CREATE TYPE stage_status AS ENUM ('pending', 'running', 'completed', 'failed', 'skipped', 'cancelled');
+
+-- APIの利用者 (OIDCプロバイダが発行したトークンの iss と sub で識別する)
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    name VARCHAR(255),
+    -- 権限 (student: 学生 / instructor: 教員 / admin: 管理者)。新しい利用者は student になる
+    role VARCHAR(20) NOT NULL DEFAULT 'student' CHECK (role IN ('student', 'instructor', 'admin')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (issuer, subject)
+);
+
+-- 組織 (大学など)。コースと利用者の所属はすべて組織の中で管理する
+CREATE TABLE IF NOT EXISTS organizations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    slug VARCHAR(100) NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 組織の所属者 (admin: 組織の管理者。コースと所属者を管理できる)
+CREATE TABLE IF NOT EXISTS organization_members (
+    organization_id INT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL DEFAULT 'member' CHECK (role IN ('member', 'admin')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (organization_id, user_id)
+);
+
+-- 組織への招待 (組織の管理者が発行し、同じ確認済みメールアドレスの利用者だけが受け入れられる)
+-- 招待トークン自体は保存せず、SHA-256のハッシュで照合する
+CREATE TABLE IF NOT EXISTS organization_invitations (
+    id SERIAL PRIMARY KEY,
+    organization_id INT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'member' CHECK (role IN ('member', 'admin')),
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    invited_by INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    accepted_at TIMESTAMP WITH TIME ZONE,
+    accepted_by INT REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS courses (
+    id SERIAL PRIMARY KEY,
+    organization_id INT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    code VARCHAR(100) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (organization_id, code)
+);
+
+-- コースの受講者と担当者 (instructor: コースの問題と利用状況を参照できる)
+CREATE TABLE IF NOT EXISTS course_members (
+    course_id INT NOT NULL REFERENCES courses(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL DEFAULT 'student' CHECK (role IN ('student', 'instructor')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (course_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_course_members_user_id ON course_members(user_id);
+
+-- コースの担当者 (コースの教員または組織の管理者) かどうか
+CREATE OR REPLACE FUNCTION is_course_staff(p_course_id INT, p_user_id INT)
+RETURNS BOOLEAN AS $$
+  SELECT EXISTS (
+    SELECT 1 FROM course_members
+    WHERE course_id = p_course_id AND user_id = p_user_id AND role = 'instructor'
+  ) OR EXISTS (
+    SELECT 1 FROM courses c JOIN organization_members m ON m.organization_id = c.organization_id
+    WHERE c.id = p_course_id AND m.user_id = p_user_id AND m.role = 'admin'
+  );
+$$ LANGUAGE sql STABLE;
 
 CREATE TABLE IF NOT EXISTS problems (
     id SERIAL PRIMARY KEY,
+    -- ジョブを作成した利用者と、ジョブが属するコース (コースに属さない問題は本人と管理者だけが参照できる)
+    owner_id INT REFERENCES users(id) ON DELETE SET NULL,
+    course_id INT REFERENCES courses(id) ON DELETE SET NULL,
     
     processing_status processing_status DEFAULT 'pending',
     error_message TEXT,
+
+    -- 各ステージの進捗 (失敗したジョブは完了済みのステージから再開する)
+    extraction_status stage_status DEFAULT 'pending',
+    generation_status stage_status DEFAULT 'pending',
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
 
+    -- 貼り付けられたテキスト (アップロードされたファイルは problem_sources に記録する)
     raw_input_text TEXT, 
-    raw_input_file BYTEA, 
+    -- 入力をモデルに渡した方法 (text / pdf_text / pdf_mixed / pdf_blob / blob など。複数の資料では "+" 区切り)
+    input_method VARCHAR(255),
+
+    -- リクエストごとの生成オプション (問題数・難易度・問題形式・出力言語・LaTeX・モデル)
+    generation_options JSONB NOT NULL DEFAULT '{}',
+
+    -- 正規化した入力と生成オプションのハッシュ (同じ入力のジョブの結果を再利用する)
+    input_hash CHAR(64),
+    -- 再利用した結果 (structure: 構造のみ / full: 生成結果まで) と再利用元のジョブ
+    cache_hit VARCHAR(20),
+    cached_from_problem_id INT REFERENCES problems(id) ON DELETE SET NULL,
     
     -- AIによる構造抽出の結果
     exam_title VARCHAR(255),
@@ -21,15 +118,22 @@ CREATE TABLE IF NOT EXISTS problems (
     question_format_is_latex BOOLEAN,
     answer_format_is_latex BOOLEAN,
     major_sections JSONB,
+    -- レビューモードで利用者が構造を承認した日時 (承認されるまで問題生成は行わない)
+    structure_approved_at TIMESTAMP WITH TIME ZONE,
 
     -- !! 修正: 問題と解答のセット全体をこのJSONBカラムに格納する
     generated_questions JSONB,
+    -- 生成された問題と構造の照合結果 (欠けていた小問・補完した小問・構造にない問題)
+    coverage_report JSONB,
 
     -- トークン使用量
     structure_prompt_tokens INT,
     structure_candidates_tokens INT,
     generation_prompt_tokens INT,
-    generation_candidates_tokens INT
+    generation_candidates_tokens INT,
+    -- キャッシュの利用で節約したトークン数 (再利用元のジョブが使ったトークン数)
+    saved_prompt_tokens INT NOT NULL DEFAULT 0,
+    saved_candidates_tokens INT NOT NULL DEFAULT 0
 );
 
 CREATE OR REPLACE FUNCTION trigger_set_timestamp()
@@ -46,5 +150,201 @@ BEFORE UPDATE ON problems
 FOR EACH ROW
 EXECUTE PROCEDURE trigger_set_timestamp();
 
+-- ステータスやステージが変化したらAPI Gatewayへ通知する (SSEでクライアントへ配信)
+CREATE OR REPLACE FUNCTION notify_problem_event()
+RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'UPDATE'
+     AND NEW.processing_status IS NOT DISTINCT FROM OLD.processing_status
+     AND NEW.extraction_status IS NOT DISTINCT FROM OLD.extraction_status
+     AND NEW.generation_status IS NOT DISTINCT FROM OLD.generation_status THEN
+    RETURN NEW;
+  END IF;
+  PERFORM pg_notify('problem_events', json_build_object(
+    'problem_id', NEW.id,
+    'status', NEW.processing_status,
+    'stages', json_build_object(
+      'extract_structure', NEW.extraction_status,
+      'generate_problem', NEW.generation_status
+    ),
+    'updated_at', NEW.updated_at
+  )::text);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS notify_problem_event ON problems;
+CREATE TRIGGER notify_problem_event
+AFTER INSERT OR UPDATE ON problems
+FOR EACH ROW
+EXECUTE PROCEDURE notify_problem_event();
+
 CREATE INDEX IF NOT EXISTS idx_problems_status ON problems(processing_status);
-CREATE INDEX IF NOT EXISTS idx_problems_created_at ON problems(created_at DESC);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_problems_created_at ON problems(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_problems_input_hash ON problems(input_hash, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_problems_owner_id ON problems(owner_id);
+CREATE INDEX IF NOT EXISTS idx_problems_course_id ON problems(course_id, created_at DESC);
+
+-- LLM呼び出しの試行履歴 (リトライ・修復プロンプト・フォールバックを含む)
+CREATE TABLE IF NOT EXISTS llm_attempts (
+    id SERIAL PRIMARY KEY,
+    problem_id INT NOT NULL REFERENCES problems(id) ON DELETE CASCADE,
+    stage VARCHAR(50) NOT NULL,
+    model VARCHAR(255) NOT NULL,
+    attempt INT NOT NULL,
+    outcome VARCHAR(50) NOT NULL,
+    error_message TEXT,
+    prompt_tokens INT,
+    candidates_tokens INT,
+    duration_ms INT,
+    -- 課金される呼び出しかどうか (フェイクのモデルとカセットの再生は false で、トークン予算に数えない)
+    billed BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_llm_attempts_problem_id ON llm_attempts(problem_id);
+
+-- 1問だけの再生成リクエスト (生成済みの問題を個別に作り直す)
+CREATE TABLE IF NOT EXISTS question_regenerations (
+    id SERIAL PRIMARY KEY,
+    problem_id INT NOT NULL REFERENCES problems(id) ON DELETE CASCADE,
+    question_index VARCHAR(50) NOT NULL,
+    status stage_status NOT NULL DEFAULT 'pending',
+    instructions TEXT,
+    error_message TEXT,
+    previous_question JSONB,
+    new_question JSONB,
+    prompt_tokens INT,
+    candidates_tokens INT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_question_regenerations_problem_id ON question_regenerations(problem_id);
+
+-- 大問ごとの問題生成の結果 (並列に生成し、成功した大問は再試行時に再利用する)
+CREATE TABLE IF NOT EXISTS section_generations (
+    id SERIAL PRIMARY KEY,
+    problem_id INT NOT NULL REFERENCES problems(id) ON DELETE CASCADE,
+    position INT NOT NULL,
+    section_index VARCHAR(50),
+    -- 生成に使った大問と試験情報のSHA-256。構成が変わった大問の結果は再利用しない
+    section_hash CHAR(64),
+    status stage_status NOT NULL DEFAULT 'pending',
+    error_message TEXT,
+    generated_questions JSONB,
+    prompt_tokens INT NOT NULL DEFAULT 0,
+    candidates_tokens INT NOT NULL DEFAULT 0,
+    started_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (problem_id, position)
+);
+
+-- ジョブにアップロードされた資料 (複数可。position の順に構造抽出へ渡す)
+CREATE TABLE IF NOT EXISTS problem_sources (
+    id SERIAL PRIMARY KEY,
+    problem_id INT NOT NULL REFERENCES problems(id) ON DELETE CASCADE,
+    position INT NOT NULL,
+    -- 資料の役割 (lecture_notes: 講義資料 / sample_exam: 過去問・サンプル試験)
+    role VARCHAR(50) NOT NULL DEFAULT 'lecture_notes',
+    file_name VARCHAR(255),
+    -- アップロードされたファイルの種類 (ゲートウェイが内容から判定する)
+    mime_type VARCHAR(255) NOT NULL,
+    -- 内容はBLOBストアに置き、SHA-256で参照する
+    content_sha256 CHAR(64) NOT NULL,
+    size_bytes INT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (problem_id, position)
+);
+
+-- スクリプト用のAPIキー (キー自体は保存せず、SHA-256のハッシュで照合する)
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    -- 一覧で見分けるためのキーの先頭部分
+    prefix VARCHAR(20) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
+
+-- SSEの接続に使う一度きりのチケット。EventSourceはヘッダーを付けられないため、
+-- トークンの代わりにURLで渡す (トークン自体はURLに載せない)
+CREATE TABLE IF NOT EXISTS stream_tickets (
+    id SERIAL PRIMARY KEY,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- APIキーで発行したチケットは、キーを失効すると使えなくなる
+    api_key_id INT REFERENCES api_keys(id) ON DELETE CASCADE,
+    method VARCHAR(20) NOT NULL,
+    -- チケットで開けるストリームのパス
+    path VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE
+);
+
+-- トークン予算 (利用者・コース・組織ごとの1日または1か月あたりの上限)
+-- scope_id が 0 の行は、個別の予算がないすべての利用者・コース・組織に適用する既定値
+CREATE TABLE IF NOT EXISTS token_budgets (
+    id SERIAL PRIMARY KEY,
+    scope VARCHAR(20) NOT NULL CHECK (scope IN ('user', 'course', 'organization')),
+    scope_id INT NOT NULL DEFAULT 0,
+    period VARCHAR(20) NOT NULL CHECK (period IN ('daily', 'monthly')),
+    token_limit BIGINT NOT NULL CHECK (token_limit >= 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (scope, scope_id, period)
+);
+
+CREATE INDEX IF NOT EXISTS idx_llm_attempts_created_at ON llm_attempts(created_at);
+
+-- 利用者とコースに適用されるトークン予算と、今の期間 (日または月) に使ったトークン数
+-- 使用量はLLM呼び出しの試行履歴から数える (失敗した試行やリトライの分も含み、課金されない呼び出しは除く)
+CREATE OR REPLACE FUNCTION token_budget_usage(p_user_id INT, p_course_id INT)
+RETURNS TABLE (
+    budget_id INT,
+    scope VARCHAR,
+    scope_id INT,
+    period VARCHAR,
+    token_limit BIGINT,
+    used_tokens BIGINT,
+    resets_at TIMESTAMP WITH TIME ZONE
+) AS $$
+  WITH targets AS (
+    SELECT 'user' AS scope, p_user_id AS id WHERE p_user_id IS NOT NULL
+    UNION ALL
+    SELECT 'course', p_course_id WHERE p_course_id IS NOT NULL
+    UNION ALL
+    SELECT 'organization', organization_id FROM courses WHERE id = p_course_id
+  ), budgets AS (
+    -- 個別の予算があれば既定値より優先する
+    SELECT DISTINCT ON (t.scope, b.period)
+      b.id, t.scope, t.id AS target_id, b.period, b.token_limit,
+      date_trunc(CASE b.period WHEN 'daily' THEN 'day' ELSE 'month' END, NOW()) AS period_start
+    FROM targets t
+    JOIN token_budgets b ON b.scope = t.scope AND b.scope_id IN (t.id, 0)
+    ORDER BY t.scope, b.period, b.scope_id DESC
+  )
+  SELECT
+    b.id, b.scope::VARCHAR, b.target_id, b.period, b.token_limit, u.used,
+    b.period_start + CASE b.period WHEN 'daily' THEN INTERVAL '1 day' ELSE INTERVAL '1 month' END
+  FROM budgets b
+  CROSS JOIN LATERAL (
+    SELECT COALESCE(SUM(COALESCE(a.prompt_tokens, 0) + COALESCE(a.candidates_tokens, 0)), 0)::BIGINT AS used
+    FROM llm_attempts a
+    JOIN problems p ON p.id = a.problem_id
+    LEFT JOIN courses c ON c.id = p.course_id
+    WHERE a.created_at >= b.period_start AND a.billed
+      AND CASE b.scope
+        WHEN 'user' THEN p.owner_id = b.target_id
+        WHEN 'course' THEN p.course_id = b.target_id
+        ELSE c.organization_id = b.target_id
+      END
+  ) u
+  ORDER BY b.scope, b.period;
+$$ LANGUAGE sql STABLE;
@@ -0,0 +1,106 @@
+import { useState } from 'react';
+
+const difficulties = ['easy', 'medium', 'hard'];
+
+// AIが抽出した試験構造 (大問・小問) を編集し、承認するためのフォーム。
+// structure は GET /problems/{id}/structure の応答と同じ形式。
+const StructureEditor = ({ structure, onApprove, onCancel, disabled }) => {
+  const [draft, setDraft] = useState(structure);
+
+  const updateMeta = (key, value) => {
+    setDraft(prev => ({ ...prev, exam_meta: { ...prev.exam_meta, [key]: value } }));
+  };
+
+  const updateSections = (update) => {
+    setDraft(prev => ({
+      ...prev,
+      structure: { ...prev.structure, major_sections: update(prev.structure.major_sections) },
+    }));
+  };
+
+  const updateSection = (si, key, value) => {
+    updateSections(sections => sections.map((s, i) => (i === si ? { ...s, [key]: value } : s)));
+  };
+
+  const updateQuestion = (si, qi, key, value) => {
+    updateSections(sections => sections.map((s, i) => (i !== si ? s : {
+      ...s,
+      sub_questions: s.sub_questions.map((q, j) => (j === qi ? { ...q, [key]: value } : q)),
+    })));
+  };
+
+  const addQuestion = (si) => {
+    updateSections(sections => sections.map((s, i) => (i !== si ? s : {
+      ...s,
+      sub_questions: [...s.sub_questions, { question_index: `${s.section_index || si + 1}-${s.sub_questions.length + 1}`, topic: '', difficulty: 'medium' }],
+    })));
+  };
+
+  const removeQuestion = (si, qi) => {
+    updateSections(sections => sections.map((s, i) => (i !== si ? s : {
+      ...s,
+      sub_questions: s.sub_questions.filter((_, j) => j !== qi),
+    })));
+  };
+
+  return (
+    <div className="structure-editor">
+      <label className="field">
+        試験タイトル
+        <input type="text" value={draft.exam_meta.exam_title || ''} onChange={(e) => updateMeta('exam_title', e.target.value)} disabled={disabled} />
+      </label>
+
+      {draft.structure.major_sections.map((section, si) => (
+        <div key={si} className="section-editor">
+          <label className="field">
+            大問 {section.section_index}
+            <input type="text" value={section.section_title || ''} onChange={(e) => updateSection(si, 'section_title', e.target.value)} disabled={disabled} />
+          </label>
+          <table>
+            <thead>
+              <tr><th>番号</th><th>トピック</th><th>キーワード (カンマ区切り)</th><th>難易度</th><th></th></tr>
+            </thead>
+            <tbody>
+              {section.sub_questions.map((q, qi) => (
+                <tr key={qi}>
+                  <td><input type="text" value={q.question_index || ''} onChange={(e) => updateQuestion(si, qi, 'question_index', e.target.value)} disabled={disabled} /></td>
+                  <td><input type="text" value={q.topic || ''} onChange={(e) => updateQuestion(si, qi, 'topic', e.target.value)} disabled={disabled} /></td>
+                  <td>
+                    <input type="text" value={(q.keywords || []).join(', ')} disabled={disabled}
+                      onChange={(e) => updateQuestion(si, qi, 'keywords', e.target.value.split(',').map(k => k.trim()).filter(Boolean))} />
+                  </td>
+                  <td>
+                    <select value={q.difficulty || ''} onChange={(e) => updateQuestion(si, qi, 'difficulty', e.target.value)} disabled={disabled}>
+                      <option value="">-</option>
+                      {difficulties.map(d => <option key={d} value={d}>{d}</option>)}
+                    </select>
+                  </td>
+                  <td><button type="button" onClick={() => removeQuestion(si, qi)} disabled={disabled}>削除</button></td>
+                </tr>
+              ))}
+            </tbody>
+          </table>
+          <button type="button" onClick={() => addQuestion(si)} disabled={disabled}>小問を追加</button>
+        </div>
+      ))}
+
+      <div className="editor-actions">
+        <button type="button" className="button" onClick={() => onApprove(draft)} disabled={disabled}>この構成で問題を生成</button>
+        <button type="button" className="button cancel-button" onClick={onCancel} disabled={disabled}>キャンセル</button>
+      </div>
+
+      <style jsx>{`
+        .structure-editor { display: flex; flex-direction: column; gap: 1rem; }
+        .field { display: flex; flex-direction: column; gap: 0.25rem; font-weight: bold; }
+        .field input { font-weight: normal; padding: 0.4rem; }
+        .section-editor { border: 1px solid #ddd; border-radius: 4px; padding: 0.75rem; }
+        table { width: 100%; border-collapse: collapse; margin: 0.5rem 0; }
+        th, td { padding: 0.25rem; text-align: left; }
+        td input { width: 100%; }
+        .editor-actions { display: flex; gap: 1rem; }
+      `}</style>
+    </div>
+  );
+};
+
+export default StructureEditor;
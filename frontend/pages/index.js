@@ -1,26 +1,141 @@
 import Head from 'next/head';
 import { useState, useEffect, useRef } from 'react';
 import MarkdownRenderer from '../components/MarkdownRenderer';
+import StructureEditor from '../components/StructureEditor';
+
+// 生成オプションの選択肢 (値はAPIの仕様に合わせる)
+const difficultyOptions = [
+    { value: '', label: 'AIに任せる' },
+    { value: 'easy', label: '易しい' },
+    { value: 'medium', label: '普通' },
+    { value: 'hard', label: '難しい' },
+    { value: 'mixed', label: '混在' },
+];
+const questionTypeOptions = [
+    { value: 'multiple_choice', label: '多肢選択式' },
+    { value: 'true_false', label: '正誤問題' },
+    { value: 'short_answer', label: '短答式' },
+    { value: 'essay', label: '論述式' },
+    { value: 'calculation', label: '計算問題' },
+    { value: 'proof', label: '証明問題' },
+];
+const languageOptions = [
+    { value: '', label: '入力と同じ' },
+    { value: 'ja', label: '日本語' },
+    { value: 'en', label: 'English' },
+];
+
+// 同じ入力の過去のジョブの結果を再利用するか (cache)
+const cacheOptions = [
+    { value: 'none', label: '再利用しない' },
+    { value: 'structure', label: '構成を再利用' },
+    { value: 'full', label: '生成結果を再利用' },
+];
+
+// APIの認証トークン (OIDCプロバイダが発行したトークンまたはAPIキー) はブラウザに保存する
+const TOKEN_STORAGE_KEY = 'edumint_api_token';
+const loadToken = () => (typeof window === 'undefined' ? '' : window.localStorage.getItem(TOKEN_STORAGE_KEY) || '');
+
+// 保存したトークンをAuthorizationヘッダーに付けてAPIを呼び出す
+const apiFetch = (url, options = {}) => {
+    const token = loadToken();
+    const headers = token ? { ...options.headers, Authorization: `Bearer ${token}` } : options.headers;
+    return fetch(url, { ...options, headers });
+};
+
+// EventSourceはヘッダーを付けられないため、1分間・1回だけ使えるチケットを発行してURLで渡す
+// (トークン自体はURLに載せない)
+const openEventStream = async (path) => {
+    const res = await apiFetch('http://localhost:8080/api/v1/stream-tickets', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ path }),
+    });
+    if (!res.ok) {
+        throw new Error(`Failed to open the event stream (status ${res.status})`);
+    }
+    const { ticket } = await res.json();
+    return new EventSource(`http://localhost:8080${path}?ticket=${encodeURIComponent(ticket)}`);
+};
+
+// SSEで通知される処理フェーズの表示名
+const phaseLabels = {
+    pending: '待機中',
+    extracting: '構造を抽出中',
+    awaiting_review: '構成の確認待ち',
+    generating: '問題を生成中',
+    processing: '処理中',
+};
 
 export default function Home() {
     const [inputType, setInputType] = useState('text');
     const [inputText, setInputText] = useState('');
-    const [pdfFile, setPdfFile] = useState(null);
+    const [sourceFiles, setSourceFiles] = useState([]); // { file, role } のアップロード順の一覧
     const fileInputRef = useRef(null);
 
+    const [numQuestions, setNumQuestions] = useState('');
+    const [difficulty, setDifficulty] = useState('');
+    const [questionTypes, setQuestionTypes] = useState([]);
+    const [language, setLanguage] = useState('');
+    const [latex, setLatex] = useState(true);
+    const [review, setReview] = useState(false);
+    const [cacheMode, setCacheMode] = useState('none');
+    const [courses, setCourses] = useState([]); // 所属しているコース
+    const [courseId, setCourseId] = useState(''); // 問題を作成するコース (空は個人の問題)
+    const [reviewStructure, setReviewStructure] = useState(null);
+
     const [jobId, setJobId] = useState(null);
     const [jobStatus, setJobStatus] = useState('');
+    const [jobPhase, setJobPhase] = useState('');
     const [jobResult, setJobResult] = useState(null);
+    const [coverage, setCoverage] = useState(null); // 生成結果と構造の照合結果
+    const [cacheHit, setCacheHit] = useState(''); // 再利用した過去の結果 (structure / full)
+    const [resultId, setResultId] = useState(null);
+    const [regenerating, setRegenerating] = useState({}); // question_index -> regeneration_id
     
     const [showAnswers, setShowAnswers] = useState(false);
     const [isShowingAd, setIsShowingAd] = useState(false);
     const [isLoading, setIsLoading] = useState(false);
     const [error, setError] = useState('');
+    const [apiToken, setApiToken] = useState('');
+
+    // 問題を作成できるコースの一覧を取得する (取得できなければ個人の問題だけを作成する)
+    const fetchCourses = async () => {
+        try {
+            const res = await apiFetch('http://localhost:8080/api/v1/courses');
+            setCourses(res.ok ? await res.json() : []);
+        } catch (err) {
+            setCourses([]);
+        }
+    };
+
+    useEffect(() => {
+        setApiToken(loadToken());
+        fetchCourses();
+    }, []);
+
+    // 入力されたトークンを保存する (空にすると削除)
+    const saveToken = (e) => {
+        e.preventDefault();
+        if (apiToken) {
+            window.localStorage.setItem(TOKEN_STORAGE_KEY, apiToken);
+        } else {
+            window.localStorage.removeItem(TOKEN_STORAGE_KEY);
+        }
+        setCourseId('');
+        fetchCourses();
+    };
 
     const resetState = () => {
         setJobId(null);
         setJobStatus('');
+        setJobPhase('');
         setJobResult(null);
+        setCoverage(null);
+        setCacheHit('');
+        setResultId(null);
+        setRegenerating({});
+        setReviewStructure(null);
         setError('');
         setShowAnswers(false);
         setIsLoading(false);
@@ -31,32 +146,40 @@ export default function Home() {
     // ジョブ完了後/失敗後に`setJobId(null)`を呼び出し、UIの状態をリセットします。
     // ============================================================================
     useEffect(() => {
-        // ポーリングを開始する条件：jobIdがあり、かつステータスが処理中であること
+        // 監視を開始する条件：jobIdがあり、かつステータスが処理中であること
         if (!jobId || (jobStatus !== 'pending' && jobStatus !== 'processing')) {
             return;
         }
 
-        const pollStatus = async () => {
+        // 最終ステータスを受け取ったら結果を取得してUIを待機状態から解放する
+        const applyStatus = (data) => {
+            setJobPhase(data.phase || data.status);
+            if (data.status !== jobStatus) {
+                setJobStatus(data.status);
+            }
+
+            if (data.status === 'completed') {
+                setJobResult(data.generated_output);
+                setCoverage(data.coverage_report || null);
+                setCacheHit(data.cache_hit || '');
+                setResultId(jobId);
+                setJobId(null); // !! 修正: ジョブIDをリセットしてUIを待機状態から解放する
+            } else if (data.status === 'failed') {
+                setError(data.error || 'Job processing failed.');
+                setJobId(null); // !! 修正: エラー時もジョブIDをリセットする
+            } else if (data.status === 'cancelled') {
+                setJobId(null);
+            }
+        };
+
+        const fetchStatus = async () => {
             try {
-                const res = await fetch(`http://localhost:8080/api/v1/problems/${jobId}/status`);
+                const res = await apiFetch(`http://localhost:8080/api/v1/problems/${jobId}/status`);
                 if (!res.ok) {
                     const errData = await res.json().catch(() => ({ message: 'Status check failed.' }));
                     throw new Error(errData.message || 'Status check failed');
                 }
-                const data = await res.json();
-                
-                // ステータスが変化した場合のみ更新
-                if (data.status !== jobStatus) {
-                    setJobStatus(data.status);
-                }
-
-                if (data.status === 'completed') {
-                    setJobResult(data.generated_output);
-                    setJobId(null); // !! 修正: ジョブIDをリセットしてUIを待機状態から解放する
-                } else if (data.status === 'failed') {
-                    setError(data.error || 'Job processing failed.');
-                    setJobId(null); // !! 修正: エラー時もジョブIDをリセットする
-                }
+                applyStatus(await res.json());
             } catch (err) {
                 setError(err.message);
                 setJobStatus('failed');
@@ -64,13 +187,182 @@ export default function Home() {
             }
         };
 
-        const intervalId = setInterval(pollStatus, 3000); // 3秒ごとにステータスをチェック
-        
-        // クリーンアップ関数：コンポーネントがアンマウントされるか、依存関係が変わる際にインターバルを停止
-        return () => clearInterval(intervalId);
+        // Server-Sent Eventsでステータスの変化を受け取る。
+        // 接続できない場合は従来どおり3秒ごとのポーリングに切り替える。
+        let intervalId = null;
+        let closed = false;
+        const startPolling = () => {
+            if (!closed && !intervalId) {
+                intervalId = setInterval(fetchStatus, 3000);
+            }
+        };
+
+        let source = null;
+        if (typeof EventSource !== 'undefined') {
+            openEventStream(`/api/v1/problems/${jobId}/events`)
+                .then((s) => {
+                    if (closed) {
+                        s.close();
+                        return;
+                    }
+                    source = s;
+                    source.addEventListener('status', (e) => {
+                        const data = JSON.parse(e.data);
+                        if (data.status === 'completed' || data.status === 'failed') {
+                            // 生成結果とエラー内容はステータスAPIから取得する
+                            source.close();
+                            fetchStatus();
+                        } else {
+                            applyStatus(data);
+                        }
+                    });
+                    source.onerror = () => {
+                        source.close();
+                        startPolling();
+                    };
+                })
+                .catch(startPolling);
+        } else {
+            startPolling();
+        }
+
+        // クリーンアップ関数：コンポーネントがアンマウントされるか、依存関係が変わる際に接続とインターバルを停止
+        return () => {
+            closed = true;
+            if (source) source.close();
+            if (intervalId) clearInterval(intervalId);
+        };
 
     }, [jobId, jobStatus]); // jobIdかjobStatusが変わるたびにこのeffectは再評価される
 
+    // 選択されたファイルを資料一覧に追加する (役割の初期値は講義資料)
+    const addSourceFiles = (e) => {
+        const added = Array.from(e.target.files).map((file) => ({ file, role: 'lecture_notes' }));
+        setSourceFiles((prev) => [...prev, ...added].slice(0, 10));
+        e.target.value = ''; // 同じファイルを再度選択できるようにする
+    };
+
+    const setSourceRole = (index, role) => {
+        setSourceFiles((prev) => prev.map((s, i) => (i === index ? { ...s, role } : s)));
+    };
+
+    const removeSourceFile = (index) => {
+        setSourceFiles((prev) => prev.filter((_, i) => i !== index));
+    };
+
+    // 入力された生成オプションをAPIのフィールド名に変換する (未指定の項目は送らない)
+    const buildOptions = () => {
+        const options = { latex };
+        if (review) options.review = true;
+        if (numQuestions) options.num_questions = parseInt(numQuestions, 10);
+        if (difficulty) options.difficulty = difficulty;
+        if (questionTypes.length > 0) options.question_types = questionTypes;
+        if (language) options.language = language;
+        if (cacheMode !== 'none') options.cache = cacheMode;
+        if (courseId) options.course_id = parseInt(courseId, 10);
+        return options;
+    };
+
+    const toggleQuestionType = (value) => {
+        setQuestionTypes(prev => prev.includes(value) ? prev.filter(t => t !== value) : [...prev, value]);
+    };
+
+    // レビューモードのジョブが構造抽出を終えたら、編集用に構造を取得する
+    useEffect(() => {
+        if (!jobId || jobStatus !== 'awaiting_review') {
+            return;
+        }
+        const fetchStructure = async () => {
+            try {
+                const res = await apiFetch(`http://localhost:8080/api/v1/problems/${jobId}/structure`);
+                if (!res.ok) {
+                    throw new Error(await res.text() || 'Failed to load the extracted structure.');
+                }
+                setReviewStructure(await res.json());
+            } catch (err) {
+                setError(err.message);
+            }
+        };
+        fetchStructure();
+    }, [jobId, jobStatus]);
+
+    // 編集した構造を保存して承認し、問題生成を再開する
+    const handleApproveStructure = async (structure) => {
+        setIsLoading(true);
+        setError('');
+        try {
+            const putRes = await apiFetch(`http://localhost:8080/api/v1/problems/${jobId}/structure`, {
+                method: 'PUT',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(structure),
+            });
+            if (!putRes.ok) {
+                throw new Error(await putRes.text() || 'Failed to save the structure.');
+            }
+            const approveRes = await apiFetch(`http://localhost:8080/api/v1/problems/${jobId}/structure/approve`, { method: 'POST' });
+            if (approveRes.status !== 202) {
+                throw new Error(await approveRes.text() || 'Failed to approve the structure.');
+            }
+            setReviewStructure(null);
+            setJobStatus('pending'); // ステータスの監視を再開
+        } catch (err) {
+            setError(err.message);
+        } finally {
+            setIsLoading(false);
+        }
+    };
+
+    // 再生成中の問題があれば2秒ごとに進捗を確認し、完了したら結果を取り直す
+    useEffect(() => {
+        if (!resultId || Object.keys(regenerating).length === 0) {
+            return;
+        }
+        const checkRegenerations = async () => {
+            try {
+                const res = await apiFetch(`http://localhost:8080/api/v1/problems/${resultId}/regenerations`);
+                if (!res.ok) {
+                    throw new Error(await res.text() || 'Failed to check regenerations.');
+                }
+                const regenerations = await res.json();
+                const finished = regenerations.filter(r =>
+                    Object.values(regenerating).includes(r.regeneration_id) && (r.status === 'completed' || r.status === 'failed'));
+                if (finished.length === 0) {
+                    return;
+                }
+                finished.filter(r => r.status === 'failed').forEach(r => setError(`問題 ${r.question_index} の再生成に失敗しました: ${r.error_message}`));
+                const statusRes = await apiFetch(`http://localhost:8080/api/v1/problems/${resultId}/status`);
+                if (statusRes.ok) {
+                    setJobResult((await statusRes.json()).generated_output);
+                }
+                setRegenerating(prev => {
+                    const next = { ...prev };
+                    finished.forEach(r => delete next[r.question_index]);
+                    return next;
+                });
+            } catch (err) {
+                setError(err.message);
+                setRegenerating({});
+            }
+        };
+        const intervalId = setInterval(checkRegenerations, 2000);
+        return () => clearInterval(intervalId);
+    }, [resultId, regenerating]);
+
+    // 1問だけ作り直す (他の問題はそのまま残る)
+    const handleRegenerate = async (questionIndex) => {
+        setError('');
+        try {
+            const res = await apiFetch(`http://localhost:8080/api/v1/problems/${resultId}/questions/${encodeURIComponent(questionIndex)}/regenerate`, { method: 'POST' });
+            if (res.status !== 202) {
+                throw new Error(await res.text() || 'Failed to regenerate the question.');
+            }
+            const data = await res.json();
+            setRegenerating(prev => ({ ...prev, [questionIndex]: data.regeneration_id }));
+        } catch (err) {
+            setError(err.message);
+        }
+    };
+
     const handleGenerateProblem = async (e) => {
         e.preventDefault();
         resetState();
@@ -79,17 +371,24 @@ export default function Home() {
         try {
             let body;
             let headers = {};
-            if (inputType === 'pdf' && pdfFile) {
+            const options = buildOptions();
+            if (inputType === 'pdf' && sourceFiles.length > 0) {
                 body = new FormData();
-                body.append('pdfFile', pdfFile);
+                sourceFiles.forEach(({ file, role }) => {
+                    body.append('file', file);
+                    body.append('roles', role);
+                });
+                Object.entries(options).forEach(([key, value]) => {
+                    body.append(key, Array.isArray(value) ? value.join(',') : String(value));
+                });
             } else if (inputType === 'text' && inputText) {
-                body = inputText;
+                body = JSON.stringify({ text: inputText, ...options });
                 headers['Content-Type'] = 'application/json';
             } else {
-                throw new Error("Input is empty. Please provide text or a PDF file.");
+                throw new Error("Input is empty. Please provide text or a file.");
             }
 
-            const res = await fetch('http://localhost:8080/api/v1/generate', { method: 'POST', headers, body });
+            const res = await apiFetch('http://localhost:8080/api/v1/generate', { method: 'POST', headers, body });
             if (res.status !== 202) {
                 const errText = await res.text();
                 throw new Error(errText || 'Failed to submit job to the server.');
@@ -97,7 +396,7 @@ export default function Home() {
 
             const data = await res.json();
             setJobId(data.problem_id);
-            setJobStatus('pending'); // ポーリングを開始
+            setJobStatus('pending'); // ステータスの監視を開始
         } catch (err) {
             setError(err.message);
         } finally {
@@ -105,6 +404,20 @@ export default function Home() {
         }
     };
 
+    // 処理中のジョブをキャンセルする (誤ったファイルをアップロードした場合など)
+    const handleCancel = async () => {
+        try {
+            const res = await apiFetch(`http://localhost:8080/api/v1/problems/${jobId}/cancel`, { method: 'POST' });
+            if (!res.ok && res.status !== 409) {
+                throw new Error(await res.text() || 'Failed to cancel the job.');
+            }
+            setJobStatus('cancelled');
+            setJobId(null);
+        } catch (err) {
+            setError(err.message);
+        }
+    };
+
     const handleShowAnswers = async () => {
         setIsShowingAd(true);
         await new Promise(resolve => setTimeout(resolve, 3000));
@@ -122,6 +435,10 @@ export default function Home() {
 
             <header className="header">
                 <h1>EduMint 問題生成プラットフォーム</h1>
+                <form className="token-form" onSubmit={saveToken}>
+                    <input type="password" value={apiToken} onChange={(e) => setApiToken(e.target.value)} placeholder="APIキーまたはIDトークン" />
+                    <button type="submit">保存</button>
+                </form>
             </header>
 
             <main className="main-content">
@@ -129,7 +446,7 @@ export default function Home() {
                     <h2>1. 問題の元となる情報を入力</h2>
                     <div className="input-type-selector">
                         <button onClick={() => setInputType('text')} className={inputType === 'text' ? 'active' : ''}>テキスト入力</button>
-                        <button onClick={() => setInputType('pdf')} className={inputType === 'pdf' ? 'active' : ''}>PDFアップロード</button>
+                        <button onClick={() => setInputType('pdf')} className={inputType === 'pdf' ? 'active' : ''}>ファイルアップロード</button>
                     </div>
 
                     <form onSubmit={handleGenerateProblem} className="form">
@@ -148,23 +465,99 @@ export default function Home() {
                                 <button type="button" onClick={() => fileInputRef.current.click()} className="button file-button" disabled={isLoading || !!jobId}>
                                   ファイルを選択
                                 </button>
-                                <input id="pdf-file-input" name="pdf-file"
-                                    type="file" ref={fileInputRef} onChange={(e) => setPdfFile(e.target.files[0])} accept=".pdf" style={{ display: 'none' }} />
-                                {pdfFile && <span className="file-name">{pdfFile.name}</span>}
+                                <input id="pdf-file-input" name="pdf-file" multiple
+                                    type="file" ref={fileInputRef} onChange={addSourceFiles} accept=".pdf,.docx,.pptx,.md,.markdown,.tex,.txt" style={{ display: 'none' }} />
+                                {sourceFiles.length > 0 && (
+                                    <ol className="source-list">
+                                        {sourceFiles.map(({ file, role }, i) => (
+                                            <li key={`${file.name}-${i}`}>
+                                                <span className="file-name">{file.name}</span>
+                                                <select value={role} onChange={(e) => setSourceRole(i, e.target.value)} disabled={isLoading || !!jobId}>
+                                                    <option value="lecture_notes">講義資料</option>
+                                                    <option value="sample_exam">過去問・サンプル試験</option>
+                                                </select>
+                                                <button type="button" onClick={() => removeSourceFile(i)} disabled={isLoading || !!jobId}>削除</button>
+                                            </li>
+                                        ))}
+                                    </ol>
+                                )}
                             </div>
                         )}
+                        <fieldset className="options" disabled={isLoading || !!jobId}>
+                            <legend>生成オプション</legend>
+                            <label>
+                                問題数
+                                <input type="number" min="1" max="50" value={numQuestions}
+                                    onChange={(e) => setNumQuestions(e.target.value)} placeholder="AIに任せる" />
+                            </label>
+                            <label>
+                                難易度
+                                <select value={difficulty} onChange={(e) => setDifficulty(e.target.value)}>
+                                    {difficultyOptions.map(o => <option key={o.value} value={o.value}>{o.label}</option>)}
+                                </select>
+                            </label>
+                            <label>
+                                出力言語
+                                <select value={language} onChange={(e) => setLanguage(e.target.value)}>
+                                    {languageOptions.map(o => <option key={o.value} value={o.value}>{o.label}</option>)}
+                                </select>
+                            </label>
+                            {courses.length > 0 && (
+                                <label>
+                                    コース
+                                    <select value={courseId} onChange={(e) => setCourseId(e.target.value)}>
+                                        <option value="">個人 (コースなし)</option>
+                                        {courses.map(c => <option key={c.id} value={c.id}>{c.code} {c.name}</option>)}
+                                    </select>
+                                </label>
+                            )}
+                            <label>
+                                過去の結果
+                                <select value={cacheMode} onChange={(e) => setCacheMode(e.target.value)}>
+                                    {cacheOptions.map(o => <option key={o.value} value={o.value}>{o.label}</option>)}
+                                </select>
+                            </label>
+                            <label>
+                                <input type="checkbox" checked={latex} onChange={(e) => setLatex(e.target.checked)} />
+                                数式をLaTeXで記述
+                            </label>
+                            <label>
+                                <input type="checkbox" checked={review} onChange={(e) => setReview(e.target.checked)} />
+                                生成前に構成を確認・編集する
+                            </label>
+                            <div className="question-types">
+                                問題形式:
+                                {questionTypeOptions.map(o => (
+                                    <label key={o.value}>
+                                        <input type="checkbox" checked={questionTypes.includes(o.value)} onChange={() => toggleQuestionType(o.value)} />
+                                        {o.label}
+                                    </label>
+                                ))}
+                            </div>
+                        </fieldset>
                         <button type="submit" disabled={isLoading || !!jobId} className="button generate-button">
                             {isLoading ? '投入中...' : (jobId ? '処理中' : '問題を生成')}
                         </button>
                     </form>
                 </section>
                 
-                { (isLoading || (jobId && jobStatus !== 'completed' && jobStatus !== 'failed')) &&
+                { (isLoading || (jobId && jobStatus !== 'completed' && jobStatus !== 'failed' && jobStatus !== 'awaiting_review')) &&
                     <div className="status-box">
-                        <p>ステータス: {isLoading ? 'ジョブをサーバーに送信中...' : `処理中 (${jobStatus})`}</p>
+                        <p>ステータス: {isLoading ? 'ジョブをサーバーに送信中...' : `処理中 (${phaseLabels[jobPhase] || jobPhase || jobStatus})`}</p>
                         <p>処理が完了すると、結果が自動的に表示されます。このページを離れても処理は続行されます。</p>
+                        {jobId && <button type="button" onClick={handleCancel} className="button cancel-button">キャンセル</button>}
                     </div>
                 }
+
+                { jobId && jobStatus === 'awaiting_review' && reviewStructure && (
+                    <section className="section">
+                        <h2>2. 抽出された構成を確認</h2>
+                        <p>AIが読み取った大問・小問の構成です。必要に応じて修正し、承認すると問題生成が始まります。</p>
+                        <StructureEditor structure={reviewStructure} onApprove={handleApproveStructure} onCancel={handleCancel} disabled={isLoading} />
+                    </section>
+                )}
+
+                { jobStatus === 'cancelled' && <p className="status-box">ジョブはキャンセルされました。</p> }
                 
                 { error && <p className="error-message">{error}</p> }
 
@@ -174,10 +567,29 @@ export default function Home() {
                         
                         <h3>{jobResult.exam_meta?.exam_title || '生成された問題'}</h3>
 
+                        {cacheHit && (
+                            <p className="status-box">
+                                {cacheHit === 'full' ? '同じ入力の過去の生成結果を再利用しました。' : '同じ入力の過去の構成を再利用して問題を生成しました。'}
+                            </p>
+                        )}
+
+                        {coverage && (coverage.filled?.length > 0 || coverage.extras?.length > 0 || !coverage.complete) && (
+                            <p className="status-box">
+                                {coverage.filled?.length > 0 && `生成されなかった小問 ${coverage.filled.map(c => c.question_index || c.topic).join(', ')} を補完しました。`}
+                                {coverage.extras?.length > 0 && ` 構成にない問題 ${coverage.extras.length} 問を除外しました。`}
+                                {coverage.unresolved?.length > 0 && ` 小問 ${coverage.unresolved.map(c => c.question_index || c.topic).join(', ')} は生成できませんでした。`}
+                            </p>
+                        )}
+
                         {jobResult.questions && jobResult.questions.length > 0 ? (
                           jobResult.questions.map((q, index) => (
                             <div key={index} className="result-box">
                                 <h4>問題 {q.question_index} (トピック: {q.topic || 'N/A'})</h4>
+                                {resultId && q.question_index && (
+                                    <button type="button" className="regenerate-button" onClick={() => handleRegenerate(q.question_index)} disabled={!!regenerating[q.question_index]}>
+                                        {regenerating[q.question_index] ? '再生成中...' : 'この問題を作り直す'}
+                                    </button>
+                                )}
                                 <div className="markdown-content">
                                     <MarkdownRenderer markdownContent={q.question_text} />
                                 </div>
@@ -222,6 +634,7 @@ export default function Home() {
                 .button { padding: 0.75rem 1.5rem; border-radius: 6px; border: none; font-size: 1rem; cursor: pointer; transition: background-color 0.2s; }
                 .button:disabled { background-color: #ccc; cursor: not-allowed; }
                 .generate-button { background-color: #0070f3; color: white; }
+                .cancel-button { background-color: #e74c3c; color: white; }
                 .textarea { width: 100%; min-height: 150px; padding: 0.5rem; font-size: 1rem; border: 1px solid #ccc; border-radius: 4px; }
                 .error-message { color: #e74c3c; background: #fbeae5; padding: 1rem; border-radius: 4px; margin: 1rem 0; white-space: pre-wrap; }
                 .status-box { background: #eaf5ff; border: 1px solid #99caff; padding: 1rem; border-radius: 4px; margin: 1rem 0; }
@@ -231,11 +644,18 @@ export default function Home() {
                 .answer-button { background-color: #f5a623; color: white; }
                 .ad-placeholder { text-align: center; padding: 2rem; border: 2px dashed #ccc; margin: 1rem 0; }
                 .input-type-selector { display: flex; margin-bottom: 1rem; }
+                .regenerate-button { margin-bottom: 0.5rem; padding: 0.25rem 0.75rem; border: 1px solid #0070f3; background: #fff; color: #0070f3; border-radius: 4px; cursor: pointer; }
+                .options { display: flex; flex-wrap: wrap; gap: 0.75rem 1.5rem; margin: 1rem 0; padding: 0.75rem 1rem; border: 1px solid #ddd; border-radius: 4px; }
+                .options input[type="number"] { width: 6rem; margin-left: 0.5rem; }
+                .options select { margin-left: 0.5rem; }
+                .question-types { display: flex; flex-wrap: wrap; gap: 0.5rem 1rem; width: 100%; }
                 .input-type-selector button { flex: 1; padding: 0.5rem; border: 1px solid #ccc; background: #f0f0f0; cursor: pointer; }
                 .input-type-selector button.active { background: #0070f3; color: white; border-color: #0070f3; }
                 .file-input-area { padding: 1rem; border: 2px dashed #ccc; border-radius: 4px; }
+                .source-list { margin: 0.75rem 0 0; padding-left: 1.5rem; }
+                .source-list li { display: flex; align-items: center; gap: 0.5rem; margin-bottom: 0.25rem; }
                 .answer-section { margin-top: 1rem; padding-top: 1rem; border-top: 1px solid #eee; }
             `}</style>
         </div>
     );
-}
\ No newline at end of file
+}
\ No newline at end of file
@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/streadway/amqp"
+	"github.com/your-username/edumint/problem-generator-worker/internal/blobstore"
 	"github.com/your-username/edumint/problem-generator-worker/internal/processor"
 	"github.com/your-username/edumint/problem-generator-worker/internal/queue"
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/cassette"
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/fake"
 	"github.com/your-username/edumint/problem-generator-worker/internal/services/gemini"
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/llm"
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/openai"
 	"github.com/your-username/edumint/problem-generator-worker/internal/storage"
 )
 
@@ -21,16 +31,26 @@ func main() {
 	defer db.Close()
 
 	// Setup Services
-	storageService := &storage.Service{DB: db}
-	geminiService, err := gemini.NewService()
+	blobs, err := blobstore.FromEnv(context.Background())
 	if err != nil {
-		log.Fatalf("Failed to initialize Gemini service: %v", err)
+		log.Fatalf("Failed to initialize blob store: %v", err)
+	}
+	storageService := &storage.Service{DB: db, Blobs: blobs}
+	llmProvider, err := newProvider()
+	if err != nil {
+		log.Fatalf("Failed to initialize LLM provider: %v", err)
 	}
 
 	// Setup Processor
 	jobProcessor := &processor.Processor{
 		StorageService: storageService,
-		GeminiService:  geminiService,
+		Provider:       llmProvider,
+	}
+	if v, err := time.ParseDuration(os.Getenv("CANCEL_POLL_INTERVAL")); err == nil && v > 0 {
+		jobProcessor.CancelPollInterval = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("GENERATION_CONCURRENCY")); err == nil && v > 0 {
+		jobProcessor.GenerationConcurrency = v
 	}
 
 	// Setup RabbitMQ Consumer
@@ -45,18 +65,100 @@ func main() {
 	forever := make(chan bool)
 	log.Printf("Worker started. Waiting for jobs on queue '%s'. To exit press CTRL+C", GENERATION_QUEUE)
 
+	retryPolicy := queue.RetryPolicyFromEnv()
+
 	// Start a goroutine to process messages from the queue.
 	go func() {
 		for d := range msgs {
 			log.Printf("Received a job with correlation ID: %s. Processing...", d.CorrelationId)
-			jobProcessor.ProcessJob(d.Body)
-			d.Ack(false) // Acknowledge the message after processing
+			err := jobProcessor.ProcessJob(context.Background(), d.Body)
+			settle(queueClient, jobProcessor, retryPolicy, d, err)
 		}
 	}()
 
 	<-forever // Block forever.
 }
 
+// settle acknowledges a processed delivery. Cancelled jobs are simply dropped.
+// Transient failures are scheduled for a delayed retry; permanent failures and
+// jobs that ran out of retries are routed to the dead-letter exchange.
+func settle(queueClient *queue.Client, jobProcessor *processor.Processor, policy queue.RetryPolicy, d amqp.Delivery, err error) {
+	if err == nil || errors.Is(err, processor.ErrCancelled) {
+		d.Ack(false)
+		return
+	}
+
+	attempt := queue.RetryCount(d) + 1
+	if processor.IsTransient(err) && attempt <= policy.MaxRetries {
+		delay := policy.Delay(attempt)
+		if rerr := queueClient.Retry(GENERATION_QUEUE, d, attempt, delay, err); rerr != nil {
+			log.Printf("Failed to schedule retry, requeueing immediately: %v", rerr)
+			d.Nack(false, true)
+			return
+		}
+		log.Printf("Scheduled retry %d/%d in %s", attempt, policy.MaxRetries, delay)
+		d.Ack(false)
+		return
+	}
+
+	jobProcessor.MarkFailed(err)
+	if derr := queueClient.DeadLetter(GENERATION_QUEUE, d, err); derr != nil {
+		log.Printf("Failed to dead-letter job, dropping it: %v", derr)
+		d.Nack(false, false)
+		return
+	}
+	log.Printf("Job moved to dead-letter queue '%s'", queue.DeadLetterQueue(GENERATION_QUEUE))
+	d.Ack(false)
+}
+
+// newProvider builds the LLM provider. LLM_CASSETTE_MODE=record saves every
+// model call under LLM_CASSETTE_DIR, and LLM_CASSETTE_MODE=replay serves calls
+// from there instead of contacting the backend.
+func newProvider() (llm.Provider, error) {
+	mode := os.Getenv("LLM_CASSETTE_MODE")
+	dir := os.Getenv("LLM_CASSETTE_DIR")
+	if dir == "" {
+		dir = "cassettes"
+	}
+
+	var svc *llm.Service
+	var err error
+	switch mode {
+	case "":
+		svc, err = newBackend()
+	case cassette.ModeReplay:
+		svc, err = cassette.NewReplayService(dir)
+	case cassette.ModeRecord:
+		svc, err = newBackend()
+		if err == nil {
+			svc, err = cassette.Record(svc, dir)
+		}
+	default:
+		return nil, fmt.Errorf("unknown LLM_CASSETTE_MODE '%s'", mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	svc.Retry = llm.RetryPolicyFromEnv()
+	svc.Chunking = llm.ChunkPolicyFromEnv()
+	return svc, nil
+}
+
+// newBackend selects the LLM backend from the LLM_PROVIDER environment variable.
+// Gemini is used when it is not set.
+func newBackend() (*llm.Service, error) {
+	switch name := os.Getenv("LLM_PROVIDER"); name {
+	case "", "gemini":
+		return gemini.NewService()
+	case "openai", "ollama", "llamacpp":
+		return openai.NewService(name)
+	case "fake":
+		return fake.NewService()
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER '%s'", name)
+	}
+}
+
 func connectToDB() *sql.DB {
 	var db *sql.DB
 	var err error
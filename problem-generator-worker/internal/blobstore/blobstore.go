@@ -0,0 +1,77 @@
+// Package blobstore reads the uploaded files that the API gateway keeps
+// outside the database. Files are immutable and addressed by the hex SHA-256
+// of their content, so rows only need to keep the 64-character key. The
+// gateway owns the store: it writes the blobs and creates the directory or
+// bucket, and the worker only ever reads them.
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// ErrNotFound is returned by Get for keys that are not in the store.
+var ErrNotFound = errors.New("blob not found")
+
+// Store loads content-addressed blobs.
+type Store interface {
+	// Get returns the blob stored under key, or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+var keyRegex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Key returns the key of data: its SHA-256 in lower-case hex.
+func Key(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidKey reports whether key is a well-formed blob key.
+func ValidKey(key string) bool {
+	return keyRegex.MatchString(key)
+}
+
+// FromEnv opens the store selected by BLOB_STORE: "fs" (the default) keeps
+// blobs under BLOB_DIR, "s3" keeps them in the S3_BUCKET of an S3-compatible
+// service such as MinIO at S3_ENDPOINT (credentials from S3_ACCESS_KEY_ID and
+// S3_SECRET_ACCESS_KEY, TLS unless S3_USE_SSL=false, region from S3_REGION).
+func FromEnv(ctx context.Context) (Store, error) {
+	switch backend := os.Getenv("BLOB_STORE"); backend {
+	case "", "fs":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "blobs"
+		}
+		return NewFS(dir)
+	case "s3":
+		useSSL := true
+		if v, err := strconv.ParseBool(os.Getenv("S3_USE_SSL")); err == nil {
+			useSSL = v
+		}
+		return NewS3(ctx, S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			Region:          os.Getenv("S3_REGION"),
+			UseSSL:          useSSL,
+		})
+	default:
+		return nil, fmt.Errorf("unknown BLOB_STORE '%s' (expected fs or s3)", backend)
+	}
+}
+
+// verify checks that data read from a backend still matches its key.
+func verify(key string, data []byte) error {
+	if got := Key(data); got != key {
+		return fmt.Errorf("blob %s is corrupted (content hashes to %s)", key, got)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FS stores blobs as files under Dir, sharded by the first two characters of
+// their key (Dir/ab/abcdef...).
+type FS struct {
+	Dir string
+}
+
+// NewFS returns a store under dir, which the API gateway creates.
+func NewFS(dir string) (*FS, error) {
+	if info, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("failed to open blob directory: %w", err)
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("blob directory '%s' is not a directory", dir)
+	}
+	return &FS{Dir: dir}, nil
+}
+
+func (s *FS) path(key string) string {
+	return filepath.Join(s.Dir, key[:2], key)
+}
+
+func (s *FS) Get(ctx context.Context, key string) ([]byte, error) {
+	if !ValidKey(key) {
+		return nil, fmt.Errorf("%w: invalid key '%s'", ErrNotFound, key)
+	}
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := verify(key, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
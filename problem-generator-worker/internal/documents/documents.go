@@ -0,0 +1,116 @@
+// Package documents turns uploaded input files into model input. Documents
+// with a usable text layer are sent as text, which costs far fewer tokens than
+// sending the file itself to a multimodal model.
+package documents
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/llm"
+)
+
+// Input methods recorded per job in problems.input_method.
+const (
+	// MethodText is plain text submitted by the user.
+	MethodText = "text"
+	// MethodPDFText is a PDF whose pages all had a text layer.
+	MethodPDFText = "pdf_text"
+	// MethodPDFMixed is a PDF sent as text, with each scanned page as a
+	// one-page PDF after its page marker.
+	MethodPDFMixed = "pdf_mixed"
+	// MethodPDFBlob is a PDF without any text layer, sent as one-page PDFs
+	// after their page markers, or as is when it could not be split.
+	MethodPDFBlob = "pdf_blob"
+	// MethodDOCX is a Word document converted to text.
+	MethodDOCX = "docx_text"
+	// MethodPPTX is a PowerPoint presentation converted to text, slide by slide.
+	MethodPPTX = "pptx_text"
+	// MethodMarkdown is an uploaded Markdown file.
+	MethodMarkdown = "markdown"
+	// MethodTeX is an uploaded LaTeX source file.
+	MethodTeX = "tex"
+	// MethodTextFile is an uploaded plain text file.
+	MethodTextFile = "text_file"
+	// MethodBlob is any other binary input sent as is.
+	MethodBlob = "blob"
+)
+
+// Upload MIME types stored in problem_sources.mime_type by the API gateway.
+const (
+	MIMEPDF      = "application/pdf"
+	MIMEDOCX     = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	MIMEPPTX     = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	MIMEMarkdown = "text/markdown"
+	MIMETeX      = "application/x-tex"
+	MIMEText     = "text/plain"
+)
+
+// ErrUnreadable is returned by Prepare for uploads that cannot be converted
+// and cannot be sent to the model as they are.
+var ErrUnreadable = errors.New("input file could not be read")
+
+// Prepare converts the stored input parts into the parts sent to the model
+// and reports which method was used; the methods of several files are joined
+// with "+" in order of first use. A PDF whose text cannot be extracted is
+// logged and sent as is; DOCX and PPTX files cannot be read by the model, so
+// a failed conversion is returned as ErrUnreadable.
+func Prepare(parts []llm.Part) ([]llm.Part, string, error) {
+	var methods []string
+	var out []llm.Part
+	for _, p := range parts {
+		blob, ok := p.(llm.Blob)
+		if !ok {
+			out = append(out, p)
+			continue
+		}
+		converted, method, err := prepareBlob(blob)
+		if err != nil {
+			return nil, method, err
+		}
+		out = append(out, converted...)
+		if !slices.Contains(methods, method) {
+			methods = append(methods, method)
+		}
+	}
+	if len(methods) == 0 {
+		return out, MethodText, nil
+	}
+	return out, strings.Join(methods, "+"), nil
+}
+
+// prepareBlob converts one uploaded file according to its MIME type.
+func prepareBlob(blob llm.Blob) ([]llm.Part, string, error) {
+	switch blob.MIMEType {
+	case MIMEPDF:
+		converted, method, err := pdfParts(blob.Data)
+		if err != nil {
+			log.Printf("Could not extract text from PDF, sending the file instead: %v", err)
+			return []llm.Part{blob}, MethodPDFBlob, nil
+		}
+		return converted, method, nil
+	case MIMEDOCX, MIMEPPTX:
+		convert, method := docxText, MethodDOCX
+		if blob.MIMEType == MIMEPPTX {
+			convert, method = pptxText, MethodPPTX
+		}
+		text, err := convert(blob.Data)
+		if err != nil {
+			return nil, method, fmt.Errorf("%w: %v", ErrUnreadable, err)
+		}
+		if strings.TrimSpace(text) == "" {
+			return nil, method, fmt.Errorf("%w: no text found", ErrUnreadable)
+		}
+		return []llm.Part{llm.Text(text)}, method, nil
+	case MIMEMarkdown:
+		return []llm.Part{llm.Text(strings.ToValidUTF8(string(blob.Data), ""))}, MethodMarkdown, nil
+	case MIMETeX:
+		return []llm.Part{llm.Text(texText(strings.ToValidUTF8(string(blob.Data), "")))}, MethodTeX, nil
+	case MIMEText:
+		return []llm.Part{llm.Text(strings.ToValidUTF8(string(blob.Data), ""))}, MethodTextFile, nil
+	}
+	return []llm.Part{blob}, MethodBlob, nil
+}
@@ -0,0 +1,100 @@
+package documents
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// openZipEntry parses the XML entry called name inside a ZIP-based Office file.
+func openZipEntry(zr *zip.Reader, name string) (*node, error) {
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return parseXML(rc)
+	}
+	return nil, fmt.Errorf("%s not found", name)
+}
+
+// docxText converts a Word document to Markdown-like text. Heading styles
+// become "#" headings so chunking can split at sections, tables become rows of
+// "|"-separated cells and equations are rendered as LaTeX.
+func docxText(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open DOCX: %w", err)
+	}
+	doc, err := openZipEntry(zr, "word/document.xml")
+	if err != nil {
+		return "", fmt.Errorf("failed to read DOCX: %w", err)
+	}
+	body := doc.find("body")
+	if body == nil {
+		return "", fmt.Errorf("DOCX has no body")
+	}
+	var blocks []string
+	for _, c := range body.children {
+		switch c.name {
+		case "p":
+			if text := docxParagraph(c); strings.TrimSpace(text) != "" {
+				blocks = append(blocks, text)
+			}
+		case "tbl":
+			if text := tableText(c); text != "" {
+				blocks = append(blocks, text)
+			}
+		case "sdt":
+			if content := c.child("sdtContent"); content != nil {
+				for _, p := range content.children {
+					if p.name == "p" {
+						if text := docxParagraph(p); strings.TrimSpace(text) != "" {
+							blocks = append(blocks, text)
+						}
+					}
+				}
+			}
+		}
+	}
+	return strings.Join(blocks, "\n\n"), nil
+}
+
+// docxParagraph renders a paragraph, prefixing headings with "#" marks.
+func docxParagraph(p *node) string {
+	text := strings.TrimSpace(inlineText(p))
+	style := p.child("pPr").child("pStyle").attr("val")
+	switch {
+	case style == "Title":
+		return "# " + text
+	case strings.HasPrefix(style, "Heading"):
+		level := strings.TrimPrefix(style, "Heading")
+		if len(level) == 1 && level[0] >= '1' && level[0] <= '6' {
+			return strings.Repeat("#", int(level[0]-'0')) + " " + text
+		}
+	}
+	return text
+}
+
+// tableText renders a Word or DrawingML table as one line per row.
+func tableText(tbl *node) string {
+	var rows []string
+	for _, tr := range tbl.children {
+		if tr.name != "tr" {
+			continue
+		}
+		var cells []string
+		for _, tc := range tr.children {
+			if tc.name == "tc" {
+				cells = append(cells, strings.Join(strings.Fields(inlineText(tc)), " "))
+			}
+		}
+		rows = append(rows, "| "+strings.Join(cells, " | ")+" |")
+	}
+	return strings.Join(rows, "\n")
+}
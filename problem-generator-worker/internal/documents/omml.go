@@ -0,0 +1,114 @@
+package documents
+
+import "strings"
+
+// naryOperators maps the operator characters of Office math n-ary elements to LaTeX.
+var naryOperators = map[string]string{
+	"∑": `\sum`,
+	"∏": `\prod`,
+	"∫": `\int`,
+	"∬": `\iint`,
+	"∮": `\oint`,
+	"⋃": `\bigcup`,
+	"⋂": `\bigcap`,
+}
+
+// omml renders an Office Math (OMML) element as LaTeX. Unknown elements are
+// rendered as the concatenation of their children, which keeps their text.
+func omml(n *node) string {
+	if n == nil {
+		return ""
+	}
+	switch n.name {
+	case "t":
+		return n.text
+	case "f":
+		return `\frac{` + omml(n.child("num")) + "}{" + omml(n.child("den")) + "}"
+	case "sSup":
+		return "{" + omml(n.child("e")) + "}^{" + omml(n.child("sup")) + "}"
+	case "sSub":
+		return "{" + omml(n.child("e")) + "}_{" + omml(n.child("sub")) + "}"
+	case "sSubSup":
+		return "{" + omml(n.child("e")) + "}_{" + omml(n.child("sub")) + "}^{" + omml(n.child("sup")) + "}"
+	case "rad":
+		if deg := omml(n.child("deg")); deg != "" {
+			return `\sqrt[` + deg + "]{" + omml(n.child("e")) + "}"
+		}
+		return `\sqrt{` + omml(n.child("e")) + "}"
+	case "d":
+		props := n.child("dPr")
+		beg, end, sep := "(", ")", ","
+		if c := props.child("begChr"); c != nil {
+			beg = c.attr("val")
+		}
+		if c := props.child("endChr"); c != nil {
+			end = c.attr("val")
+		}
+		if c := props.child("sepChr"); c != nil {
+			sep = c.attr("val")
+		}
+		var parts []string
+		for _, c := range n.children {
+			if c.name == "e" {
+				parts = append(parts, omml(c))
+			}
+		}
+		return beg + strings.Join(parts, sep) + end
+	case "nary":
+		op := "∫"
+		if c := n.child("naryPr").child("chr"); c != nil {
+			op = c.attr("val")
+		}
+		if latex, ok := naryOperators[op]; ok {
+			op = latex
+		}
+		out := op
+		if sub := omml(n.child("sub")); sub != "" {
+			out += "_{" + sub + "}"
+		}
+		if sup := omml(n.child("sup")); sup != "" {
+			out += "^{" + sup + "}"
+		}
+		return out + " " + omml(n.child("e"))
+	case "func":
+		return omml(n.child("fName")) + " " + omml(n.child("e"))
+	case "bar":
+		return `\overline{` + omml(n.child("e")) + "}"
+	case "acc":
+		accent := `\hat`
+		if c := n.child("accPr").child("chr"); c != nil {
+			switch c.attr("val") {
+			case "→", "⃗":
+				accent = `\vec`
+			case "˙", "̇":
+				accent = `\dot`
+			case "~", "̃":
+				accent = `\tilde`
+			}
+		}
+		return accent + "{" + omml(n.child("e")) + "}"
+	case "m":
+		var rows []string
+		for _, row := range n.children {
+			if row.name != "mr" {
+				continue
+			}
+			var cells []string
+			for _, cell := range row.children {
+				if cell.name == "e" {
+					cells = append(cells, omml(cell))
+				}
+			}
+			rows = append(rows, strings.Join(cells, " & "))
+		}
+		return `\begin{matrix}` + strings.Join(rows, ` \\ `) + `\end{matrix}`
+	}
+	if strings.HasSuffix(n.name, "Pr") {
+		return ""
+	}
+	var sb strings.Builder
+	for _, c := range n.children {
+		sb.WriteString(omml(c))
+	}
+	return sb.String()
+}
@@ -0,0 +1,140 @@
+package documents
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/ledongthuc/pdf"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/llm"
+)
+
+// minPageChars is the number of non-space characters below which a page is
+// treated as scanned, i.e. as having no usable text layer.
+const minPageChars = 20
+
+func init() {
+	// Keep pdfcpu from creating a configuration directory in the user's home.
+	model.ConfigPath = "disable"
+}
+
+// pdfParts extracts the text of every page, marking pages with llm.PageMarker.
+// Each scanned page is sent as a one-page PDF right after its marker, so that
+// long inputs can be split into chunks between pages.
+func pdfParts(data []byte) ([]llm.Part, string, error) {
+	pages, err := pageTexts(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	scanned := 0
+	for _, t := range pages {
+		if countChars(t) < minPageChars {
+			scanned++
+		}
+	}
+	var single map[int][]byte
+	if scanned > 0 {
+		if single, err = splitPages(data); err != nil {
+			return nil, "", fmt.Errorf("could not split the scanned pages: %w", err)
+		}
+	}
+
+	var parts []llm.Part
+	var text strings.Builder
+	for i, t := range pages {
+		n := i + 1
+		text.WriteString(llm.PageMarker(n))
+		text.WriteString("\n")
+		if countChars(t) >= minPageChars {
+			text.WriteString(strings.TrimSpace(t))
+			text.WriteString("\n\n")
+			continue
+		}
+		page, ok := single[n]
+		if !ok {
+			return nil, "", fmt.Errorf("could not extract scanned page %d", n)
+		}
+		text.WriteString("(このページは画像のため、次に添付したPDFを参照してください)\n")
+		parts = append(parts, llm.Text(text.String()), llm.Blob{MIMEType: "application/pdf", Data: page})
+		text.Reset()
+		text.WriteString("\n")
+	}
+	if text.Len() > 1 {
+		parts = append(parts, llm.Text(text.String()))
+	}
+
+	switch scanned {
+	case 0:
+		return parts, MethodPDFText, nil
+	case len(pages):
+		return parts, MethodPDFBlob, nil
+	}
+	return parts, MethodPDFMixed, nil
+}
+
+// splitPages splits a PDF into one-page PDFs, keyed by page number.
+func splitPages(data []byte) (map[int][]byte, error) {
+	spans, err := api.SplitRaw(bytes.NewReader(data), 1, nil)
+	if err != nil {
+		return nil, err
+	}
+	pages := make(map[int][]byte, len(spans))
+	for _, span := range spans {
+		page, err := io.ReadAll(span.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", span.From, err)
+		}
+		pages[span.From] = page
+	}
+	return pages, nil
+}
+
+// pageTexts returns the plain text of every page of a PDF.
+func pageTexts(data []byte) (pages []string, err error) {
+	// The PDF reader panics on some malformed files.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed PDF: %v", r)
+		}
+	}()
+	r, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i <= r.NumPage(); i++ {
+		p := r.Page(i)
+		if p.V.IsNull() {
+			pages = append(pages, "")
+			continue
+		}
+		fonts := map[string]*pdf.Font{}
+		for _, name := range p.Fonts() {
+			f := p.Font(name)
+			fonts[name] = &f
+		}
+		t, err := p.GetPlainText(fonts)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", i, err)
+		}
+		pages = append(pages, t)
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("PDF has no pages")
+	}
+	return pages, nil
+}
+
+func countChars(s string) int {
+	n := 0
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			n++
+		}
+	}
+	return n
+}
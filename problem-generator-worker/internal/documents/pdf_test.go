@@ -0,0 +1,67 @@
+package documents
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/llm"
+)
+
+// blankPDF returns a PDF with n empty pages, i.e. a file without a text layer.
+func blankPDF(n int) []byte {
+	var b bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	b.WriteString("%PDF-1.4\n")
+	var kids []string
+	for i := 0; i < n; i++ {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 3+i))
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), n))
+	for i := 0; i < n; i++ {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 200 200] /Contents %d 0 R >>", 3+n+i))
+	}
+	for i := 0; i < n; i++ {
+		obj("<< /Length 0 >>\nstream\n\nendstream")
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return b.Bytes()
+}
+
+func TestPDFPartsSendsScannedPagesOneByOne(t *testing.T) {
+	parts, method, err := pdfParts(blankPDF(3))
+	if err != nil {
+		t.Fatalf("pdfParts() error = %v", err)
+	}
+	if method != MethodPDFBlob {
+		t.Errorf("pdfParts() method = %q, want %q", method, MethodPDFBlob)
+	}
+	if len(parts) != 6 {
+		t.Fatalf("pdfParts() returned %d parts, want a marker and a page for each of 3 pages", len(parts))
+	}
+	for i := 0; i < 3; i++ {
+		text, ok := parts[2*i].(llm.Text)
+		if !ok || !strings.Contains(string(text), llm.PageMarker(i+1)) {
+			t.Errorf("part %d = %v, want the marker of page %d", 2*i, parts[2*i], i+1)
+		}
+		blob, ok := parts[2*i+1].(llm.Blob)
+		if !ok {
+			t.Fatalf("part %d is %T, want a PDF of page %d", 2*i+1, parts[2*i+1], i+1)
+		}
+		if n, err := api.PageCount(bytes.NewReader(blob.Data), nil); err != nil || n != 1 {
+			t.Errorf("PDF of page %d has %d pages (error %v), want 1", i+1, n, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package documents
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/llm"
+)
+
+var slideNameRegex = regexp.MustCompile(`^ppt/slides/slide(\d+)\.xml$`)
+
+// pptxText converts a PowerPoint presentation to text. Every slide starts with
+// an llm.SlideMarker line so chunking can split between slides, slide titles
+// become "#" headings, tables become rows of "|"-separated cells and equations
+// are rendered as LaTeX.
+func pptxText(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open PPTX: %w", err)
+	}
+	names := slideOrder(zr)
+	if len(names) == 0 {
+		return "", fmt.Errorf("PPTX has no slides")
+	}
+	var sb strings.Builder
+	for i, name := range names {
+		slide, err := openZipEntry(zr, name)
+		if err != nil {
+			return "", fmt.Errorf("failed to read slide %d: %w", i+1, err)
+		}
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString(llm.SlideMarker(i + 1))
+		sb.WriteString("\n")
+		var blocks []string
+		collectSlideText(slide, &blocks)
+		sb.WriteString(strings.Join(blocks, "\n"))
+	}
+	return sb.String(), nil
+}
+
+// slideOrder returns the slide entries in presentation order. The order comes
+// from the slide list in ppt/presentation.xml; if it cannot be read, slides
+// are ordered by the number in their file name.
+func slideOrder(zr *zip.Reader) []string {
+	exists := map[string]bool{}
+	var numbered []string
+	for _, f := range zr.File {
+		exists[f.Name] = true
+		if slideNameRegex.MatchString(f.Name) {
+			numbered = append(numbered, f.Name)
+		}
+	}
+
+	pres, perr := openZipEntry(zr, "ppt/presentation.xml")
+	rels, rerr := openZipEntry(zr, "ppt/_rels/presentation.xml.rels")
+	if perr == nil && rerr == nil {
+		targets := map[string]string{}
+		for _, rel := range rels.find("Relationships").children {
+			targets[rel.attr("Id")] = path.Join("ppt", rel.attr("Target"))
+		}
+		var ordered []string
+		for _, id := range pres.find("sldIdLst").children {
+			if name := targets[id.attr("id")]; exists[name] {
+				ordered = append(ordered, name)
+			}
+		}
+		if len(ordered) > 0 {
+			return ordered
+		}
+	}
+
+	sort.Slice(numbered, func(i, j int) bool {
+		a, _ := strconv.Atoi(slideNameRegex.FindStringSubmatch(numbered[i])[1])
+		b, _ := strconv.Atoi(slideNameRegex.FindStringSubmatch(numbered[j])[1])
+		return a < b
+	})
+	return numbered
+}
+
+// collectSlideText appends the text of every shape and table below n.
+func collectSlideText(n *node, blocks *[]string) {
+	for _, c := range n.children {
+		switch c.name {
+		case "sp":
+			body := c.child("txBody")
+			if body == nil {
+				continue
+			}
+			var paras []string
+			for _, p := range body.children {
+				if p.name == "p" {
+					if text := strings.TrimSpace(inlineText(p)); text != "" {
+						paras = append(paras, text)
+					}
+				}
+			}
+			if len(paras) == 0 {
+				continue
+			}
+			switch c.find("nvPr").child("ph").attr("type") {
+			case "title", "ctrTitle":
+				paras[0] = "# " + paras[0]
+			}
+			*blocks = append(*blocks, paras...)
+		case "tbl":
+			if text := tableText(c); text != "" {
+				*blocks = append(*blocks, text)
+			}
+		default:
+			collectSlideText(c, blocks)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package documents
+
+import (
+	"regexp"
+	"strings"
+)
+
+var texTitleRegex = regexp.MustCompile(`\\title\{([^}]*)\}`)
+
+// texText prepares LaTeX source for the model. Comments and the preamble are
+// removed (the \title is kept), while sectioning commands and math are left as
+// they are: the model reads LaTeX directly, and chunking splits at \section.
+func texText(src string) string {
+	src = stripTeXComments(src)
+	begin := strings.Index(src, `\begin{document}`)
+	if begin < 0 {
+		return strings.TrimSpace(src)
+	}
+	body := src[begin+len(`\begin{document}`):]
+	if end := strings.Index(body, `\end{document}`); end >= 0 {
+		body = body[:end]
+	}
+	body = strings.TrimSpace(body)
+	if m := texTitleRegex.FindStringSubmatch(src[:begin]); m != nil {
+		body = `\title{` + m[1] + "}\n\n" + body
+	}
+	return body
+}
+
+// stripTeXComments removes every comment, from an unescaped % to the end of
+// its line. A % is escaped by an odd number of backslashes before it: \% is a
+// percent sign, while \\% is a line break followed by a comment.
+func stripTeXComments(src string) string {
+	lines := strings.Split(src, "\n")
+	for i, line := range lines {
+		backslashes := 0
+		for j := 0; j < len(line); j++ {
+			if line[j] == '%' && backslashes%2 == 0 {
+				lines[i] = line[:j]
+				break
+			}
+			if line[j] == '\\' {
+				backslashes++
+			} else {
+				backslashes = 0
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
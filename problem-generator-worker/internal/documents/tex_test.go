@@ -0,0 +1,66 @@
+package documents
+
+import "testing"
+
+func TestStripTeXComments(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{name: "no comments", src: "$x^2$\n\\section{A}", want: "$x^2$\n\\section{A}"},
+		{name: "whole line", src: "% comment\ntext", want: "\ntext"},
+		{name: "end of line", src: "text % comment\nmore", want: "text \nmore"},
+		{name: "escaped percent", src: `50\% off % comment`, want: `50\% off `},
+		{name: "line break before a comment", src: `a \\% comment`, want: `a \\`},
+		{name: "escaped backslash and percent", src: `a \\\% b`, want: `a \\\% b`},
+		{name: "only the first unescaped percent counts", src: `a % b % c`, want: `a `},
+		{name: "backslashes separated by text", src: `\a\% b\\c% d`, want: `\a\% b\\c`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripTeXComments(tt.src); got != tt.want {
+				t.Errorf("stripTeXComments(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTeXText(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "fragment without a document",
+			src:  "\n\\section{微分} % 第1問\n$f'(x)$\n",
+			want: "\\section{微分} \n$f'(x)$",
+		},
+		{
+			name: "preamble is dropped and the title kept",
+			src: "\\documentclass{article}\n\\usepackage{amsmath}\n\\title{解析学 期末試験}\n" +
+				"\\begin{document}\n\\maketitle\n問1 % 易しい\n\\end{document}\ntrailing",
+			want: "\\title{解析学 期末試験}\n\n\\maketitle\n問1",
+		},
+		{
+			name: "commented-out title is ignored",
+			src:  "% \\title{古い題名}\n\\begin{document}\n本文\n\\end{document}",
+			want: "本文",
+		},
+		{
+			name: "document without an end",
+			src:  "\\begin{document}\n本文\n",
+			want: "本文",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := texText(tt.src); got != tt.want {
+				t.Errorf("texText(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
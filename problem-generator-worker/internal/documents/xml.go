@@ -0,0 +1,114 @@
+package documents
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// node is a parsed XML element. Office documents are parsed into a tree and
+// walked by local element name, ignoring namespace prefixes (w:, a:, m:, ...).
+type node struct {
+	name     string
+	attrs    map[string]string
+	children []*node
+	text     string
+}
+
+// parseXML reads an XML document into a tree of nodes. Character data is kept
+// on the element that directly contains it.
+func parseXML(r io.Reader) (*node, error) {
+	root := &node{}
+	stack := []*node{root}
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return root, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		top := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{name: t.Name.Local, attrs: map[string]string{}}
+			for _, a := range t.Attr {
+				n.attrs[a.Name.Local] = a.Value
+			}
+			top.children = append(top.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			top.text += string(t)
+		}
+	}
+}
+
+// child returns the first direct child called name, or nil.
+func (n *node) child(name string) *node {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// find returns the first descendant called name, or nil.
+func (n *node) find(name string) *node {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+		if found := c.find(name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// attr returns the value of an attribute of n, or "" if n is nil.
+func (n *node) attr(name string) string {
+	if n == nil {
+		return ""
+	}
+	return n.attrs[name]
+}
+
+// inlineText renders the text runs below n. Office math is rendered as LaTeX
+// between dollar signs so formulas survive the conversion.
+func inlineText(n *node) string {
+	switch n.name {
+	case "t":
+		return n.text
+	case "tab":
+		return "\t"
+	case "br", "cr":
+		return "\n"
+	case "delText", "instrText", "rPr", "pPr":
+		return ""
+	case "oMath":
+		return "$" + strings.TrimSpace(omml(n)) + "$"
+	case "oMathPara":
+		var sb strings.Builder
+		for _, c := range n.children {
+			if c.name == "oMath" {
+				sb.WriteString("\n$$" + strings.TrimSpace(omml(c)) + "$$\n")
+			}
+		}
+		return sb.String()
+	}
+	var sb strings.Builder
+	for _, c := range n.children {
+		sb.WriteString(inlineText(c))
+	}
+	return sb.String()
+}
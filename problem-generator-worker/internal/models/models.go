@@ -27,9 +27,19 @@ type SubQuestion struct {
 	QuestionIndex string   `json:"question_index,omitempty"`
 	Topic         string   `json:"topic,omitempty"`
 	Keywords      []string `json:"keywords,omitempty"`
-	Difficulty    string   `json:"difficulty,omitempty"`
+	Difficulty    string   `json:"difficulty,omitempty" enum:"easy,medium,hard"`
 }
 
+// 難易度として許される値。構造体の enum タグはモデルの出力スキーマにも使われます。
+const (
+	DifficultyEasy   = "easy"
+	DifficultyMedium = "medium"
+	DifficultyHard   = "hard"
+)
+
+// Difficulties は許される難易度の一覧です。
+var Difficulties = []string{DifficultyEasy, DifficultyMedium, DifficultyHard}
+
 // !! 修正: AIが生成する問題と解答のJSON構造に合わせた新しいモデル
 type GeneratedData struct {
 	ExamMeta  GeneratedExamMeta   `json:"exam_meta"`
@@ -41,11 +51,60 @@ type GeneratedExamMeta struct {
 	QuestionFormatIsLatex bool   `json:"question_format_is_latex"`
 	AnswerFormatIsLatex   bool   `json:"answer_format_is_latex"`
 }
+
+// FindQuestion は question_index が一致する問題の位置を返します (見つからなければ -1)。
+func (gd *GeneratedData) FindQuestion(questionIndex string) int {
+	for i, q := range gd.Questions {
+		if q.QuestionIndex == questionIndex {
+			return i
+		}
+	}
+	return -1
+}
+
 type GeneratedQuestion struct {
 	QuestionIndex string   `json:"question_index"`
 	Topic         string   `json:"topic"`
 	Keywords      []string `json:"keywords"`
-	Difficulty    string   `json:"difficulty"`
+	Difficulty    string   `json:"difficulty" enum:"easy,medium,hard"`
 	QuestionText  string   `json:"question_text"`
 	AnswerText    string   `json:"answer_text"`
 }
+
+// CoverageReport は生成された問題と抽出された構造の照合結果です (problems.coverage_report)。
+type CoverageReport struct {
+	// Expected は構造に含まれる小問の数、Generated は最初の生成結果に含まれていた問題の数です。
+	Expected  int `json:"expected"`
+	Generated int `json:"generated"`
+	// MatchedByIndex と MatchedByTopic は question_index またはトピックで対応付けられた問題の数です。
+	MatchedByIndex int `json:"matched_by_index"`
+	MatchedByTopic int `json:"matched_by_topic"`
+	// Missing は生成結果に含まれていなかった小問、Filled はそのうち個別に生成し直せた小問です。
+	Missing    []CoverageItem `json:"missing,omitempty"`
+	Filled     []CoverageItem `json:"filled,omitempty"`
+	Unresolved []CoverageItem `json:"unresolved,omitempty"`
+	// Extras は構造にない問題です。試験からは除外し、ここに記録します。
+	Extras []GeneratedQuestion `json:"extras,omitempty"`
+	// Complete はすべての小問に問題が1問ずつ対応していることを表します。
+	Complete bool `json:"complete"`
+}
+
+// CoverageItem は照合結果に現れる小問です。
+type CoverageItem struct {
+	QuestionIndex string `json:"question_index,omitempty"`
+	Topic         string `json:"topic,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// GenerationOptions はリクエストごとの生成オプションです (problems.generation_options)。
+// ゼロ値の項目はAIの判断に任せます。
+type GenerationOptions struct {
+	NumQuestions  int      `json:"num_questions,omitempty"`
+	Difficulty    string   `json:"difficulty,omitempty"`
+	QuestionTypes []string `json:"question_types,omitempty"`
+	Language      string   `json:"language,omitempty"`
+	Latex         *bool    `json:"latex,omitempty"`
+	Model         string   `json:"model,omitempty"`
+	// Review は構造抽出の後に 'awaiting_review' で停止し、利用者の承認を待ちます。
+	Review bool `json:"review,omitempty"`
+}
@@ -1,73 +1,315 @@
 package processor
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
-	"github.com/your-username/edumint/problem-generator-worker/internal/services/gemini"
+	"github.com/your-username/edumint/problem-generator-worker/internal/documents"
+	"github.com/your-username/edumint/problem-generator-worker/internal/models"
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/llm"
 	"github.com/your-username/edumint/problem-generator-worker/internal/storage"
 )
 
 type Processor struct {
 	StorageService *storage.Service
-	GeminiService  *gemini.Service
+	Provider       llm.Provider
+	// CancelPollInterval is how often a running job checks whether the user
+	// cancelled it. Defaults to 2 seconds.
+	CancelPollInterval time.Duration
+	// GenerationConcurrency caps how many major sections of one job are
+	// generated at the same time. Defaults to 3.
+	GenerationConcurrency int
+}
+
+// ErrCancelled is the cause of jobs that stopped because the user cancelled them.
+var ErrCancelled = errors.New("job was cancelled")
+
+// Job is the message published on the generation queue.
+type Job struct {
+	ProblemID int `json:"problem_id"`
+	// Kind is empty for a full generation job.
+	Kind           string `json:"kind,omitempty"`
+	RegenerationID int    `json:"regeneration_id,omitempty"`
+}
+
+// JobKindRegenerateQuestion regenerates a single question of a completed problem.
+const JobKindRegenerateQuestion = "regenerate_question"
+
+// JobError describes a failed job: the stage it failed at and whether
+// retrying the job later may succeed.
+type JobError struct {
+	ProblemID int
+	// RegenerationID is set for question regeneration jobs.
+	RegenerationID int
+	Stage          string
+	Err            error
+	Transient      bool
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("failed at stage '%s': %v", e.Stage, e.Err)
+}
+
+func (e *JobError) Unwrap() error { return e.Err }
+
+// IsTransient reports whether err is a JobError worth retrying.
+func IsTransient(err error) bool {
+	var jobErr *JobError
+	return errors.As(err, &jobErr) && jobErr.Transient
 }
 
 // ProcessJob orchestrates the entire problem generation process for a single job.
-func (p *Processor) ProcessJob(body []byte) {
-	var jobData map[string]int
-	if err := json.Unmarshal(body, &jobData); err != nil {
+// It returns a *JobError when the job did not complete.
+func (p *Processor) ProcessJob(ctx context.Context, body []byte) error {
+	var job Job
+	if err := json.Unmarshal(body, &job); err != nil {
 		log.Printf("Error unmarshalling job data: %v", err)
-		return
+		return &JobError{Stage: "decode_job", Err: err}
+	}
+	switch job.Kind {
+	case "":
+	case JobKindRegenerateQuestion:
+		return p.regenerateQuestion(ctx, job.RegenerationID)
+	default:
+		return &JobError{ProblemID: job.ProblemID, Stage: "decode_job", Err: fmt.Errorf("unknown job kind '%s'", job.Kind)}
 	}
-	problemID := jobData["problem_id"]
+	problemID := job.ProblemID
 	log.Printf("Processing job for problem ID: %d", problemID)
 
+	// Cancelling the context aborts in-flight LLM requests as soon as the user cancels the job.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go p.watchCancellation(ctx, cancel, problemID)
+
+	ctx = p.withAttemptTrace(ctx, problemID)
+
 	// Helper function to handle errors and update the DB status.
-	handleError := func(err error, stage string) {
-		errorMsg := fmt.Sprintf("failed at stage '%s': %v", stage, err)
-		log.Printf("Error processing job %d: %s", problemID, errorMsg)
-		p.StorageService.UpdateStatus(problemID, "failed", errorMsg)
+	// Transient failures put the job back to 'pending' since it will be retried,
+	// and cancelled jobs keep their 'cancelled' status.
+	fail := func(jobErr *JobError) error {
+		if errors.Is(jobErr.Err, ErrCancelled) {
+			log.Printf("Job %d was cancelled before stage '%s' finished", problemID, jobErr.Stage)
+			return jobErr
+		}
+		log.Printf("Error processing job %d (transient: %t): %s", problemID, jobErr.Transient, jobErr)
+		status := "failed"
+		if jobErr.Transient {
+			status = "pending"
+		}
+		p.StorageService.UpdateStatus(problemID, status, jobErr.Error())
+		return jobErr
+	}
+	handleError := func(err error, stage string, transient bool) error {
+		return fail(&JobError{ProblemID: problemID, Stage: stage, Err: err, Transient: transient})
 	}
 
 	// 1. Update job status to 'processing'
 	if err := p.StorageService.UpdateStatus(problemID, "processing", ""); err != nil {
-		handleError(err, "update_status_processing")
-		return
+		return handleError(err, "update_status_processing", true)
 	}
 
-	// 2. Retrieve input data from DB
-	inputParts, err := p.StorageService.GetInputData(problemID)
+	opts, err := p.StorageService.GetGenerationOptions(problemID)
 	if err != nil {
-		handleError(err, "get_input_data")
-		return
+		permanent := errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidOptions)
+		return handleError(err, "load_options", !permanent)
 	}
 
-	// 3. Call Gemini for structure extraction
-	problemStructure, structureTokens, err := p.GeminiService.ExtractStructure(inputParts...)
+	// 2. Resume from the checkpointed structure if a previous attempt already extracted it,
+	// or use the blueprint the job was submitted with
+	problemStructure, extracted, err := p.StorageService.LoadStructure(problemID)
 	if err != nil {
-		handleError(err, "extract_structure")
-		return
+		return handleError(err, "load_checkpoint", !errors.Is(err, storage.ErrNotFound))
+	}
+	if extracted {
+		log.Printf("Job %d already has a structure (checkpoint or blueprint), skipping extraction", problemID)
+	} else {
+		if jobErr := p.checkCancelled(ctx, problemID, llm.StageExtractStructure); jobErr != nil {
+			return fail(jobErr)
+		}
+		var jobErr *JobError
+		if problemStructure, jobErr = p.extract(ctx, problemID, opts); jobErr != nil {
+			return fail(jobErr)
+		}
 	}
 
-	// 4. Call Gemini for problem generation
-	generated, generationTokens, err := p.GeminiService.GenerateProblem(problemStructure)
-	if err != nil {
-		handleError(err, "generate_problem")
-		return
+	// 3. In review mode, pause until the user has approved the (possibly edited) structure.
+	// Approval re-queues the job, which then resumes here from the checkpoint.
+	if opts.Review {
+		approved, err := p.StorageService.IsStructureApproved(problemID)
+		if err != nil {
+			return handleError(err, "check_review", true)
+		}
+		if !approved {
+			if err := p.StorageService.UpdateStatus(problemID, "awaiting_review", ""); err != nil {
+				return handleError(err, "update_status_awaiting_review", true)
+			}
+			log.Printf("Job %d is awaiting review of its structure", problemID)
+			return nil
+		}
 	}
 
-	// 5. Save the successful result to the database
-	if err := p.StorageService.SaveResult(problemID, problemStructure, generated, structureTokens, generationTokens); err != nil {
-		handleError(err, "save_result")
-		return
+	// 4. Call the LLM provider for problem generation, one request per major section
+	if jobErr := p.checkCancelled(ctx, problemID, llm.StageGenerateProblem); jobErr != nil {
+		return fail(jobErr)
+	}
+	// Do not start generation if the extraction (or other jobs) already used up a budget.
+	if err := p.StorageService.CheckBudget(problemID); err != nil {
+		return handleError(err, "check_budget", !errors.Is(err, storage.ErrBudgetExceeded))
+	}
+	p.StorageService.UpdateStageStatus(problemID, llm.StageGenerateProblem, storage.StageRunning)
+	generated, generationTokens, jobErr := p.generateSections(ctx, problemID, problemStructure, opts)
+	if jobErr != nil {
+		p.StorageService.UpdateStageStatus(problemID, llm.StageGenerateProblem, stageFailureStatus(jobErr))
+		return fail(jobErr)
 	}
 
-	// 6. Final status update to 'completed'
+	// 5. Check that every sub-question got exactly one question, filling in missing ones
+	coverage, fillTokens, jobErr := p.reconcileGeneration(ctx, problemID, generated, problemStructure, opts)
+	if jobErr != nil {
+		p.StorageService.UpdateStageStatus(problemID, llm.StageGenerateProblem, stageFailureStatus(jobErr))
+		return fail(jobErr)
+	}
+	generationTokens.Add(fillTokens)
+
+	// 6. Save the generated questions and the coverage report to the database
+	if err := p.StorageService.SaveGeneration(problemID, generated, generationTokens, coverage); err != nil {
+		return handleError(err, "save_result", true)
+	}
+
+	// 7. Final status update to 'completed'
 	if err := p.StorageService.UpdateStatus(problemID, "completed", ""); err != nil {
-		handleError(err, "update_status_completed")
+		return handleError(err, "update_status_completed", true)
 	}
 
 	log.Printf("Successfully processed job for problem ID: %d", problemID)
+	return nil
+}
+
+// withAttemptTrace records every model attempt made with the returned context.
+func (p *Processor) withAttemptTrace(ctx context.Context, problemID int) context.Context {
+	return llm.WithTrace(ctx, &llm.Trace{
+		OnAttempt: func(a llm.Attempt) {
+			if err := p.StorageService.RecordAttempt(problemID, a); err != nil {
+				log.Printf("Error recording %s attempt %d for job %d: %v", a.Stage, a.Number, problemID, err)
+			}
+		},
+	})
+}
+
+// watchCancellation polls the job status and cancels ctx with ErrCancelled
+// once the user has cancelled the job. It returns when ctx is done.
+func (p *Processor) watchCancellation(ctx context.Context, cancel context.CancelCauseFunc, problemID int) {
+	interval := p.CancelPollInterval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cancelled, err := p.StorageService.IsCancelled(problemID)
+			if err != nil {
+				log.Printf("Error checking cancellation of job %d: %v", problemID, err)
+				continue
+			}
+			if cancelled {
+				log.Printf("Job %d was cancelled by the user, aborting in-flight requests", problemID)
+				cancel(ErrCancelled)
+				return
+			}
+		}
+	}
+}
+
+// checkCancelled returns a *JobError wrapping ErrCancelled if the job was
+// cancelled before stage could start.
+func (p *Processor) checkCancelled(ctx context.Context, problemID int, stage string) *JobError {
+	if errors.Is(context.Cause(ctx), ErrCancelled) {
+		return &JobError{ProblemID: problemID, Stage: stage, Err: ErrCancelled}
+	}
+	cancelled, err := p.StorageService.IsCancelled(problemID)
+	if err != nil {
+		return &JobError{ProblemID: problemID, Stage: stage, Err: err, Transient: true}
+	}
+	if cancelled {
+		return &JobError{ProblemID: problemID, Stage: stage, Err: ErrCancelled}
+	}
+	return nil
+}
+
+// stageError classifies an error returned by the LLM provider, treating
+// errors caused by a user cancellation as ErrCancelled.
+func stageError(ctx context.Context, problemID int, stage string, err error) *JobError {
+	if errors.Is(context.Cause(ctx), ErrCancelled) {
+		return &JobError{ProblemID: problemID, Stage: stage, Err: ErrCancelled}
+	}
+	return &JobError{ProblemID: problemID, Stage: stage, Err: err, Transient: llm.IsTransient(err)}
+}
+
+func stageFailureStatus(jobErr *JobError) string {
+	if errors.Is(jobErr.Err, ErrCancelled) {
+		return storage.StageCancelled
+	}
+	return storage.StageFailed
+}
+
+// extract runs the structure extraction stage and checkpoints its result.
+// It does not touch the overall job status; the caller reports the returned error.
+func (p *Processor) extract(ctx context.Context, problemID int, opts models.GenerationOptions) (*models.ProblemStructure, *JobError) {
+	inputParts, err := p.StorageService.GetInputData(ctx, problemID)
+	if err != nil {
+		permanent := errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrNoInput)
+		return nil, &JobError{ProblemID: problemID, Stage: "get_input_data", Err: err, Transient: !permanent}
+	}
+	// Send uploads as extracted text where possible; only scanned PDF pages go to the model as a file.
+	inputParts, method, err := documents.Prepare(inputParts)
+	if serr := p.StorageService.SetInputMethod(problemID, method); serr != nil {
+		log.Printf("Error recording input method of job %d: %v", problemID, serr)
+	}
+	if err != nil {
+		return nil, &JobError{ProblemID: problemID, Stage: "prepare_input", Err: err}
+	}
+
+	// Retries and fallbacks inside the provider are recorded via the trace.
+	p.StorageService.UpdateStageStatus(problemID, llm.StageExtractStructure, storage.StageRunning)
+	problemStructure, structureTokens, err := p.Provider.ExtractStructure(ctx, opts, inputParts...)
+	if err != nil {
+		jobErr := stageError(ctx, problemID, llm.StageExtractStructure, err)
+		p.StorageService.UpdateStageStatus(problemID, llm.StageExtractStructure, stageFailureStatus(jobErr))
+		return nil, jobErr
+	}
+
+	// Checkpoint immediately so a failure in generation does not throw the extraction away.
+	if err := p.StorageService.SaveStructure(problemID, problemStructure, structureTokens); err != nil {
+		return nil, &JobError{ProblemID: problemID, Stage: "save_structure", Err: err, Transient: true}
+	}
+	return problemStructure, nil
+}
+
+// MarkFailed records a job that will not be retried any more as 'failed'.
+func (p *Processor) MarkFailed(err error) {
+	var jobErr *JobError
+	if !errors.As(err, &jobErr) || errors.Is(err, ErrCancelled) {
+		return
+	}
+	if jobErr.RegenerationID != 0 {
+		// A failed regeneration leaves the problem itself untouched.
+		if uerr := p.StorageService.UpdateRegenerationStatus(jobErr.RegenerationID, storage.StageFailed, jobErr.Error()); uerr != nil {
+			log.Printf("Error marking regeneration %d as failed: %v", jobErr.RegenerationID, uerr)
+		}
+		return
+	}
+	if jobErr.ProblemID == 0 {
+		return
+	}
+	if uerr := p.StorageService.UpdateStatus(jobErr.ProblemID, "failed", jobErr.Error()); uerr != nil {
+		log.Printf("Error marking job %d as failed: %v", jobErr.ProblemID, uerr)
+	}
 }
@@ -0,0 +1,136 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/models"
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/llm"
+)
+
+// StageReconcile is the stage name of errors raised while filling in questions
+// the generation stage left out.
+const StageReconcile = "reconcile"
+
+// slot is one sub-question of the structure and the question assigned to it.
+type slot struct {
+	sectionTitle string
+	sub          models.SubQuestion
+	question     *models.GeneratedQuestion
+}
+
+// reconcile matches generated questions to the sub-questions of the structure,
+// first by question_index and then by topic. It returns the slots in structure
+// order and a report listing missing sub-questions and extra questions.
+func reconcile(gd *models.GeneratedData, ps *models.ProblemStructure) ([]*slot, *models.CoverageReport) {
+	var slots []*slot
+	for _, section := range ps.Structure.MajorSections {
+		for _, sq := range section.SubQuestions {
+			slots = append(slots, &slot{sectionTitle: section.SectionTitle, sub: sq})
+		}
+	}
+	report := &models.CoverageReport{Expected: len(slots), Generated: len(gd.Questions)}
+
+	matched := make([]bool, len(gd.Questions))
+	for i := range gd.Questions {
+		q := &gd.Questions[i]
+		if q.QuestionIndex == "" {
+			continue
+		}
+		for _, s := range slots {
+			if s.question == nil && s.sub.QuestionIndex == q.QuestionIndex {
+				s.question, matched[i] = q, true
+				report.MatchedByIndex++
+				break
+			}
+		}
+	}
+	for i := range gd.Questions {
+		q := &gd.Questions[i]
+		if matched[i] || llm.Normalize(q.Topic) == "" {
+			continue
+		}
+		for _, s := range slots {
+			if s.question == nil && llm.Normalize(s.sub.Topic) == llm.Normalize(q.Topic) {
+				s.question, matched[i] = q, true
+				report.MatchedByTopic++
+				break
+			}
+		}
+	}
+
+	for i, q := range gd.Questions {
+		if !matched[i] {
+			report.Extras = append(report.Extras, q)
+		}
+	}
+	for _, s := range slots {
+		if s.question == nil {
+			report.Missing = append(report.Missing, models.CoverageItem{QuestionIndex: s.sub.QuestionIndex, Topic: s.sub.Topic})
+		} else if s.sub.QuestionIndex != "" {
+			// A question matched by topic takes the index of its sub-question.
+			s.question.QuestionIndex = s.sub.QuestionIndex
+		}
+	}
+	return slots, report
+}
+
+// reconcileGeneration makes the generated exam follow the structure: extra
+// questions are dropped (and kept in the report), and questions for missing
+// sub-questions are generated one at a time. Sub-questions that still cannot be
+// generated are reported as unresolved; only transient errors and cancellation
+// fail the job. The returned usage covers the additional requests.
+func (p *Processor) reconcileGeneration(ctx context.Context, problemID int, gd *models.GeneratedData, ps *models.ProblemStructure, opts models.GenerationOptions) (*models.CoverageReport, *llm.Usage, *JobError) {
+	slots, report := reconcile(gd, ps)
+	usage := &llm.Usage{}
+	// abort records the tokens spent so far, as failed sections do, since the
+	// filled questions are generated again when the job is retried.
+	abort := func(jobErr *JobError) (*models.CoverageReport, *llm.Usage, *JobError) {
+		if err := p.StorageService.AddGenerationUsage(problemID, usage); err != nil {
+			log.Printf("Error recording generation usage of job %d: %v", problemID, err)
+		}
+		return nil, usage, jobErr
+	}
+	if len(report.Extras) > 0 {
+		log.Printf("Job %d: dropping %d generated question(s) that are not in the structure", problemID, len(report.Extras))
+	}
+
+	for _, s := range slots {
+		if s.question != nil {
+			continue
+		}
+		item := models.CoverageItem{QuestionIndex: s.sub.QuestionIndex, Topic: s.sub.Topic}
+		if jobErr := p.checkCancelled(ctx, problemID, StageReconcile); jobErr != nil {
+			return abort(jobErr)
+		}
+		log.Printf("Job %d: generating missing question '%s' (%s)", problemID, item.QuestionIndex, item.Topic)
+		q, u, err := p.Provider.RegenerateQuestion(ctx, llm.QuestionRequest{
+			ExamMeta:     ps.ExamMeta,
+			SectionTitle: s.sectionTitle,
+			SubQuestion:  s.sub,
+		}, opts)
+		usage.Add(u)
+		if err != nil {
+			jobErr := stageError(ctx, problemID, StageReconcile, err)
+			if jobErr.Transient || errors.Is(jobErr.Err, ErrCancelled) {
+				return abort(jobErr)
+			}
+			item.Error = err.Error()
+			report.Unresolved = append(report.Unresolved, item)
+			continue
+		}
+		s.question = q
+		report.Filled = append(report.Filled, item)
+	}
+
+	questions := make([]models.GeneratedQuestion, 0, len(slots))
+	for _, s := range slots {
+		if s.question != nil {
+			questions = append(questions, *s.question)
+		}
+	}
+	gd.Questions = questions
+	report.Complete = len(report.Unresolved) == 0
+	return report, usage, nil
+}
@@ -0,0 +1,114 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/models"
+)
+
+func TestReconcile(t *testing.T) {
+	ps := &models.ProblemStructure{Structure: models.StructureSection{MajorSections: []models.MajorSection{
+		{SectionTitle: "微分", SubQuestions: []models.SubQuestion{
+			{QuestionIndex: "1-1", Topic: "導関数"},
+			{QuestionIndex: "1-2", Topic: "Chain Rule"},
+		}},
+		{SectionTitle: "積分", SubQuestions: []models.SubQuestion{
+			{QuestionIndex: "2-1", Topic: "定積分"},
+		}},
+	}}}
+	q := func(index, topic string) models.GeneratedQuestion {
+		return models.GeneratedQuestion{QuestionIndex: index, Topic: topic}
+	}
+
+	tests := []struct {
+		name      string
+		questions []models.GeneratedQuestion
+		// want is the index of the question assigned to each slot, "" if none.
+		want           []string
+		wantByIndex    int
+		wantByTopic    int
+		wantMissing    []string
+		wantExtraTopic []string
+	}{
+		{
+			name:        "every question matches by index",
+			questions:   []models.GeneratedQuestion{q("2-1", "定積分"), q("1-1", "導関数"), q("1-2", "Chain Rule")},
+			want:        []string{"1-1", "1-2", "2-1"},
+			wantByIndex: 3,
+		},
+		{
+			name:        "renumbered questions match by topic ignoring case and spaces",
+			questions:   []models.GeneratedQuestion{q("1", "導関数"), q("", "chain rule"), q("3", " 定 積 分 ")},
+			want:        []string{"1-1", "1-2", "2-1"},
+			wantByTopic: 3,
+		},
+		{
+			name:        "index wins over topic",
+			questions:   []models.GeneratedQuestion{q("1-2", "導関数"), q("9", "導関数")},
+			want:        []string{"1-1", "1-2", ""},
+			wantByIndex: 1,
+			wantByTopic: 1,
+			wantMissing: []string{"2-1"},
+		},
+		{
+			name:           "missing and extra questions are reported",
+			questions:      []models.GeneratedQuestion{q("1-1", "導関数"), q("5-1", "級数"), q("", "")},
+			want:           []string{"1-1", "", ""},
+			wantByIndex:    1,
+			wantMissing:    []string{"1-2", "2-1"},
+			wantExtraTopic: []string{"級数", ""},
+		},
+		{
+			name:           "duplicate questions fill one slot",
+			questions:      []models.GeneratedQuestion{q("1-1", "導関数"), q("1-1", "導関数"), q("1-2", "Chain Rule"), q("2-1", "定積分")},
+			want:           []string{"1-1", "1-2", "2-1"},
+			wantByIndex:    3,
+			wantExtraTopic: []string{"導関数"},
+		},
+		{
+			name:        "no questions",
+			want:        []string{"", "", ""},
+			wantMissing: []string{"1-1", "1-2", "2-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gd := &models.GeneratedData{Questions: tt.questions}
+			slots, report := reconcile(gd, ps)
+
+			var got []string
+			for _, s := range slots {
+				if s.question == nil {
+					got = append(got, "")
+				} else {
+					got = append(got, s.question.QuestionIndex)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("assigned questions = %q, want %q", got, tt.want)
+			}
+			if report.Expected != 3 || report.Generated != len(tt.questions) {
+				t.Errorf("Expected/Generated = %d/%d, want 3/%d", report.Expected, report.Generated, len(tt.questions))
+			}
+			if report.MatchedByIndex != tt.wantByIndex || report.MatchedByTopic != tt.wantByTopic {
+				t.Errorf("matched by index/topic = %d/%d, want %d/%d", report.MatchedByIndex, report.MatchedByTopic, tt.wantByIndex, tt.wantByTopic)
+			}
+			var missing []string
+			for _, m := range report.Missing {
+				missing = append(missing, m.QuestionIndex)
+			}
+			if !reflect.DeepEqual(missing, tt.wantMissing) {
+				t.Errorf("missing = %v, want %v", missing, tt.wantMissing)
+			}
+			var extras []string
+			for _, e := range report.Extras {
+				extras = append(extras, e.Topic)
+			}
+			if !reflect.DeepEqual(extras, tt.wantExtraTopic) {
+				t.Errorf("extra topics = %q, want %q", extras, tt.wantExtraTopic)
+			}
+		})
+	}
+}
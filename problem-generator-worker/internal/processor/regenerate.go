@@ -0,0 +1,105 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/llm"
+	"github.com/your-username/edumint/problem-generator-worker/internal/storage"
+)
+
+// regenerateQuestion replaces a single question of a completed problem. Only
+// the regeneration request changes status; the problem keeps its status.
+func (p *Processor) regenerateQuestion(ctx context.Context, regenerationID int) error {
+	regen, err := p.StorageService.GetRegeneration(regenerationID)
+	if err != nil {
+		return &JobError{RegenerationID: regenerationID, Stage: "load_regeneration", Err: err, Transient: !errors.Is(err, storage.ErrNotFound)}
+	}
+	problemID := regen.ProblemID
+	log.Printf("Regenerating question '%s' of problem ID: %d", regen.QuestionIndex, problemID)
+
+	// Cancelling the problem aborts the in-flight LLM request, as for generation jobs.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go p.watchCancellation(ctx, cancel, problemID)
+
+	ctx = p.withAttemptTrace(ctx, problemID)
+
+	fail := func(stage string, err error, transient bool) error {
+		jobErr := &JobError{ProblemID: problemID, RegenerationID: regenerationID, Stage: stage, Err: err, Transient: transient}
+		log.Printf("Error regenerating question '%s' of problem %d (transient: %t): %s", regen.QuestionIndex, problemID, transient, jobErr)
+		status := storage.StageFailed
+		if transient {
+			status = storage.StagePending
+		}
+		p.StorageService.UpdateRegenerationStatus(regenerationID, status, jobErr.Error())
+		return jobErr
+	}
+
+	if err := p.StorageService.UpdateRegenerationStatus(regenerationID, storage.StageRunning, ""); err != nil {
+		return fail("update_status_running", err, true)
+	}
+
+	problemStructure, extracted, err := p.StorageService.LoadStructure(problemID)
+	if err != nil {
+		return fail("load_structure", err, !errors.Is(err, storage.ErrNotFound))
+	}
+	if !extracted {
+		return fail("load_structure", fmt.Errorf("problem %d has no extracted structure", problemID), false)
+	}
+	generated, err := p.StorageService.LoadGeneration(problemID)
+	if err != nil {
+		permanent := errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrQuestionNotFound)
+		return fail("load_generation", err, !permanent)
+	}
+	opts, err := p.StorageService.GetGenerationOptions(problemID)
+	if err != nil {
+		permanent := errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidOptions)
+		return fail("load_options", err, !permanent)
+	}
+
+	i := generated.FindQuestion(regen.QuestionIndex)
+	if i < 0 {
+		return fail("find_question", fmt.Errorf("%w: '%s'", storage.ErrQuestionNotFound, regen.QuestionIndex), false)
+	}
+	previous := generated.Questions[i]
+	req := llm.QuestionRequest{
+		ExamMeta:     problemStructure.ExamMeta,
+		Previous:     &previous,
+		Instructions: regen.Instructions,
+	}
+	// Prefer the planned sub-question; fall back to what the generated question says about itself.
+	req.SubQuestion.QuestionIndex = previous.QuestionIndex
+	req.SubQuestion.Topic = previous.Topic
+	req.SubQuestion.Keywords = previous.Keywords
+	req.SubQuestion.Difficulty = previous.Difficulty
+	for _, section := range problemStructure.Structure.MajorSections {
+		for _, sq := range section.SubQuestions {
+			if sq.QuestionIndex == regen.QuestionIndex {
+				req.SectionTitle = section.SectionTitle
+				req.SubQuestion = sq
+			}
+		}
+	}
+
+	if jobErr := p.checkCancelled(ctx, problemID, llm.StageRegenerateQuestion); jobErr != nil {
+		return fail(jobErr.Stage, jobErr.Err, jobErr.Transient)
+	}
+	// Do not call the model if a budget of the problem's owner, course or organization is used up.
+	if err := p.StorageService.CheckBudget(problemID); err != nil {
+		return fail("check_budget", err, !errors.Is(err, storage.ErrBudgetExceeded))
+	}
+	question, usage, err := p.Provider.RegenerateQuestion(ctx, req, opts)
+	if err != nil {
+		jobErr := stageError(ctx, problemID, llm.StageRegenerateQuestion, err)
+		return fail(jobErr.Stage, jobErr.Err, jobErr.Transient)
+	}
+	if err := p.StorageService.SaveRegeneratedQuestion(regen, question, usage); err != nil {
+		return fail("save_question", err, !errors.Is(err, storage.ErrQuestionNotFound))
+	}
+
+	log.Printf("Successfully regenerated question '%s' of problem ID: %d", regen.QuestionIndex, problemID)
+	return nil
+}
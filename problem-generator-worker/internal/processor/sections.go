@@ -0,0 +1,134 @@
+package processor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/models"
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/llm"
+)
+
+// defaultGenerationConcurrency is used when Processor.GenerationConcurrency is not set.
+const defaultGenerationConcurrency = 3
+
+// sectionOutcome is the result of generating one major section.
+type sectionOutcome struct {
+	generated *models.GeneratedData
+	usage     *llm.Usage
+	err       *JobError
+}
+
+// generateSections generates each major section with its own request, running
+// up to GenerationConcurrency requests at once, and merges the results in
+// structure order. Sections checkpointed by a previous attempt are reused as
+// long as the section and the exam information are unchanged.
+//
+// A section that fails permanently is left out; its sub-questions are then
+// reported as missing and filled in one by one by the reconciliation step. The
+// job only fails if every section failed, or if any section failed with a
+// transient error or was cancelled, in which case the retried job generates
+// just the sections that are still missing.
+func (p *Processor) generateSections(ctx context.Context, problemID int, ps *models.ProblemStructure, opts models.GenerationOptions) (*models.GeneratedData, *llm.Usage, *JobError) {
+	sections := ps.Structure.MajorSections
+	checkpoints, err := p.StorageService.LoadSectionResults(problemID)
+	if err != nil {
+		return nil, nil, &JobError{ProblemID: problemID, Stage: "load_sections", Err: err, Transient: true}
+	}
+
+	concurrency := p.GenerationConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultGenerationConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	outcomes := make([]sectionOutcome, len(sections))
+	var wg sync.WaitGroup
+	for i, section := range sections {
+		if cp, ok := checkpoints[i]; ok && cp.StructureHash == sectionHash(ps.ExamMeta, section) {
+			log.Printf("Job %d: reusing generated section %d (%s)", problemID, i+1, section.SectionIndex)
+			usage := cp.Usage
+			outcomes[i] = sectionOutcome{generated: cp.Generated, usage: &usage}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, section models.MajorSection) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			outcomes[i] = p.generateSection(ctx, problemID, i, ps.ExamMeta, section, opts)
+		}(i, section)
+	}
+	wg.Wait()
+
+	merged := &models.GeneratedData{}
+	usage := &llm.Usage{}
+	var firstErr *JobError
+	succeeded := 0
+	for i, o := range outcomes {
+		usage.Add(o.usage)
+		if o.err != nil {
+			if firstErr == nil || (!retryable(firstErr) && retryable(o.err)) {
+				firstErr = o.err
+			}
+			log.Printf("Job %d: section %d (%s) failed: %s", problemID, i+1, sections[i].SectionIndex, o.err)
+			continue
+		}
+		if succeeded == 0 {
+			merged.ExamMeta = o.generated.ExamMeta
+		}
+		succeeded++
+		merged.Questions = append(merged.Questions, o.generated.Questions...)
+	}
+	if firstErr != nil && (succeeded == 0 || retryable(firstErr)) {
+		return nil, usage, firstErr
+	}
+	return merged, usage, nil
+}
+
+// generateSection generates the questions of a single major section and
+// checkpoints them.
+func (p *Processor) generateSection(ctx context.Context, problemID, position int, meta models.ExamMeta, section models.MajorSection, opts models.GenerationOptions) sectionOutcome {
+	if err := p.StorageService.StartSection(problemID, position, section.SectionIndex, sectionHash(meta, section)); err != nil {
+		return sectionOutcome{err: &JobError{ProblemID: problemID, Stage: "start_section", Err: err, Transient: true}}
+	}
+	sub := &models.ProblemStructure{
+		ExamMeta:  meta,
+		Structure: models.StructureSection{MajorSections: []models.MajorSection{section}},
+	}
+	generated, usage, err := p.Provider.GenerateProblem(ctx, sub, opts)
+	if err != nil {
+		jobErr := stageError(ctx, problemID, llm.StageGenerateProblem, err)
+		if ferr := p.StorageService.FailSection(problemID, position, stageFailureStatus(jobErr), jobErr.Error(), usage); ferr != nil {
+			log.Printf("Error recording failure of section %d of job %d: %v", position+1, problemID, ferr)
+		}
+		return sectionOutcome{usage: usage, err: jobErr}
+	}
+	if usage == nil {
+		usage = &llm.Usage{}
+	}
+	if err := p.StorageService.SaveSectionResult(problemID, position, generated, usage); err != nil {
+		return sectionOutcome{usage: usage, err: &JobError{ProblemID: problemID, Stage: "save_section", Err: err, Transient: true}}
+	}
+	return sectionOutcome{generated: generated, usage: usage}
+}
+
+// sectionHash identifies the input of one section's generation request, so a
+// checkpoint is not reused after the structure was edited or re-extracted.
+func sectionHash(meta models.ExamMeta, section models.MajorSection) string {
+	data, _ := json.Marshal(struct {
+		ExamMeta models.ExamMeta     `json:"exam_meta"`
+		Section  models.MajorSection `json:"section"`
+	}{meta, section})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// retryable reports whether a section failure should fail the whole job so
+// that it is retried (or stopped, for cancellations).
+func retryable(jobErr *JobError) bool {
+	return jobErr.Transient || errors.Is(jobErr.Err, ErrCancelled)
+}
@@ -0,0 +1,61 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/models"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name   string
+		jobErr *JobError
+		want   bool
+	}{
+		{name: "transient", jobErr: &JobError{Err: errors.New("503"), Transient: true}, want: true},
+		{name: "cancelled", jobErr: &JobError{Err: ErrCancelled}, want: true},
+		{name: "wrapped cancellation", jobErr: &JobError{Err: fmt.Errorf("section 2: %w", ErrCancelled)}, want: true},
+		{name: "permanent", jobErr: &JobError{Err: errors.New("invalid output")}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.jobErr); got != tt.want {
+				t.Errorf("retryable(%v) = %t, want %t", tt.jobErr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSectionHash(t *testing.T) {
+	meta := models.ExamMeta{ExamTitle: "解析学"}
+	section := models.MajorSection{SectionIndex: "1", SectionTitle: "微分", SubQuestions: []models.SubQuestion{{QuestionIndex: "1-1", Topic: "導関数"}}}
+	base := sectionHash(meta, section)
+
+	tests := []struct {
+		name   string
+		modify func(meta *models.ExamMeta, section *models.MajorSection)
+		same   bool
+	}{
+		{name: "unchanged", modify: func(*models.ExamMeta, *models.MajorSection) {}, same: true},
+		{name: "topic edited", modify: func(_ *models.ExamMeta, s *models.MajorSection) { s.SubQuestions[0].Topic = "極限" }},
+		{name: "question added", modify: func(_ *models.ExamMeta, s *models.MajorSection) {
+			s.SubQuestions = append(s.SubQuestions, models.SubQuestion{QuestionIndex: "1-2", Topic: "極限"})
+		}},
+		{name: "section title edited", modify: func(_ *models.ExamMeta, s *models.MajorSection) { s.SectionTitle = "微分法" }},
+		{name: "exam meta edited", modify: func(m *models.ExamMeta, _ *models.MajorSection) { m.OpenBook = true }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, s := meta, section
+			s.SubQuestions = append([]models.SubQuestion(nil), section.SubQuestions...)
+			tt.modify(&m, &s)
+			if got := sectionHash(m, s); (got == base) != tt.same {
+				t.Errorf("sectionHash() equal to the original = %t, want %t", got == base, tt.same)
+			}
+		})
+	}
+}
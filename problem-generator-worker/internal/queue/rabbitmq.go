@@ -4,11 +4,67 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/streadway/amqp"
 )
 
+// Header names used to track retries and dead-lettered jobs.
+const (
+	RetryCountHeader     = "x-retry-count"
+	LastErrorHeader      = "x-last-error"
+	DeadLetteredAtHeader = "x-dead-lettered-at"
+)
+
+// RetryPolicy controls how often and how late transiently failing jobs are retried.
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// RetryPolicyFromEnv reads JOB_MAX_RETRIES, JOB_RETRY_BASE_DELAY and
+// JOB_RETRY_MAX_DELAY, falling back to 5 retries starting at 10s and capped at 10m.
+func RetryPolicyFromEnv() RetryPolicy {
+	policy := RetryPolicy{MaxRetries: 5, BaseDelay: 10 * time.Second, MaxDelay: 10 * time.Minute}
+	if v, err := strconv.Atoi(os.Getenv("JOB_MAX_RETRIES")); err == nil && v >= 0 {
+		policy.MaxRetries = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("JOB_RETRY_BASE_DELAY")); err == nil && v > 0 {
+		policy.BaseDelay = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("JOB_RETRY_MAX_DELAY")); err == nil && v > 0 {
+		policy.MaxDelay = v
+	}
+	return policy
+}
+
+// Delay returns the exponential delay before the given retry attempt (1-based).
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// DeadLetterExchange returns the name of the exchange that receives jobs of
+// queueName which failed permanently.
+func DeadLetterExchange(queueName string) string { return queueName + ".dlx" }
+
+// DeadLetterQueue returns the name of the queue bound to the dead-letter exchange.
+func DeadLetterQueue(queueName string) string { return queueName + ".dead" }
+
+// retryQueue returns the name of the delay queue used for a given retry delay.
+// Each delay gets its own queue so that messages expire in order.
+func retryQueue(queueName string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%d", queueName, delay.Milliseconds())
+}
+
 type Client struct {
 	conn *amqp.Connection
 	ch   *amqp.Channel
@@ -35,6 +91,7 @@ func MustConnect() *Client {
 }
 
 // Consume starts consuming messages from the specified queue.
+// It also declares the dead-letter exchange and queue for it.
 func (c *Client) Consume(queueName string) (<-chan amqp.Delivery, error) {
 	_, err := c.ch.QueueDeclare(
 		queueName,
@@ -48,6 +105,10 @@ func (c *Client) Consume(queueName string) (<-chan amqp.Delivery, error) {
 		return nil, fmt.Errorf("failed to declare a queue: %w", err)
 	}
 
+	if err := c.declareDeadLetter(queueName); err != nil {
+		return nil, err
+	}
+
 	// Set Quality of Service to prefetch only one message at a time.
 	// This ensures that a busy worker doesn't hoard messages it can't process.
 	err = c.ch.Qos(
@@ -70,6 +131,92 @@ func (c *Client) Consume(queueName string) (<-chan amqp.Delivery, error) {
 	)
 }
 
+func (c *Client) declareDeadLetter(queueName string) error {
+	exchange := DeadLetterExchange(queueName)
+	if err := c.ch.ExchangeDeclare(exchange, "fanout", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare dead-letter exchange: %w", err)
+	}
+	dlq := DeadLetterQueue(queueName)
+	if _, err := c.ch.QueueDeclare(dlq, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare dead-letter queue: %w", err)
+	}
+	if err := c.ch.QueueBind(dlq, "", exchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind dead-letter queue: %w", err)
+	}
+	return nil
+}
+
+// RetryCount returns how many times the delivery has already been retried.
+func RetryCount(d amqp.Delivery) int {
+	switch v := d.Headers[RetryCountHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// Retry republishes the delivery to a delay queue that routes it back to
+// queueName once delay has passed. The caller must still ack the original delivery.
+func (c *Client) Retry(queueName string, d amqp.Delivery, attempt int, delay time.Duration, cause error) error {
+	name := retryQueue(queueName, delay)
+	_, err := c.ch.QueueDeclare(
+		name,
+		true,  // Durable
+		false, // Delete when unused
+		false, // Exclusive
+		false, // No-wait
+		amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queueName,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare retry queue: %w", err)
+	}
+
+	headers := copyHeaders(d.Headers)
+	headers[RetryCountHeader] = int32(attempt)
+	headers[LastErrorHeader] = cause.Error()
+	return c.ch.Publish("", name, false, false, republish(d, headers))
+}
+
+// DeadLetter publishes the delivery to the dead-letter exchange of queueName.
+// The caller must still ack the original delivery.
+func (c *Client) DeadLetter(queueName string, d amqp.Delivery, cause error) error {
+	headers := copyHeaders(d.Headers)
+	headers[RetryCountHeader] = int32(RetryCount(d))
+	headers[LastErrorHeader] = cause.Error()
+	headers[DeadLetteredAtHeader] = time.Now().UTC().Format(time.RFC3339)
+	return c.ch.Publish(DeadLetterExchange(queueName), "", false, false, republish(d, headers))
+}
+
+func republish(d amqp.Delivery, headers amqp.Table) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:       headers,
+		DeliveryMode:  amqp.Persistent,
+		ContentType:   d.ContentType,
+		CorrelationId: d.CorrelationId,
+		Body:          d.Body,
+	}
+}
+
+func copyHeaders(h amqp.Table) amqp.Table {
+	out := amqp.Table{}
+	for k, v := range h {
+		// x-death is maintained by the broker for its own dead-lettering.
+		if k == "x-death" {
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
 // Close gracefully closes the channel and connection.
 func (c *Client) Close() {
 	if c.ch != nil {
@@ -0,0 +1,307 @@
+// Package cassette records model calls to disk and replays them later, so that
+// parsing failures seen in production can be reproduced byte for byte.
+//
+// Every call is stored as one JSON file named after its key, the SHA-256 of the
+// model name, the prompt text and any binary input, followed by the number of
+// the call among those with the same key. Retries of a prompt and calls to a
+// fallback model are therefore recorded separately and replayed in order.
+// Binary inputs themselves are not stored, only their hash.
+package cassette
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/llm"
+)
+
+const (
+	ModeRecord = "record"
+	ModeReplay = "replay"
+)
+
+// manifestFile records the models of the recorded service, so that replay
+// uses the same primary and fallback models.
+const manifestFile = "service.json"
+
+// ErrNotRecorded is returned in replay mode when no entry matches the prompt.
+var ErrNotRecorded = errors.New("cassette: no recorded response for prompt")
+
+// Entry is one recorded model call.
+type Entry struct {
+	Key string `json:"key"`
+	// Sequence numbers the calls with the same key from 1, in call order.
+	Sequence  int        `json:"sequence"`
+	Model     string     `json:"model"`
+	Prompt    string     `json:"prompt"`
+	InputHash string     `json:"input_hash"`
+	Response  string     `json:"response"`
+	Usage     *llm.Usage `json:"usage,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	// Transient records whether Error was worth retrying, so that a replayed
+	// failure takes the same retry and dead-letter path as the recorded one.
+	Transient  bool      `json:"transient,omitempty"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// manifest lists the model names of a recorded service; empty names are unset.
+type manifest struct {
+	Extraction         string `json:"extraction"`
+	Generation         string `json:"generation"`
+	ExtractionFallback string `json:"extraction_fallback,omitempty"`
+	GenerationFallback string `json:"generation_fallback,omitempty"`
+}
+
+// sequence counts the calls made per key by all models of one service.
+type sequence struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (s *sequence) next(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[key]++
+	return s.calls[key]
+}
+
+// Model wraps an llm.Model and records or replays its calls.
+type Model struct {
+	mode string
+	dir  string
+	// name is the recorded model a replaying Model stands in for.
+	name  string
+	inner llm.Model
+	seq   *sequence
+}
+
+// Record wraps both models of svc so that every call is saved under dir.
+func Record(svc *llm.Service, dir string) (*llm.Service, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+	data, err := json.MarshalIndent(manifest{
+		Extraction:         modelName(svc.Extraction),
+		Generation:         modelName(svc.Generation),
+		ExtractionFallback: modelName(svc.ExtractionFallback),
+		GenerationFallback: modelName(svc.GenerationFallback),
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, manifestFile), data, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write cassette manifest: %w", err)
+	}
+	log.Printf("Recording LLM calls to cassette directory '%s'", dir)
+	seq := &sequence{calls: map[string]int{}}
+	wrap := func(inner llm.Model) llm.Model {
+		if inner == nil {
+			return nil
+		}
+		return &Model{mode: ModeRecord, dir: dir, inner: inner, seq: seq}
+	}
+	recorded := &llm.Service{
+		Extraction:         wrap(svc.Extraction),
+		Generation:         wrap(svc.Generation),
+		ExtractionFallback: wrap(svc.ExtractionFallback),
+		GenerationFallback: wrap(svc.GenerationFallback),
+		Retry:              svc.Retry,
+		Chunking:           svc.Chunking,
+	}
+	if svc.NewModel != nil {
+		recorded.NewModel = func(stage, name string) (llm.Model, error) {
+			inner, err := svc.NewModel(stage, name)
+			if err != nil {
+				return nil, err
+			}
+			return wrap(inner), nil
+		}
+	}
+	return recorded, nil
+}
+
+// NewReplayService serves every call from the cassettes under dir without
+// contacting any backend. It has the same primary and fallback models as the
+// recorded service, so retries and fallbacks happen as they did when recording.
+func NewReplayService(dir string) (*llm.Service, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("cassette directory has no readable manifest: %w", err)
+	}
+	var mf manifest
+	if err := json.Unmarshal(data, &mf); err != nil {
+		return nil, fmt.Errorf("failed to decode cassette manifest: %w", err)
+	}
+	log.Printf("Replaying LLM calls from cassette directory '%s'", dir)
+	seq := &sequence{calls: map[string]int{}}
+	replay := func(name string) llm.Model {
+		if name == "" {
+			return nil
+		}
+		return &Model{mode: ModeReplay, dir: dir, name: name, seq: seq}
+	}
+	return &llm.Service{
+		Extraction:         replay(mf.Extraction),
+		Generation:         replay(mf.Generation),
+		ExtractionFallback: replay(mf.ExtractionFallback),
+		GenerationFallback: replay(mf.GenerationFallback),
+		NewModel: func(_, name string) (llm.Model, error) {
+			return replay(name), nil
+		},
+	}, nil
+}
+
+func modelName(m llm.Model) string {
+	if m == nil {
+		return ""
+	}
+	return m.Name()
+}
+
+func (m *Model) Name() string {
+	if m.inner != nil {
+		return m.inner.Name()
+	}
+	return m.name
+}
+
+// Unbilled reports replayed calls, and recorded calls of unbilled models, so
+// that they do not count against token budgets.
+func (m *Model) Unbilled() bool {
+	return m.mode == ModeReplay || !llm.Billed(m.inner)
+}
+
+func (m *Model) GenerateText(ctx context.Context, parts ...llm.Part) (string, *llm.Usage, error) {
+	return m.generate(parts, func() (string, *llm.Usage, error) {
+		return m.inner.GenerateText(ctx, parts...)
+	})
+}
+
+// GenerateJSON forwards to the wrapped model's GenerateJSON when it has one.
+// Keys do not depend on the method, so both methods share the same cassettes.
+func (m *Model) GenerateJSON(ctx context.Context, shape any, parts ...llm.Part) (string, *llm.Usage, error) {
+	return m.generate(parts, func() (string, *llm.Usage, error) {
+		if jm, ok := m.inner.(llm.JSONModel); ok {
+			return jm.GenerateJSON(ctx, shape, parts...)
+		}
+		return m.inner.GenerateText(ctx, parts...)
+	})
+}
+
+func (m *Model) generate(parts []llm.Part, call func() (string, *llm.Usage, error)) (string, *llm.Usage, error) {
+	prompt, inputHash := describe(parts)
+	key := Key(m.Name(), prompt, inputHash)
+	n := m.seq.next(key)
+
+	if m.mode == ModeReplay {
+		return m.replay(key, n)
+	}
+
+	raw, usage, err := call()
+	entry := Entry{
+		Key:        key,
+		Sequence:   n,
+		Model:      m.inner.Name(),
+		Prompt:     prompt,
+		InputHash:  inputHash,
+		Response:   raw,
+		Usage:      usage,
+		RecordedAt: time.Now().UTC(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		entry.Transient = llm.IsTransient(err)
+	}
+	if werr := m.write(entry); werr != nil {
+		log.Printf("Warning: failed to record cassette %s: %v", key, werr)
+	}
+	return raw, usage, err
+}
+
+func (m *Model) replay(key string, n int) (string, *llm.Usage, error) {
+	name := fileName(key, n)
+	data, err := os.ReadFile(filepath.Join(m.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil, fmt.Errorf("%w (model %s, call %d, key %s)", ErrNotRecorded, m.name, n, key)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read cassette %s: %w", name, err)
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return "", nil, fmt.Errorf("failed to decode cassette %s: %w", name, err)
+	}
+
+	switch entry.Error {
+	case "":
+		return entry.Response, entry.Usage, nil
+	case llm.ErrEmptyResponse.Error():
+		return entry.Response, entry.Usage, llm.ErrEmptyResponse
+	}
+	err = errors.New(entry.Error)
+	if entry.Transient {
+		err = llm.Transient(err)
+	}
+	return entry.Response, entry.Usage, err
+}
+
+func (m *Model) write(entry Entry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so concurrent workers never read a partial cassette.
+	tmp, err := os.CreateTemp(m.dir, entry.Key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(m.dir, fileName(entry.Key, entry.Sequence)))
+}
+
+// fileName returns the cassette file of the n-th call with key.
+func fileName(key string, n int) string {
+	return fmt.Sprintf("%s-%d.json", key, n)
+}
+
+// describe returns the concatenated text parts and the hash of all non-text parts.
+func describe(parts []llm.Part) (string, string) {
+	var prompt strings.Builder
+	h := sha256.New()
+	for _, p := range parts {
+		switch v := p.(type) {
+		case llm.Text:
+			prompt.WriteString(string(v))
+			prompt.WriteString("\n")
+		case llm.Blob:
+			h.Write([]byte(v.MIMEType))
+			h.Write(v.Data)
+		}
+	}
+	return prompt.String(), hex.EncodeToString(h.Sum(nil))
+}
+
+// Key returns the cassette key for a call of model with a prompt and the hash
+// of its binary inputs.
+func Key(model, prompt, inputHash string) string {
+	sum := sha256.Sum256([]byte(model + "\x00" + prompt + "\x00" + inputHash))
+	return hex.EncodeToString(sum[:])
+}
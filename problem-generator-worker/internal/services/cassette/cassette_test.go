@@ -0,0 +1,162 @@
+package cassette
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/models"
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/llm"
+)
+
+const structureJSON = `{"exam_meta": {"exam_title": "解析学"}, "structure": {"major_sections": [` +
+	`{"section_title": "微分", "sub_questions": [{"question_index": "1-1", "topic": "導関数"}]}]}}`
+
+// scriptedModel answers each call with the next response of its script.
+type scriptedModel struct {
+	name      string
+	responses []scriptedResponse
+	calls     int
+}
+
+type scriptedResponse struct {
+	raw string
+	err error
+}
+
+func (m *scriptedModel) Name() string { return m.name }
+
+func (m *scriptedModel) GenerateText(ctx context.Context, parts ...llm.Part) (string, *llm.Usage, error) {
+	if m.calls >= len(m.responses) {
+		return "", nil, errors.New("scripted model: no more responses")
+	}
+	r := m.responses[m.calls]
+	m.calls++
+	return r.raw, &llm.Usage{TotalTokenCount: 1}, r.err
+}
+
+// extract runs a structure extraction and returns the attempts it made as
+// "model:outcome" pairs.
+func extract(t *testing.T, svc *llm.Service) ([]string, error) {
+	t.Helper()
+	svc.Retry = llm.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Microsecond, MaxDelay: time.Microsecond}
+	var attempts []string
+	ctx := llm.WithTrace(context.Background(), &llm.Trace{OnAttempt: func(a llm.Attempt) {
+		attempts = append(attempts, a.Model+":"+a.Outcome)
+	}})
+	_, _, err := svc.ExtractStructure(ctx, models.GenerationOptions{}, llm.Text("講義ノート"))
+	return attempts, err
+}
+
+func TestReplayReproducesRetriesAndFallbacks(t *testing.T) {
+	transient := scriptedResponse{err: llm.Transient(errors.New("503 unavailable"))}
+	valid := scriptedResponse{raw: structureJSON}
+
+	tests := []struct {
+		name     string
+		primary  []scriptedResponse
+		fallback []scriptedResponse
+		wantErr  bool
+		want     []string
+	}{
+		{
+			name:    "transient error, then success",
+			primary: []scriptedResponse{transient, valid},
+			want:    []string{"primary:" + llm.OutcomeTransientError, "primary:" + llm.OutcomeSuccess},
+		},
+		{
+			name:     "fallback after the attempts run out",
+			primary:  []scriptedResponse{transient, transient, transient},
+			fallback: []scriptedResponse{valid},
+			want: []string{
+				"primary:" + llm.OutcomeTransientError, "primary:" + llm.OutcomeTransientError,
+				"primary:" + llm.OutcomeTransientError, "fallback:" + llm.OutcomeSuccess,
+			},
+		},
+		{
+			name:     "fallback after a permanent error",
+			primary:  []scriptedResponse{{err: errors.New("400 bad request")}},
+			fallback: []scriptedResponse{transient, valid},
+			want: []string{
+				"primary:" + llm.OutcomeError, "fallback:" + llm.OutcomeTransientError, "fallback:" + llm.OutcomeSuccess,
+			},
+		},
+		{
+			name:     "every model fails",
+			primary:  []scriptedResponse{{err: errors.New("400 bad request")}},
+			fallback: []scriptedResponse{{err: errors.New("400 bad request")}},
+			wantErr:  true,
+			want:     []string{"primary:" + llm.OutcomeError, "fallback:" + llm.OutcomeError},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			backend := &llm.Service{
+				Extraction: &scriptedModel{name: "primary", responses: tt.primary},
+				Generation: &scriptedModel{name: "primary"},
+			}
+			if tt.fallback != nil {
+				backend.ExtractionFallback = &scriptedModel{name: "fallback", responses: tt.fallback}
+			}
+
+			recorder, err := Record(backend, dir)
+			if err != nil {
+				t.Fatalf("Record() error = %v", err)
+			}
+			recorded, err := extract(t, recorder)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("recorded run error = %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(recorded, tt.want) {
+				t.Fatalf("recorded attempts = %v, want %v", recorded, tt.want)
+			}
+
+			replayer, err := NewReplayService(dir)
+			if err != nil {
+				t.Fatalf("NewReplayService() error = %v", err)
+			}
+			replayed, err := extract(t, replayer)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("replayed run error = %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(replayed, recorded) {
+				t.Errorf("replayed attempts = %v, want the recorded %v", replayed, recorded)
+			}
+		})
+	}
+}
+
+func TestReplayFailsBeyondTheRecording(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := Record(&llm.Service{Extraction: &scriptedModel{name: "m"}, Generation: &scriptedModel{name: "m"}}, dir)
+	if err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if _, _, err := recorder.Extraction.GenerateText(context.Background(), llm.Text("a")); err == nil {
+		t.Fatal("scripted model without responses did not fail")
+	}
+
+	replayer, err := NewReplayService(dir)
+	if err != nil {
+		t.Fatalf("NewReplayService() error = %v", err)
+	}
+	if _, _, err := replayer.Extraction.GenerateText(context.Background(), llm.Text("a")); err == nil || errors.Is(err, ErrNotRecorded) {
+		t.Fatalf("first replayed call error = %v, want the recorded error", err)
+	}
+	if _, _, err := replayer.Extraction.GenerateText(context.Background(), llm.Text("a")); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("second replayed call error = %v, want %v", err, ErrNotRecorded)
+	}
+	if _, _, err := replayer.Extraction.GenerateText(context.Background(), llm.Text("b")); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("unrecorded prompt error = %v, want %v", err, ErrNotRecorded)
+	}
+}
+
+func TestNewReplayServiceRequiresManifest(t *testing.T) {
+	if _, err := NewReplayService(t.TempDir()); err == nil {
+		t.Fatal("NewReplayService() error = nil, want an error for a directory without a manifest")
+	}
+}
@@ -0,0 +1,312 @@
+// Package fake provides a deterministic, network-free LLM backend for running the
+// whole pipeline (gateway, queue, worker, Postgres) in CI-like conditions.
+//
+// The behaviour of each stage is chosen by FAKE_LLM_EXTRACTION_MODE and
+// FAKE_LLM_GENERATION_MODE, and can be overridden per job by putting a directive
+// such as "fake:extract=malformed" or "fake:generate=error" in the input text.
+package fake
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/models"
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/llm"
+)
+
+// Mode selects the canned behaviour of a fake model.
+type Mode string
+
+const (
+	// ModeOK returns the fixture (or, for generation, questions derived from the structure).
+	ModeOK Mode = "ok"
+	// ModeMalformed returns text that is not valid JSON even after sanitization.
+	ModeMalformed Mode = "malformed"
+	// ModeEmpty behaves like a response without candidates.
+	ModeEmpty Mode = "empty"
+	// ModeError fails the request as if the backend returned an error.
+	ModeError Mode = "error"
+	// ModeUnavailable fails the request with a transient error, like a 503 from the backend.
+	ModeUnavailable Mode = "unavailable"
+	// ModePartial generates questions for all but the last sub-question plus one
+	// question that is not in the structure. Single-question requests behave as in ModeOK.
+	ModePartial Mode = "partial"
+)
+
+const (
+	stageExtract  = "extract"
+	stageGenerate = "generate"
+)
+
+var (
+	// ErrInjected is returned by models running in ModeError.
+	ErrInjected = errors.New("fake LLM: injected error")
+	// ErrUnavailable is returned, marked as transient, by models running in ModeUnavailable.
+	ErrUnavailable = errors.New("fake LLM: service unavailable")
+)
+
+//go:embed fixtures/*.json
+var defaultFixtures embed.FS
+
+var directiveRegex = regexp.MustCompile(`fake:(extract|generate)=(ok|malformed|empty|error|unavailable|partial)`)
+
+// Model is a fake llm.Model for a single stage.
+type Model struct {
+	name    string
+	stage   string
+	mode    Mode
+	fixture string
+	delay   time.Duration
+}
+
+// NewService builds an llm.Service whose models never leave the process.
+// FAKE_LLM_FIXTURE_DIR may point to a directory containing structure.json
+// and/or generation.json to replace the built-in fixtures, and FAKE_LLM_DELAY
+// (a Go duration) adds latency to every call.
+func NewService() (*llm.Service, error) {
+	var delay time.Duration
+	if v := os.Getenv("FAKE_LLM_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FAKE_LLM_DELAY '%s': %w", v, err)
+		}
+		delay = d
+	}
+
+	extraction, err := newModel(stageExtract, "FAKE_LLM_EXTRACTION_MODE", "structure.json", delay)
+	if err != nil {
+		return nil, err
+	}
+	generation, err := newModel(stageGenerate, "FAKE_LLM_GENERATION_MODE", "generation.json", delay)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Fake LLM service initialized (extraction mode: %s, generation mode: %s)", extraction.mode, generation.mode)
+	return &llm.Service{
+		Extraction: extraction,
+		Generation: generation,
+		// Any model name is accepted; it only shows up in the recorded attempts.
+		NewModel: func(stage, name string) (llm.Model, error) {
+			m := *generation
+			if stage == llm.StageExtractStructure {
+				m = *extraction
+			}
+			m.name = name
+			return &m, nil
+		},
+	}, nil
+}
+
+func newModel(stage, modeEnv, fixtureName string, delay time.Duration) (*Model, error) {
+	mode := Mode(os.Getenv(modeEnv))
+	switch mode {
+	case "":
+		mode = ModeOK
+	case ModeOK, ModeMalformed, ModeEmpty, ModeError, ModeUnavailable, ModePartial:
+	default:
+		return nil, fmt.Errorf("invalid %s '%s'", modeEnv, mode)
+	}
+
+	fixture, err := loadFixture(fixtureName)
+	if err != nil {
+		return nil, err
+	}
+	return &Model{stage: stage, mode: mode, fixture: fixture, delay: delay}, nil
+}
+
+// loadFixture reads a fixture from FAKE_LLM_FIXTURE_DIR, falling back to the embedded one.
+// A missing generation fixture is not an error: generation output is then derived from the structure.
+func loadFixture(name string) (string, error) {
+	if dir := os.Getenv("FAKE_LLM_FIXTURE_DIR"); dir != "" {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			return string(data), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to read fake fixture %s: %w", name, err)
+		}
+	}
+	data, err := defaultFixtures.ReadFile("fixtures/" + name)
+	if err != nil {
+		return "", nil
+	}
+	return string(data), nil
+}
+
+func (m *Model) Name() string {
+	if m.name != "" {
+		return m.name
+	}
+	return "fake-" + m.stage
+}
+
+// Unbilled reports that fake calls never count against token budgets.
+func (m *Model) Unbilled() bool { return true }
+
+// GenerateText returns a canned response according to the model mode and any
+// directive found in the prompt.
+func (m *Model) GenerateText(ctx context.Context, parts ...llm.Part) (string, *llm.Usage, error) {
+	if m.delay > 0 {
+		select {
+		case <-time.After(m.delay):
+		case <-ctx.Done():
+			return "", nil, ctx.Err()
+		}
+	}
+
+	prompt := promptText(parts)
+	mode := m.mode
+	for _, d := range directiveRegex.FindAllStringSubmatch(prompt, -1) {
+		if d[1] == m.stage {
+			mode = Mode(d[2])
+		}
+	}
+
+	var raw string
+	switch mode {
+	case ModeError:
+		return "", nil, ErrInjected
+	case ModeUnavailable:
+		return "", nil, llm.Transient(ErrUnavailable)
+	case ModeEmpty:
+		return "", usageFor(prompt, ""), llm.ErrEmptyResponse
+	case ModeMalformed:
+		raw = `{"exam_meta": {"exam_title": "malformed \q output", "questions": [`
+	default:
+		var err error
+		raw, err = m.okResponse(prompt, mode == ModePartial)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return raw, usageFor(prompt, raw), nil
+}
+
+func (m *Model) okResponse(prompt string, partial bool) (string, error) {
+	if m.stage == stageExtract {
+		return withGenerateDirectives(m.fixture, prompt)
+	}
+	if m.fixture != "" {
+		return m.fixture, nil
+	}
+	return generateFromPrompt(prompt, partial)
+}
+
+// withGenerateDirectives carries "fake:generate=..." directives from the input
+// into the extracted exam title so that the generation stage can see them.
+func withGenerateDirectives(fixture, prompt string) (string, error) {
+	var directives []string
+	for _, d := range directiveRegex.FindAllString(prompt, -1) {
+		if strings.HasPrefix(d, "fake:"+stageGenerate) {
+			directives = append(directives, d)
+		}
+	}
+	if len(directives) == 0 {
+		return fixture, nil
+	}
+
+	var ps models.ProblemStructure
+	if err := json.Unmarshal([]byte(fixture), &ps); err != nil {
+		return "", fmt.Errorf("fake structure fixture is not valid: %w", err)
+	}
+	ps.ExamMeta.ExamTitle += " [" + strings.Join(directives, " ") + "]"
+	out, err := json.Marshal(ps)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// generateFromPrompt finds the problem structure embedded in the generation
+// prompt and answers it with one deterministic question per sub-question.
+// A regeneration prompt, which embeds a single sub_question, is answered with
+// that one question. partial leaves out the last question and adds one that
+// does not belong to the structure.
+func generateFromPrompt(prompt string, partial bool) (string, error) {
+	start := strings.LastIndex(prompt, "\n{\n")
+	if start < 0 {
+		return "", fmt.Errorf("fake LLM: no problem structure found in generation prompt")
+	}
+	var doc struct {
+		models.ProblemStructure
+		SubQuestion *models.SubQuestion `json:"sub_question"`
+	}
+	if err := json.NewDecoder(strings.NewReader(prompt[start:])).Decode(&doc); err != nil {
+		return "", fmt.Errorf("fake LLM: could not decode problem structure from prompt: %w", err)
+	}
+	if doc.SubQuestion != nil {
+		out, err := json.Marshal(fakeQuestion(*doc.SubQuestion, "別の"))
+		if err != nil {
+			return "", err
+		}
+		return string(out), nil
+	}
+	ps := doc.ProblemStructure
+
+	gd := models.GeneratedData{
+		ExamMeta: models.GeneratedExamMeta{
+			ExamTitle:             ps.ExamMeta.ExamTitle,
+			OpenBook:              ps.ExamMeta.OpenBook,
+			QuestionFormatIsLatex: ps.ExamMeta.QuestionFormatIsLatex,
+			AnswerFormatIsLatex:   ps.ExamMeta.AnswerFormatIsLatex,
+		},
+	}
+	for _, section := range ps.Structure.MajorSections {
+		for _, sq := range section.SubQuestions {
+			gd.Questions = append(gd.Questions, fakeQuestion(sq, ""))
+		}
+	}
+	if partial && len(gd.Questions) > 0 {
+		gd.Questions = append(gd.Questions[:len(gd.Questions)-1],
+			fakeQuestion(models.SubQuestion{QuestionIndex: "99-1", Topic: "構造にないトピック"}, "余分な"))
+	}
+	out, err := json.Marshal(gd)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func fakeQuestion(sq models.SubQuestion, variant string) models.GeneratedQuestion {
+	difficulty := sq.Difficulty
+	if difficulty == "" {
+		difficulty = models.DifficultyMedium
+	}
+	return models.GeneratedQuestion{
+		QuestionIndex: sq.QuestionIndex,
+		Topic:         sq.Topic,
+		Keywords:      sq.Keywords,
+		Difficulty:    difficulty,
+		QuestionText:  fmt.Sprintf("「%s」に関する%s問題です。$x^2 + 1 = 0$ を解きなさい。", sq.Topic, variant),
+		AnswerText:    "$x = \\pm i$",
+	}
+}
+
+func promptText(parts []llm.Part) string {
+	var sb strings.Builder
+	for _, p := range parts {
+		if t, ok := p.(llm.Text); ok {
+			sb.WriteString(string(t))
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// usageFor approximates token counts as one token per four characters.
+func usageFor(prompt, response string) *llm.Usage {
+	p := int32(utf8.RuneCountInString(prompt) / 4)
+	c := int32(utf8.RuneCountInString(response) / 4)
+	return &llm.Usage{PromptTokenCount: p, CandidatesTokenCount: c, TotalTokenCount: p + c}
+}
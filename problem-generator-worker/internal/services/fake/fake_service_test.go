@@ -0,0 +1,181 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/models"
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/llm"
+)
+
+// newTestService builds a fake service with the given modes and a retry policy
+// that does not slow the tests down.
+func newTestService(t *testing.T, extraction, generation Mode) *llm.Service {
+	t.Helper()
+	t.Setenv("FAKE_LLM_EXTRACTION_MODE", string(extraction))
+	t.Setenv("FAKE_LLM_GENERATION_MODE", string(generation))
+	svc, err := NewService()
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+	svc.Retry = llm.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	return svc
+}
+
+func questionIndices(gd *models.GeneratedData) []string {
+	var indices []string
+	for _, q := range gd.Questions {
+		indices = append(indices, q.QuestionIndex)
+	}
+	return indices
+}
+
+func TestNewServiceRejectsUnknownModes(t *testing.T) {
+	t.Setenv("FAKE_LLM_EXTRACTION_MODE", "sometimes")
+	if _, err := NewService(); err == nil {
+		t.Fatal("NewService() error = nil, want an error for an unknown mode")
+	}
+}
+
+func TestExtractStructure(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      Mode
+		input     string
+		wantErr   error
+		transient bool
+		// anyErr accepts any error, for failures that have no sentinel.
+		anyErr bool
+	}{
+		{name: "ok returns the fixture", mode: ModeOK, input: "講義ノート"},
+		{name: "error", mode: ModeError, input: "講義ノート", wantErr: ErrInjected},
+		{name: "unavailable is transient", mode: ModeUnavailable, input: "講義ノート", wantErr: ErrUnavailable, transient: true},
+		{name: "empty", mode: ModeEmpty, input: "講義ノート", wantErr: llm.ErrEmptyResponse},
+		{name: "malformed output is rejected", mode: ModeMalformed, input: "講義ノート", anyErr: true},
+		{name: "directive overrides the mode", mode: ModeOK, input: "講義ノート fake:extract=error", wantErr: ErrInjected},
+		{name: "directive for the other stage is ignored", mode: ModeOK, input: "講義ノート fake:generate=error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(t, tt.mode, ModeOK)
+			ps, _, err := svc.ExtractStructure(context.Background(), models.GenerationOptions{}, llm.Text(tt.input))
+			switch {
+			case tt.anyErr:
+				if err == nil {
+					t.Fatal("ExtractStructure() error = nil, want an error")
+				}
+				return
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ExtractStructure() error = %v, want %v", err, tt.wantErr)
+				}
+				if llm.IsTransient(err) != tt.transient {
+					t.Errorf("IsTransient(%v) = %t, want %t", err, !tt.transient, tt.transient)
+				}
+				return
+			case err != nil:
+				t.Fatalf("ExtractStructure() error = %v", err)
+			}
+			if ps.ExamMeta.ExamTitle == "" || len(ps.Structure.MajorSections) != 2 {
+				t.Errorf("ExtractStructure() = %+v, want the fixture structure", ps)
+			}
+		})
+	}
+}
+
+func TestGenerateProblem(t *testing.T) {
+	svc := newTestService(t, ModeOK, ModeOK)
+	ps, _, err := svc.ExtractStructure(context.Background(), models.GenerationOptions{}, llm.Text("講義ノート"))
+	if err != nil {
+		t.Fatalf("ExtractStructure() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		mode    Mode
+		want    []string
+		wantErr error
+	}{
+		{name: "one question per sub-question", mode: ModeOK, want: []string{"1-1", "1-2", "2-1", "2-2"}},
+		{name: "partial drops the last question and adds one", mode: ModePartial, want: []string{"1-1", "1-2", "2-1", "99-1"}},
+		{name: "error", mode: ModeError, wantErr: ErrInjected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(t, ModeOK, tt.mode)
+			gd, _, err := svc.GenerateProblem(context.Background(), ps, models.GenerationOptions{})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("GenerateProblem() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateProblem() error = %v", err)
+			}
+			if got := questionIndices(gd); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("question indices = %v, want %v", got, tt.want)
+			}
+			if gd.ExamMeta.ExamTitle != ps.ExamMeta.ExamTitle {
+				t.Errorf("exam title = %q, want %q", gd.ExamMeta.ExamTitle, ps.ExamMeta.ExamTitle)
+			}
+		})
+	}
+}
+
+func TestGenerateDirectiveIsCarriedThroughExtraction(t *testing.T) {
+	svc := newTestService(t, ModeOK, ModeOK)
+	ps, _, err := svc.ExtractStructure(context.Background(), models.GenerationOptions{}, llm.Text("講義ノート fake:generate=empty"))
+	if err != nil {
+		t.Fatalf("ExtractStructure() error = %v", err)
+	}
+	if _, _, err := svc.GenerateProblem(context.Background(), ps, models.GenerationOptions{}); !errors.Is(err, llm.ErrEmptyResponse) {
+		t.Fatalf("GenerateProblem() error = %v, want %v", err, llm.ErrEmptyResponse)
+	}
+}
+
+func TestFixtureDirectory(t *testing.T) {
+	dir := t.TempDir()
+	fixture := `{"exam_meta": {"exam_title": "差し替えた試験"}, "structure": {"major_sections": [` +
+		`{"section_title": "唯一の大問", "sub_questions": [{"question_index": "1-1", "topic": "確率"}]}]}}`
+	if err := os.WriteFile(filepath.Join(dir, "structure.json"), []byte(fixture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAKE_LLM_FIXTURE_DIR", dir)
+
+	svc := newTestService(t, ModeOK, ModeOK)
+	ps, _, err := svc.ExtractStructure(context.Background(), models.GenerationOptions{}, llm.Text("講義ノート"))
+	if err != nil {
+		t.Fatalf("ExtractStructure() error = %v", err)
+	}
+	if ps.ExamMeta.ExamTitle != "差し替えた試験" {
+		t.Errorf("exam title = %q, want the fixture from FAKE_LLM_FIXTURE_DIR", ps.ExamMeta.ExamTitle)
+	}
+	// Without generation.json in the directory, questions are still derived from the structure.
+	gd, _, err := svc.GenerateProblem(context.Background(), ps, models.GenerationOptions{})
+	if err != nil {
+		t.Fatalf("GenerateProblem() error = %v", err)
+	}
+	if got := questionIndices(gd); !reflect.DeepEqual(got, []string{"1-1"}) {
+		t.Errorf("question indices = %v, want [1-1]", got)
+	}
+}
+
+func TestRegenerateQuestion(t *testing.T) {
+	svc := newTestService(t, ModeOK, ModeOK)
+	req := llm.QuestionRequest{SubQuestion: models.SubQuestion{QuestionIndex: "2-1", Topic: "固有値", Difficulty: models.DifficultyHard}}
+	q, _, err := svc.RegenerateQuestion(context.Background(), req, models.GenerationOptions{})
+	if err != nil {
+		t.Fatalf("RegenerateQuestion() error = %v", err)
+	}
+	if q.QuestionIndex != "2-1" || q.Topic != "固有値" || q.Difficulty != models.DifficultyHard {
+		t.Errorf("RegenerateQuestion() = %+v, want a question for sub-question 2-1", q)
+	}
+}
@@ -0,0 +1,103 @@
+package gemini
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// schemaCache holds the response schema derived for each output type.
+var schemaCache sync.Map // reflect.Type -> *genai.Schema
+
+// schemaFor returns the Gemini response schema for the JSON encoding of shape.
+// Fields without omitempty are required, pointers are nullable and string
+// fields tagged `enum:"a,b,c"` are restricted to those values.
+func schemaFor(shape any) (*genai.Schema, error) {
+	t := reflect.TypeOf(shape)
+	if t == nil {
+		return nil, fmt.Errorf("no response shape given")
+	}
+	if cached, ok := schemaCache.Load(t); ok {
+		return cached.(*genai.Schema), nil
+	}
+	schema, err := schemaOf(t)
+	if err != nil {
+		return nil, fmt.Errorf("cannot derive response schema for %s: %w", t, err)
+	}
+	schemaCache.Store(t, schema)
+	return schema, nil
+}
+
+func schemaOf(t reflect.Type) (*genai.Schema, error) {
+	switch t.Kind() {
+	case reflect.Pointer:
+		s, err := schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		nullable := *s
+		nullable.Nullable = true
+		return &nullable, nil
+	case reflect.String:
+		return &genai.Schema{Type: genai.TypeString}, nil
+	case reflect.Bool:
+		return &genai.Schema{Type: genai.TypeBoolean}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &genai.Schema{Type: genai.TypeInteger}, nil
+	case reflect.Float32, reflect.Float64:
+		return &genai.Schema{Type: genai.TypeNumber}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &genai.Schema{Type: genai.TypeArray, Items: items}, nil
+	case reflect.Struct:
+		s := &genai.Schema{Type: genai.TypeObject, Properties: map[string]*genai.Schema{}}
+		if err := addFields(s, t); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unsupported kind %s", t.Kind())
+}
+
+// addFields adds the JSON properties of struct type t to s, flattening
+// embedded structs the way encoding/json does.
+func addFields(s *genai.Schema, t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			if err := addFields(s, f.Type); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop, err := schemaOf(f.Type)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", f.Name, err)
+		}
+		if enum := f.Tag.Get("enum"); enum != "" && prop.Type == genai.TypeString {
+			prop.Format = "enum"
+			prop.Enum = strings.Split(enum, ",")
+		}
+		s.Properties[name] = prop
+		if !strings.Contains(","+opts+",", ",omitempty,") && f.Type.Kind() != reflect.Pointer {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package gemini
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+type schemaInner struct {
+	Score float64 `json:"score"`
+}
+
+type schemaEmbedded struct {
+	Shared string `json:"shared"`
+}
+
+type schemaShape struct {
+	schemaEmbedded
+	Name     string        `json:"name"`
+	Count    int           `json:"count,omitempty"`
+	Level    string        `json:"level" enum:"easy,medium,hard"`
+	Flag     bool          `json:"flag"`
+	Optional *int          `json:"optional"`
+	Items    []schemaInner `json:"items"`
+	Untagged string
+	Skipped  string `json:"-"`
+	private  string
+}
+
+func TestSchemaFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		shape   any
+		want    *genai.Schema
+		wantErr bool
+	}{
+		{name: "string", shape: "", want: &genai.Schema{Type: genai.TypeString}},
+		{name: "unsigned integer", shape: uint8(0), want: &genai.Schema{Type: genai.TypeInteger}},
+		{name: "pointer is nullable", shape: new(bool), want: &genai.Schema{Type: genai.TypeBoolean, Nullable: true}},
+		{
+			name:  "array",
+			shape: [2]float32{},
+			want:  &genai.Schema{Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeNumber}},
+		},
+		{
+			name:  "struct",
+			shape: schemaShape{},
+			want: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"shared":   {Type: genai.TypeString},
+					"name":     {Type: genai.TypeString},
+					"count":    {Type: genai.TypeInteger},
+					"level":    {Type: genai.TypeString, Format: "enum", Enum: []string{"easy", "medium", "hard"}},
+					"flag":     {Type: genai.TypeBoolean},
+					"optional": {Type: genai.TypeInteger, Nullable: true},
+					"items": {Type: genai.TypeArray, Items: &genai.Schema{
+						Type:       genai.TypeObject,
+						Properties: map[string]*genai.Schema{"score": {Type: genai.TypeNumber}},
+						Required:   []string{"score"},
+					}},
+					"Untagged": {Type: genai.TypeString},
+				},
+				Required: []string{"shared", "name", "level", "flag", "items", "Untagged"},
+			},
+		},
+		{name: "no shape", shape: nil, wantErr: true},
+		{name: "maps are unsupported", shape: map[string]int{}, wantErr: true},
+		{name: "unsupported field", shape: struct {
+			Callback func() `json:"callback"`
+		}{}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := schemaFor(tt.shape)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("schemaFor(%T) = %+v, want an error", tt.shape, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("schemaFor(%T) error = %v", tt.shape, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("schemaFor(%T) = %+v, want %+v", tt.shape, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemaForCachesByType(t *testing.T) {
+	first, err := schemaFor(schemaInner{})
+	if err != nil {
+		t.Fatalf("schemaFor() error = %v", err)
+	}
+	second, err := schemaFor(schemaInner{Score: 1})
+	if err != nil {
+		t.Fatalf("schemaFor() error = %v", err)
+	}
+	if first != second {
+		t.Error("schemaFor() derived the schema of the same type twice")
+	}
+}
@@ -0,0 +1,206 @@
+package llm
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ChunkPolicy controls how long inputs are split for structure extraction.
+// Inputs up to MaxChars characters are sent in one request; longer ones are
+// split at source and page markers and headings into chunks of at most
+// MaxChars characters, extracted separately (up to Concurrency at once) and
+// merged.
+type ChunkPolicy struct {
+	MaxChars    int
+	Concurrency int
+}
+
+// DefaultChunkPolicy is used when a Service has no explicit chunk policy.
+var DefaultChunkPolicy = ChunkPolicy{MaxChars: 120000, Concurrency: 3}
+
+// ChunkPolicyFromEnv reads EXTRACTION_CHUNK_CHARS and EXTRACTION_CHUNK_CONCURRENCY.
+func ChunkPolicyFromEnv() ChunkPolicy {
+	policy := DefaultChunkPolicy
+	if v, err := strconv.Atoi(os.Getenv("EXTRACTION_CHUNK_CHARS")); err == nil && v > 0 {
+		policy.MaxChars = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("EXTRACTION_CHUNK_CONCURRENCY")); err == nil && v > 0 {
+		policy.Concurrency = v
+	}
+	return policy
+}
+
+// PageMarker returns the line that marks the start of page n in text
+// extracted from a paged document. Chunks are preferably split before it.
+func PageMarker(n int) string {
+	return fmt.Sprintf("--- ページ %d ---", n)
+}
+
+// SlideMarker returns the line that marks the start of slide n in text
+// extracted from a presentation. Chunks are preferably split before it.
+func SlideMarker(n int) string {
+	return fmt.Sprintf("--- スライド %d ---", n)
+}
+
+// boundaryRegex matches lines that start a new source, page or section: source,
+// page and slide markers, form feeds, Markdown headings up to level 3 and LaTeX
+// sectioning commands.
+var boundaryRegex = regexp.MustCompile(`(?m)^(?:=== 資料 \d+/\d+: .* ===$|--- (?:ページ|スライド) \d+ ---$|\f|#{1,3} |\\(?:chapter|section|subsection)\*?\{)`)
+
+// blobChars is the number of characters a binary part, typically one scanned
+// page, counts as when an input is split into chunks.
+const blobChars = 2000
+
+// splitInput splits the input into chunks of at most maxChars characters, each
+// sent as its own list of parts. Binary parts count as blobChars characters and
+// stay with the text before them, so a scanned page goes with its page marker.
+// It returns nil when the input fits into one request. A chunk that starts
+// inside a source file repeats the marker line of that source, so the model
+// still knows its role.
+func splitInput(parts []Part, maxChars int) [][]Part {
+	var sb strings.Builder
+	var blobs []Blob
+	var blobAt []int // offset in text of the end of the text before each blob
+	for _, p := range parts {
+		switch v := p.(type) {
+		case Text:
+			sb.WriteString(string(v))
+		case Blob:
+			blobs = append(blobs, v)
+			blobAt = append(blobAt, sb.Len())
+		}
+	}
+	text := sb.String()
+	if maxChars <= 0 || utf8.RuneCountInString(text)+len(blobs)*blobChars <= maxChars {
+		return nil
+	}
+
+	// Leave room for a repeated source marker in every chunk, unless the
+	// markers are so long that the chunks would hardly hold any text.
+	reserve := 0
+	for _, line := range strings.Split(text, "\n") {
+		if n := utf8.RuneCountInString(line) + 1; strings.HasPrefix(line, sourceMarkerPrefix) && n > reserve {
+			reserve = n
+		}
+	}
+	if reserve > maxChars/2 {
+		reserve = 0
+	}
+
+	var chunks [][]Part
+	var current []Part
+	var pending strings.Builder // text of the current chunk not yet in a part
+	currentLen := 0
+	hasBlob := false
+	marker := "" // marker line of the source the text so far belongs to
+	addText := func() {
+		if pending.Len() > 0 {
+			current = append(current, Text(pending.String()))
+			pending.Reset()
+		}
+	}
+	flush := func() {
+		addText()
+		if hasBlob || strings.TrimSpace(textOf(current)) != "" {
+			chunks = append(chunks, current)
+		}
+		current = nil
+		currentLen = 0
+		hasBlob = false
+	}
+	// Cut the text at every binary part as well, so that each part ends a
+	// segment and can start a new chunk.
+	var segments []string
+	prev := 0
+	for _, at := range append(blobAt, len(text)) {
+		if at > prev || at == len(text) {
+			segments = append(segments, splitSegments(text[prev:at], maxChars-reserve)...)
+			prev = at
+		}
+	}
+
+	next, offset := 0, 0
+	for _, segment := range segments {
+		start, end := offset, offset+len(segment)
+		offset = end
+		first := next
+		for next < len(blobs) && blobAt[next] <= end {
+			next++
+		}
+
+		n := utf8.RuneCountInString(segment) + (next-first)*blobChars
+		if currentLen > 0 && currentLen+n > maxChars {
+			flush()
+		}
+		startsSource := strings.HasPrefix(segment, sourceMarkerPrefix)
+		if currentLen == 0 && marker != "" && !startsSource {
+			pending.WriteString(marker + "\n")
+			currentLen += utf8.RuneCountInString(marker) + 1
+		}
+		if startsSource {
+			marker, _, _ = strings.Cut(segment, "\n")
+		}
+		pos := start
+		for i := first; i < next; i++ {
+			pending.WriteString(text[pos:blobAt[i]])
+			pos = blobAt[i]
+			addText()
+			current = append(current, blobs[i])
+			hasBlob = true
+		}
+		pending.WriteString(text[pos:end])
+		currentLen += n
+	}
+	flush()
+	return chunks
+}
+
+// textOf returns the concatenated text parts of parts.
+func textOf(parts []Part) string {
+	var sb strings.Builder
+	for _, p := range parts {
+		if t, ok := p.(Text); ok {
+			sb.WriteString(string(t))
+		}
+	}
+	return sb.String()
+}
+
+// splitSegments cuts text before every boundary line. Segments that are still
+// longer than maxChars are cut at paragraph breaks and, as a last resort, at
+// maxChars characters.
+func splitSegments(text string, maxChars int) []string {
+	var segments []string
+	starts := boundaryRegex.FindAllStringIndex(text, -1)
+	prev := 0
+	for _, loc := range starts {
+		if loc[0] > prev {
+			segments = append(segments, text[prev:loc[0]])
+			prev = loc[0]
+		}
+	}
+	segments = append(segments, text[prev:])
+
+	var out []string
+	for _, segment := range segments {
+		if utf8.RuneCountInString(segment) <= maxChars {
+			out = append(out, segment)
+			continue
+		}
+		for _, para := range strings.SplitAfter(segment, "\n\n") {
+			for utf8.RuneCountInString(para) > maxChars {
+				cut := len(string([]rune(para)[:maxChars]))
+				out = append(out, para[:cut])
+				para = para[cut:]
+			}
+			if para != "" {
+				out = append(out, para)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,164 @@
+package llm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// chunkChars returns the size of a chunk as splitInput counts it.
+func chunkChars(chunk []Part) int {
+	n := utf8.RuneCountInString(textOf(chunk))
+	for _, p := range chunk {
+		if _, ok := p.(Blob); ok {
+			n += blobChars
+		}
+	}
+	return n
+}
+
+// texts turns a list of strings into text-only chunks.
+func texts(chunks ...string) [][]Part {
+	parts := make([][]Part, len(chunks))
+	for i, c := range chunks {
+		parts[i] = []Part{Text(c)}
+	}
+	return parts
+}
+
+func TestSplitInput(t *testing.T) {
+	pages := PageMarker(1) + "\n" + strings.Repeat("あ", 40) + "\n" + PageMarker(2) + "\n" + strings.Repeat("い", 40) + "\n"
+	longParagraphs := strings.Repeat("a", 30) + "\n\n" + strings.Repeat("b", 30) + "\n\n" + strings.Repeat("c", 30)
+	page1 := Blob{MIMEType: "application/pdf", Data: []byte("%PDF page 1")}
+	page2 := Blob{MIMEType: "application/pdf", Data: []byte("%PDF page 2")}
+	notes := SourceMarker(1, 2, SourceLectureNotes, "notes.pdf")
+	exam := SourceMarker(2, 2, SourceSampleExam, "")
+
+	tests := []struct {
+		name     string
+		parts    []Part
+		maxChars int
+		want     [][]Part
+	}{
+		{
+			name:     "short input is not split",
+			parts:    []Part{Text("short")},
+			maxChars: 100,
+		},
+		{
+			name:     "binary parts count towards the size",
+			parts:    []Part{Text("short\n\n"), page1, Text("text"), page2},
+			maxChars: blobChars + 10,
+			want:     [][]Part{{Text("short\n\n"), page1}, {Text("text"), page2}},
+		},
+		{
+			name: "scanned pages stay with their page markers",
+			parts: []Part{
+				Text(PageMarker(1) + "\n(画像)\n"), page1,
+				Text("\n" + PageMarker(2) + "\n(画像)\n"), page2,
+			},
+			maxChars: blobChars + 100,
+			want: [][]Part{
+				{Text(PageMarker(1) + "\n(画像)\n"), page1, Text("\n")},
+				{Text(PageMarker(2) + "\n(画像)\n"), page2},
+			},
+		},
+		{
+			name: "files of several sources",
+			parts: []Part{
+				Text(notes + "\n"), page1,
+				Text("\n\n" + exam + "\n" + strings.Repeat("問", 50) + "\n"),
+			},
+			maxChars: blobChars + 100,
+			want: [][]Part{
+				{Text(notes + "\n"), page1, Text("\n\n")},
+				{Text(exam + "\n" + strings.Repeat("問", 50) + "\n")},
+			},
+		},
+		{
+			name:     "split at page markers",
+			parts:    []Part{Text(pages)},
+			maxChars: 60,
+			want: texts(
+				PageMarker(1)+"\n"+strings.Repeat("あ", 40)+"\n",
+				PageMarker(2)+"\n"+strings.Repeat("い", 40)+"\n",
+			),
+		},
+		{
+			name:     "small sections are packed together",
+			parts:    []Part{Text("# A\naaa\n# B\nbbb\n# C\n" + strings.Repeat("c", 20))},
+			maxChars: 24,
+			want:     texts("# A\naaa\n# B\nbbb\n", "# C\n"+strings.Repeat("c", 20)),
+		},
+		{
+			name:     "long sections are cut at paragraphs",
+			parts:    []Part{Text(longParagraphs)},
+			maxChars: 40,
+			want: texts(
+				strings.Repeat("a", 30)+"\n\n",
+				strings.Repeat("b", 30)+"\n\n",
+				strings.Repeat("c", 30),
+			),
+		},
+		{
+			name:     "text without breaks is cut by length",
+			parts:    []Part{Text(strings.Repeat("x", 25))},
+			maxChars: 10,
+			want:     texts(strings.Repeat("x", 10), strings.Repeat("x", 10), strings.Repeat("x", 5)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitInput(tt.parts, tt.maxChars)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("splitInput() = %q, want %q", got, tt.want)
+			}
+			for i, chunk := range got {
+				if n := chunkChars(chunk); n > tt.maxChars {
+					t.Errorf("chunk %d has %d characters, more than %d", i, n, tt.maxChars)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitInputRepeatsSourceMarkers(t *testing.T) {
+	notes := SourceMarker(1, 2, SourceLectureNotes, "notes.md")
+	exam := SourceMarker(2, 2, SourceSampleExam, "")
+	body := strings.Repeat("講義の内容です。\n\n", 20)
+	text := notes + "\n" + body + exam + "\n" + "問1 次の関数を微分せよ。\n"
+	maxChars := 80
+
+	chunks := splitInput([]Part{Text(text)}, maxChars)
+	if len(chunks) < 3 {
+		t.Fatalf("splitInput() returned %d chunks, want at least 3", len(chunks))
+	}
+
+	var rebuilt strings.Builder
+	current := ""
+	for i, parts := range chunks {
+		chunk := textOf(parts)
+		if n := chunkChars(parts); n > maxChars {
+			t.Errorf("chunk %d has %d characters, more than %d", i, n, maxChars)
+		}
+		first, rest, _ := strings.Cut(chunk, "\n")
+		if !strings.HasPrefix(first, sourceMarkerPrefix) {
+			t.Fatalf("chunk %d does not start with a source marker: %q", i, chunk)
+		}
+		// A marker that continues the current source was repeated; drop it to rebuild the input.
+		if first == current && strings.Contains(text, first) && i > 0 {
+			rebuilt.WriteString(rest)
+		} else {
+			rebuilt.WriteString(chunk)
+		}
+		current = first
+	}
+	if rebuilt.String() != text {
+		t.Errorf("chunks without the repeated markers do not add up to the input:\n got %q\nwant %q", rebuilt.String(), text)
+	}
+	if last := textOf(chunks[len(chunks)-1]); !strings.HasPrefix(last, exam) {
+		t.Errorf("last chunk starts with %q, want the sample exam marker", last)
+	}
+}
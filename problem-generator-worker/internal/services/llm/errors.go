@@ -0,0 +1,50 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// transientError marks a failure that may succeed when retried later, such as
+// rate limiting, an overloaded backend or a network timeout.
+type transientError struct{ err error }
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// Transient wraps err so that IsTransient reports true for it.
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &transientError{err: err}
+}
+
+// IsTransient reports whether err, or any error it wraps, is worth retrying.
+// Network errors and deadline expiry are always considered transient;
+// cancellation is not.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var te *transientError
+	if errors.As(err, &te) {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// IsTransientStatus reports whether an HTTP status code returned by a model
+// backend indicates a temporary condition.
+func IsTransientStatus(code int) bool {
+	switch code {
+	case 408, 429, 500, 502, 503, 504:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrEmptyResponse is returned by models when the backend answered without any content.
+var ErrEmptyResponse = errors.New("AI response was empty")
+
+var (
+	invalidEscapeRegex = regexp.MustCompile(`\\([^"\\/bfnrtu])`)
+	codeFenceRegex     = regexp.MustCompile("(?s)```json(.*)```")
+)
+
+// parseAndCleanAndFixJSONResponse safely extracts and fixes malformed JSON.
+func parseAndCleanAndFixJSONResponse(rawResponse string) (string, error) {
+	if rawResponse == "" {
+		return "", ErrEmptyResponse
+	}
+
+	if strings.Contains(rawResponse, "```") {
+		matches := codeFenceRegex.FindStringSubmatch(rawResponse)
+		if len(matches) >= 2 {
+			rawResponse = matches[1]
+		}
+	}
+
+	trimmed := strings.TrimSpace(rawResponse)
+
+	// Step 1: Try raw JSON
+	if isValidJSON(trimmed) {
+		return trimmed, nil
+	}
+
+	// Step 2: Apply fix
+	sanitized := sanitizeJSONString(trimmed)
+
+	if isValidJSON(sanitized) {
+		return sanitized, nil
+	}
+
+	return "", fmt.Errorf("failed to parse valid JSON even after sanitization: %s", sanitized)
+}
+
+func sanitizeJSONString(s string) string {
+	return invalidEscapeRegex.ReplaceAllString(s, `\\$1`)
+}
+
+func isValidJSON(input string) bool {
+	decoder := json.NewDecoder(strings.NewReader(input))
+	decoder.DisallowUnknownFields()
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package llm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseAndCleanAndFixJSONResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+		empty   bool
+	}{
+		{name: "empty response", raw: "", wantErr: true, empty: true},
+		{name: "valid JSON", raw: `{"a": 1}`, want: `{"a": 1}`},
+		{name: "surrounding whitespace", raw: "\n  {\"a\": 1}\n\n", want: `{"a": 1}`},
+		{name: "code fence", raw: "Here you go:\n```json\n{\"a\": [1, 2]}\n```\nDone.", want: `{"a": [1, 2]}`},
+		{name: "LaTeX command with an invalid escape", raw: `{"q": "$\alpha + 1$"}`, want: `{"q": "$\\alpha + 1$"}`},
+		{name: "LaTeX command in a code fence", raw: "```json\n{\"q\": \"$\\sqrt{2}$\"}\n```", want: `{"q": "$\\sqrt{2}$"}`},
+		{name: "valid escapes are kept", raw: `{"q": "a\nb \"c\" \\d"}`, want: `{"q": "a\nb \"c\" \\d"}`},
+		{name: "truncated JSON", raw: `{"q": "unfinished`, wantErr: true},
+		{name: "plain text", raw: "I cannot help with that.", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAndCleanAndFixJSONResponse(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseAndCleanAndFixJSONResponse(%q) = %q, want an error", tt.raw, got)
+				}
+				if errors.Is(err, ErrEmptyResponse) != tt.empty {
+					t.Errorf("errors.Is(err, ErrEmptyResponse) = %t, want %t (err: %v)", !tt.empty, tt.empty, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAndCleanAndFixJSONResponse(%q) error = %v", tt.raw, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseAndCleanAndFixJSONResponse(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
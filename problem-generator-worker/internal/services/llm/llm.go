@@ -0,0 +1,299 @@
+// Package llm defines the provider-neutral interface the worker uses to talk to
+// language models, so the processing pipeline does not depend on any vendor SDK.
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/models"
+)
+
+// Part is a single piece of model input. It is either Text or Blob.
+type Part interface {
+	isPart()
+}
+
+// Text is a plain text input part.
+type Text string
+
+// Blob is a binary input part such as a PDF file.
+type Blob struct {
+	MIMEType string
+	Data     []byte
+}
+
+func (Text) isPart() {}
+func (Blob) isPart() {}
+
+// Usage holds the token accounting reported by a model for one request.
+type Usage struct {
+	PromptTokenCount     int32 `json:"prompt_token_count"`
+	CandidatesTokenCount int32 `json:"candidates_token_count"`
+	TotalTokenCount      int32 `json:"total_token_count"`
+}
+
+// Provider is the interface the processor depends on. Each backend (Gemini,
+// OpenAI-compatible servers, ...) is exposed through this interface.
+type Provider interface {
+	ExtractStructure(ctx context.Context, opts models.GenerationOptions, parts ...Part) (*models.ProblemStructure, *Usage, error)
+	GenerateProblem(ctx context.Context, problemStructure *models.ProblemStructure, opts models.GenerationOptions) (*models.GeneratedData, *Usage, error)
+	RegenerateQuestion(ctx context.Context, req QuestionRequest, opts models.GenerationOptions) (*models.GeneratedQuestion, *Usage, error)
+}
+
+// QuestionRequest describes one question of an existing exam to generate again.
+// Only this sub-question and the exam context are sent to the model.
+type QuestionRequest struct {
+	ExamMeta     models.ExamMeta
+	SectionTitle string
+	SubQuestion  models.SubQuestion
+	// Previous is the question being replaced, if any.
+	Previous *models.GeneratedQuestion
+	// Instructions is optional feedback from the user, such as what was wrong.
+	Instructions string
+}
+
+// Model is a single backend model that turns prompt parts into raw response text.
+// Backends only need to implement this; prompting and JSON handling are shared.
+type Model interface {
+	Name() string
+	GenerateText(ctx context.Context, parts ...Part) (string, *Usage, error)
+}
+
+// ErrModelSelectionUnsupported is returned when a job asks for a specific model
+// but the configured backend cannot create models by name.
+var ErrModelSelectionUnsupported = errors.New("model selection is not supported by this backend")
+
+// UnbilledModel is implemented by models whose calls cost nothing, such as
+// the fake backend and cassette replays. Their attempts are recorded but do
+// not count against token budgets.
+type UnbilledModel interface {
+	Model
+	Unbilled() bool
+}
+
+// Billed reports whether the calls of m are paid for.
+func Billed(m Model) bool {
+	um, ok := m.(UnbilledModel)
+	return !ok || !um.Unbilled()
+}
+
+// JSONModel is implemented by models that can constrain their output natively
+// to the JSON shape of a Go value, such as Gemini's response schemas. shape is
+// a zero value of the expected type (for example models.ProblemStructure{}).
+// Service prefers GenerateJSON over GenerateText when a model implements it.
+type JSONModel interface {
+	Model
+	GenerateJSON(ctx context.Context, shape any, parts ...Part) (string, *Usage, error)
+}
+
+// Service implements Provider on top of one model for structure extraction and
+// one model for problem generation. Optional fallback models are tried when the
+// primary model keeps failing.
+type Service struct {
+	Extraction         Model
+	Generation         Model
+	ExtractionFallback Model
+	GenerationFallback Model
+	Retry              RetryPolicy
+	// Chunking controls how long inputs are split for structure extraction.
+	Chunking ChunkPolicy
+
+	// NewModel, if set, returns the backend model called name for a stage.
+	// It serves jobs that request a model via GenerationOptions.Model.
+	NewModel func(stage, name string) (Model, error)
+}
+
+// model returns the primary model for a stage, honouring a requested model name.
+func (s *Service) model(stage string, configured Model, requested string) (Model, error) {
+	if requested == "" || (configured != nil && configured.Name() == requested) {
+		return configured, nil
+	}
+	if s.NewModel == nil {
+		return nil, fmt.Errorf("%w (requested '%s')", ErrModelSelectionUnsupported, requested)
+	}
+	return s.NewModel(stage, requested)
+}
+
+func (s *Service) ExtractStructure(ctx context.Context, opts models.GenerationOptions, parts ...Part) (*models.ProblemStructure, *Usage, error) {
+	if s.Extraction == nil {
+		return nil, nil, fmt.Errorf("extraction model not initialized")
+	}
+	primary, err := s.model(StageExtractStructure, s.Extraction, opts.Model)
+	if err != nil {
+		return nil, nil, fmt.Errorf("structure extraction failed: %w", err)
+	}
+	policy := s.Chunking
+	if policy.MaxChars <= 0 {
+		policy = DefaultChunkPolicy
+	}
+
+	note := ""
+	if hasSources(parts) {
+		note = sourcesPrompt
+	}
+
+	var problemStructure *models.ProblemStructure
+	var usage *Usage
+	if chunks := splitInput(parts, policy.MaxChars); len(chunks) > 1 {
+		problemStructure, usage, err = s.extractChunks(ctx, primary, opts, note, chunks, policy.Concurrency)
+	} else {
+		problemStructure, usage, err = s.extractOnce(ctx, primary, opts, note, parts)
+	}
+	if err != nil {
+		return nil, usage, fmt.Errorf("structure extraction failed: %w", err)
+	}
+	// An explicit LaTeX choice overrides whatever the model inferred from the input.
+	if opts.Latex != nil {
+		problemStructure.ExamMeta.QuestionFormatIsLatex = *opts.Latex
+		problemStructure.ExamMeta.AnswerFormatIsLatex = *opts.Latex
+	}
+	return problemStructure, usage, nil
+}
+
+// extractOnce extracts a structure from parts with a single model call.
+// note is appended to the instructions, for example to describe a chunk.
+func (s *Service) extractOnce(ctx context.Context, primary Model, opts models.GenerationOptions, note string, parts []Part) (*models.ProblemStructure, *Usage, error) {
+	promptParts := []Part{Text(structureExtractionPromptTemplate)}
+	if instructions := optionsPrompt(opts) + note; instructions != "" {
+		promptParts = append(promptParts, Text("\n\n"+instructions))
+	}
+	promptParts = append(promptParts, parts...)
+
+	var problemStructure models.ProblemStructure
+	usage, err := s.call(ctx, StageExtractStructure, primary, s.ExtractionFallback, promptParts, models.ProblemStructure{}, func(jsonOutput string) error {
+		problemStructure = models.ProblemStructure{}
+		if err := json.Unmarshal([]byte(jsonOutput), &problemStructure); err != nil {
+			return fmt.Errorf("failed to unmarshal final JSON (structure): %w. Final JSON string: %s", err, jsonOutput)
+		}
+		return ValidateStructure(&problemStructure)
+	})
+	if err != nil {
+		return nil, usage, err
+	}
+	return &problemStructure, usage, nil
+}
+
+// extractChunks extracts a structure from every chunk, running up to
+// concurrency requests at once, and merges the results with MergeStructures.
+// note is added to the instructions of every chunk. Any failed chunk fails the
+// whole extraction.
+func (s *Service) extractChunks(ctx context.Context, primary Model, opts models.GenerationOptions, note string, chunks [][]Part, concurrency int) (*models.ProblemStructure, *Usage, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	structures := make([]*models.ProblemStructure, len(chunks))
+	usages := make([]*Usage, len(chunks))
+	errs := make([]error, len(chunks))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk []Part) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			chunkNote := note + fmt.Sprintf(chunkPromptTemplate, len(chunks), i+1)
+			structures[i], usages[i], errs[i] = s.extractOnce(ctx, primary, opts, chunkNote, chunk)
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	total := &Usage{}
+	for _, u := range usages {
+		total.Add(u)
+	}
+	for i, err := range errs {
+		if err != nil {
+			return nil, total, fmt.Errorf("chunk %d of %d: %w", i+1, len(chunks), err)
+		}
+	}
+	merged := MergeStructures(structures, opts.NumQuestions)
+	if err := ValidateStructure(merged); err != nil {
+		return nil, total, fmt.Errorf("merged structure is invalid: %w", err)
+	}
+	return merged, total, nil
+}
+
+func (s *Service) GenerateProblem(ctx context.Context, problemStructure *models.ProblemStructure, opts models.GenerationOptions) (*models.GeneratedData, *Usage, error) {
+	if s.Generation == nil {
+		return nil, nil, fmt.Errorf("generation model not initialized")
+	}
+	primary, err := s.model(StageGenerateProblem, s.Generation, opts.Model)
+	if err != nil {
+		return nil, nil, fmt.Errorf("problem generation failed: %w", err)
+	}
+	structureBytes, err := json.MarshalIndent(problemStructure, "", "  ")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal problem structure: %w", err)
+	}
+
+	// The structure already fixes the number of questions.
+	opts.NumQuestions = 0
+	prompt := fmt.Sprintf(problemAndAnswerGenerationPromptTemplate, optionsPrompt(opts), string(structureBytes))
+
+	var generatedOutput models.GeneratedData
+	usage, err := s.call(ctx, StageGenerateProblem, primary, s.GenerationFallback, []Part{Text(prompt)}, models.GeneratedData{}, func(jsonOutput string) error {
+		generatedOutput = models.GeneratedData{}
+		if err := json.Unmarshal([]byte(jsonOutput), &generatedOutput); err != nil {
+			return fmt.Errorf("failed to unmarshal final JSON (problem/answer): %w. Final JSON string: %s", err, jsonOutput)
+		}
+		return ValidateGeneration(&generatedOutput, problemStructure)
+	})
+	if err != nil {
+		return nil, usage, fmt.Errorf("problem generation failed: %w", err)
+	}
+	return &generatedOutput, usage, nil
+}
+
+// questionContext is the JSON document embedded in the regeneration prompt.
+type questionContext struct {
+	ExamMeta         models.ExamMeta           `json:"exam_meta"`
+	SectionTitle     string                    `json:"section_title,omitempty"`
+	SubQuestion      models.SubQuestion        `json:"sub_question"`
+	PreviousQuestion *models.GeneratedQuestion `json:"previous_question,omitempty"`
+}
+
+func (s *Service) RegenerateQuestion(ctx context.Context, req QuestionRequest, opts models.GenerationOptions) (*models.GeneratedQuestion, *Usage, error) {
+	if s.Generation == nil {
+		return nil, nil, fmt.Errorf("generation model not initialized")
+	}
+	primary, err := s.model(StageRegenerateQuestion, s.Generation, opts.Model)
+	if err != nil {
+		return nil, nil, fmt.Errorf("question regeneration failed: %w", err)
+	}
+	contextBytes, err := json.MarshalIndent(questionContext{
+		ExamMeta:         req.ExamMeta,
+		SectionTitle:     req.SectionTitle,
+		SubQuestion:      req.SubQuestion,
+		PreviousQuestion: req.Previous,
+	}, "", "  ")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal question context: %w", err)
+	}
+
+	opts.NumQuestions = 0
+	instructions := optionsPrompt(opts)
+	if req.Instructions != "" {
+		instructions += "**利用者からの修正依頼:**\n" + req.Instructions + "\n\n"
+	}
+	prompt := fmt.Sprintf(questionRegenerationPromptTemplate, instructions, string(contextBytes))
+
+	var question models.GeneratedQuestion
+	usage, err := s.call(ctx, StageRegenerateQuestion, primary, s.GenerationFallback, []Part{Text(prompt)}, models.GeneratedQuestion{}, func(jsonOutput string) error {
+		question = models.GeneratedQuestion{}
+		if err := json.Unmarshal([]byte(jsonOutput), &question); err != nil {
+			return fmt.Errorf("failed to unmarshal final JSON (question): %w. Final JSON string: %s", err, jsonOutput)
+		}
+		return ValidateQuestion(&question)
+	})
+	if err != nil {
+		return nil, usage, fmt.Errorf("question regeneration failed: %w", err)
+	}
+	// Keep the question in its slot even if the model renumbered it.
+	question.QuestionIndex = req.SubQuestion.QuestionIndex
+	return &question, usage, nil
+}
@@ -0,0 +1,135 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/models"
+)
+
+// MergeStructures combines the structures extracted from the chunks of one
+// input into a single structure. Sections with the same title are combined,
+// sub-questions with the same topic are kept only once (their keywords are
+// merged), and sections and sub-questions are renumbered in order. If
+// numQuestions is positive, the merged structure is cut down to that many
+// sub-questions, taking them from the sections in turn.
+func MergeStructures(parts []*models.ProblemStructure, numQuestions int) *models.ProblemStructure {
+	merged := &models.ProblemStructure{}
+	materials := map[string]bool{}
+	sectionByTitle := map[string]int{}
+	seenTopics := map[string][2]int{} // topic -> section, sub-question
+
+	for _, ps := range parts {
+		if ps == nil {
+			continue
+		}
+		meta := ps.ExamMeta
+		if merged.ExamMeta.ExamTitle == "" {
+			merged.ExamMeta.ExamTitle = meta.ExamTitle
+		}
+		if meta.ExamDuration != nil && (merged.ExamMeta.ExamDuration == nil || *meta.ExamDuration > *merged.ExamMeta.ExamDuration) {
+			d := *meta.ExamDuration
+			merged.ExamMeta.ExamDuration = &d
+		}
+		merged.ExamMeta.OpenBook = merged.ExamMeta.OpenBook || meta.OpenBook
+		merged.ExamMeta.QuestionFormatIsLatex = merged.ExamMeta.QuestionFormatIsLatex || meta.QuestionFormatIsLatex
+		merged.ExamMeta.AnswerFormatIsLatex = merged.ExamMeta.AnswerFormatIsLatex || meta.AnswerFormatIsLatex
+		for _, m := range meta.AllowedMaterials {
+			if !materials[m] {
+				materials[m] = true
+				merged.ExamMeta.AllowedMaterials = append(merged.ExamMeta.AllowedMaterials, m)
+			}
+		}
+
+		for _, section := range ps.Structure.MajorSections {
+			key := Normalize(section.SectionTitle)
+			i, ok := sectionByTitle[key]
+			if !ok || key == "" {
+				merged.Structure.MajorSections = append(merged.Structure.MajorSections, models.MajorSection{SectionTitle: section.SectionTitle})
+				i = len(merged.Structure.MajorSections) - 1
+				sectionByTitle[key] = i
+			}
+			target := &merged.Structure.MajorSections[i]
+			for _, sq := range section.SubQuestions {
+				topic := Normalize(sq.Topic)
+				if at, dup := seenTopics[topic]; dup && topic != "" {
+					existing := &merged.Structure.MajorSections[at[0]].SubQuestions[at[1]]
+					existing.Keywords = mergeKeywords(existing.Keywords, sq.Keywords)
+					continue
+				}
+				target.SubQuestions = append(target.SubQuestions, sq)
+				seenTopics[topic] = [2]int{i, len(target.SubQuestions) - 1}
+			}
+		}
+	}
+
+	if numQuestions > 0 {
+		limitQuestions(merged, numQuestions)
+	}
+	renumber(merged)
+	return merged
+}
+
+// limitQuestions keeps at most n sub-questions, picking one from each section
+// in turn so that every section stays represented for as long as possible.
+func limitQuestions(ps *models.ProblemStructure, n int) {
+	sections := ps.Structure.MajorSections
+	keep := make([]int, len(sections))
+	for picked := 0; picked < n; {
+		progressed := false
+		for i := range sections {
+			if picked < n && keep[i] < len(sections[i].SubQuestions) {
+				keep[i]++
+				picked++
+				progressed = true
+			}
+		}
+		if !progressed {
+			break
+		}
+	}
+	kept := sections[:0]
+	for i, section := range sections {
+		if keep[i] > 0 {
+			section.SubQuestions = section.SubQuestions[:keep[i]]
+			kept = append(kept, section)
+		}
+	}
+	ps.Structure.MajorSections = kept
+}
+
+// renumber assigns section indices "1", "2", ... and question indices "1-1", "1-2", ...
+func renumber(ps *models.ProblemStructure) {
+	for i := range ps.Structure.MajorSections {
+		section := &ps.Structure.MajorSections[i]
+		section.SectionIndex = fmt.Sprint(i + 1)
+		for j := range section.SubQuestions {
+			section.SubQuestions[j].QuestionIndex = fmt.Sprintf("%d-%d", i+1, j+1)
+		}
+	}
+}
+
+func mergeKeywords(a, b []string) []string {
+	seen := map[string]bool{}
+	for _, k := range a {
+		seen[Normalize(k)] = true
+	}
+	for _, k := range b {
+		if !seen[Normalize(k)] {
+			seen[Normalize(k)] = true
+			a = append(a, k)
+		}
+	}
+	return a
+}
+
+// Normalize makes titles, topics and keywords comparable regardless of case and spacing.
+func Normalize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, s)
+}
@@ -0,0 +1,145 @@
+package llm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/models"
+)
+
+func TestMergeStructures(t *testing.T) {
+	minutes := func(n int) *int { return &n }
+	section := func(title string, topics ...string) models.MajorSection {
+		s := models.MajorSection{SectionIndex: "x", SectionTitle: title}
+		for _, topic := range topics {
+			s.SubQuestions = append(s.SubQuestions, models.SubQuestion{QuestionIndex: "x", Topic: topic})
+		}
+		return s
+	}
+	structure := func(meta models.ExamMeta, sections ...models.MajorSection) *models.ProblemStructure {
+		return &models.ProblemStructure{ExamMeta: meta, Structure: models.StructureSection{MajorSections: sections}}
+	}
+
+	// summary lists each merged section as its index, title and "index:topic" pairs.
+	type summary struct {
+		index, title string
+		questions    []string
+	}
+
+	tests := []struct {
+		name         string
+		parts        []*models.ProblemStructure
+		numQuestions int
+		want         []summary
+	}{
+		{
+			name: "sections with the same title are combined",
+			parts: []*models.ProblemStructure{
+				structure(models.ExamMeta{}, section("微分", "導関数"), section("積分", "定積分")),
+				structure(models.ExamMeta{}, section(" 微 分 ", "合成関数の微分")),
+			},
+			want: []summary{
+				{"1", "微分", []string{"1-1:導関数", "1-2:合成関数の微分"}},
+				{"2", "積分", []string{"2-1:定積分"}},
+			},
+		},
+		{
+			name: "repeated topics are kept once",
+			parts: []*models.ProblemStructure{
+				structure(models.ExamMeta{}, section("A", "Chain Rule")),
+				structure(models.ExamMeta{}, section("B", "chain rule", "Limits")),
+			},
+			want: []summary{
+				{"1", "A", []string{"1-1:Chain Rule"}},
+				{"2", "B", []string{"2-1:Limits"}},
+			},
+		},
+		{
+			name: "untitled sections are never combined",
+			parts: []*models.ProblemStructure{
+				structure(models.ExamMeta{}, section("", "a")),
+				nil,
+				structure(models.ExamMeta{}, section("", "b")),
+			},
+			want: []summary{
+				{"1", "", []string{"1-1:a"}},
+				{"2", "", []string{"2-1:b"}},
+			},
+		},
+		{
+			name: "question limit takes from each section in turn",
+			parts: []*models.ProblemStructure{
+				structure(models.ExamMeta{}, section("A", "a1", "a2", "a3"), section("B", "b1"), section("C", "c1", "c2")),
+			},
+			numQuestions: 4,
+			want: []summary{
+				{"1", "A", []string{"1-1:a1", "1-2:a2"}},
+				{"2", "B", []string{"2-1:b1"}},
+				{"3", "C", []string{"3-1:c1"}},
+			},
+		},
+		{
+			name: "question limit drops whole sections",
+			parts: []*models.ProblemStructure{
+				structure(models.ExamMeta{}, section("A", "a1", "a2"), section("B", "b1")),
+			},
+			numQuestions: 1,
+			want:         []summary{{"1", "A", []string{"1-1:a1"}}},
+		},
+		{
+			name: "question limit above the total keeps everything",
+			parts: []*models.ProblemStructure{
+				structure(models.ExamMeta{}, section("A", "a1"), section("B", "b1")),
+			},
+			numQuestions: 10,
+			want: []summary{
+				{"1", "A", []string{"1-1:a1"}},
+				{"2", "B", []string{"2-1:b1"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := MergeStructures(tt.parts, tt.numQuestions)
+			var got []summary
+			for _, s := range merged.Structure.MajorSections {
+				sum := summary{index: s.SectionIndex, title: s.SectionTitle}
+				for _, sq := range s.SubQuestions {
+					sum.questions = append(sum.questions, sq.QuestionIndex+":"+sq.Topic)
+				}
+				got = append(got, sum)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MergeStructures() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("exam meta and keywords are merged", func(t *testing.T) {
+		first := structure(models.ExamMeta{ExamTitle: "解析学", ExamDuration: minutes(60), AllowedMaterials: []string{"電卓"}},
+			section("A", "極限"))
+		first.Structure.MajorSections[0].SubQuestions[0].Keywords = []string{"ε-δ", "収束"}
+		second := structure(models.ExamMeta{ExamTitle: "別の題名", ExamDuration: minutes(90), OpenBook: true, AllowedMaterials: []string{"電卓", "教科書"}},
+			section("A", "極 限"))
+		second.Structure.MajorSections[0].SubQuestions[0].Keywords = []string{"収束", "数列"}
+
+		merged := MergeStructures([]*models.ProblemStructure{first, second}, 0)
+		meta := merged.ExamMeta
+		if meta.ExamTitle != "解析学" {
+			t.Errorf("ExamTitle = %q, want the first title", meta.ExamTitle)
+		}
+		if meta.ExamDuration == nil || *meta.ExamDuration != 90 {
+			t.Errorf("ExamDuration = %v, want the longest duration 90", meta.ExamDuration)
+		}
+		if !meta.OpenBook {
+			t.Error("OpenBook = false, want true when any part is open book")
+		}
+		if want := []string{"電卓", "教科書"}; !reflect.DeepEqual(meta.AllowedMaterials, want) {
+			t.Errorf("AllowedMaterials = %v, want %v", meta.AllowedMaterials, want)
+		}
+		if want := []string{"ε-δ", "収束", "数列"}; !reflect.DeepEqual(merged.Structure.MajorSections[0].SubQuestions[0].Keywords, want) {
+			t.Errorf("Keywords = %v, want %v", merged.Structure.MajorSections[0].SubQuestions[0].Keywords, want)
+		}
+	})
+}
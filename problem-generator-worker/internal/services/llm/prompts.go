@@ -0,0 +1,161 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/models"
+)
+
+// プロンプトを厳格化し、AIがタスクを誤解したり、余計なテキストを出力したりするのを防ぎます。
+const structureExtractionPromptTemplate = `あなたのタスクは、以下の「入力テキスト」を分析し、指定されたJSON形式で出力することです。
+**最重要ルール: あなたの応答は、JSONオブジェクト自体で開始し、終了する必要があります。JSONの前後に、いかなる説明、前置き、言い訳、その他のテキストも絶対に追加しないでください。**
+
+**ステップ1：入力タイプの判断**
+まず、入力テキストが**「試験問題形式」**か、それとも**「講義ノートや教科書のような学習資料形式」**かを判断してください。
+
+**ステップ2：タイプに応じた処理の実行**
+
+**【入力が「試験問題形式」の場合】**
+テキストに書かれている問題の構造を**そのまま抽出**し、JSONにマッピングしてください。
+- ` + "`exam_meta`" + `: 試験のタイトルや設定を抽出します。
+- ` + "`major_sections`" + `: 大問を抽出します。
+- ` + "`sub_questions`" + `: 各大問を小問ごとに区切ってください。` + "`question_index`" + `には問題番号をそのまま使います。
+
+**【入力が「講義ノートや学習資料形式」の場合】**
+その資料から**新しい試験問題を作成するための設計図**として、JSONを**提案・生成**してください。
+- ` + "`exam_meta.exam_title`" + `: 資料全体を代表する架空の試験タイトル（例: 「〇〇学 期末試験設計案」）を設定してください。
+- ` + "`major_sections`" + `: 資料内容を論理的なセクションに分割し、` + "`section_title`" + `を設定してください。
+- ` + "`sub_questions`" + `: 各セクションで問うべき具体的な小問を、キーワードレベルで設定してください。
+
+**共通ルール:**
+- **問題を解いたり、JSON以外のテキストを出力したりしないでください。**
+- 出力は**必ず**指定されたJSONオブジェクト形式でなければなりません。
+
+**JSON出力スキーマ:**
+{
+  "exam_meta": { "exam_title": "string", "exam_duration": "integer (minutes)", "open_book": "boolean", "allowed_materials": ["string"], "question_format_is_latex": "boolean", "answer_format_is_latex": "boolean" },
+  "structure": {
+    "major_sections": [
+      { "section_index": "string", "section_title": "string", "sub_questions": [ { "question_index": "string", "topic": "string", "keywords": ["string"], "difficulty": "string" } ] }
+    ]
+  }
+}`
+
+const problemAndAnswerGenerationPromptTemplate = `以下の問題構造情報に基づいて、新しい大学レベルの試験問題とその解答のセットを生成してください。
+
+**最重要ルール:**
+- **あなたの応答は、JSONオブジェクト自体で開始し、終了する必要があります。JSONの前後に、いかなる説明、前置き、その他のテキストも絶対に追加しないでください。**
+- **絶対に、LaTeXのプリアンブルやドキュメント環境コマンド（例: \documentclass, \usepackage, \begin{document}, \end{document}）を含めないでください。**
+- ` + "`question_text`と`answer_text`" + `の値は、Markdown形式のテキスト本体のみにしてください。
+- 数式はインライン($...$)またはディスプレイ($$...$$)形式で記述してください。
+- **注意: 文字列中にバックスラッシュ（\）を使用する場合、JSONの構文規則に従い、必ず\\と二重にエスケープしてください。**
+
+
+%s**JSON出力スキーマ:**
+{
+  "exam_meta": { "exam_title": "string", "open_book": "boolean", "question_format_is_latex": "boolean", "answer_format_is_latex": "boolean" },
+  "questions": [
+    { "question_index": "string", "topic": "string", "keywords": ["string"], "difficulty": "string", "question_text": "string", "answer_text": "string" }
+  ]
+}
+
+**元の問題構造情報:**
+%s
+`
+
+// questionRegenerationPromptTemplate asks for a single replacement question. It
+// receives the user's instructions and the question context as JSON.
+const questionRegenerationPromptTemplate = `以下の試験の小問を1問だけ、新しく作り直してください。
+
+**最重要ルール:**
+- **あなたの応答は、JSONオブジェクト自体で開始し、終了する必要があります。JSONの前後に、いかなる説明、前置き、その他のテキストも絶対に追加しないでください。**
+- ` + "`sub_question`" + `のトピック・キーワード・難易度に沿った問題にしてください。` + "`previous_question`" + `がある場合は、それとは異なる問題にしてください。
+- **絶対に、LaTeXのプリアンブルやドキュメント環境コマンド（例: \documentclass, \usepackage, \begin{document}, \end{document}）を含めないでください。**
+- ` + "`question_text`と`answer_text`" + `の値は、Markdown形式のテキスト本体のみにしてください。
+- 数式はインライン($...$)またはディスプレイ($$...$$)形式で記述してください。
+- **注意: 文字列中にバックスラッシュ（\）を使用する場合、JSONの構文規則に従い、必ず\\と二重にエスケープしてください。**
+
+%s**JSON出力スキーマ:**
+{ "question_index": "string", "topic": "string", "keywords": ["string"], "difficulty": "string", "question_text": "string", "answer_text": "string" }
+
+**対象の小問と試験の情報:**
+%s
+`
+
+// repairPromptTemplate is appended to the original prompt when the previous
+// response could not be used. It receives the error and the rejected response.
+const repairPromptTemplate = `
+
+**前回の応答は使用できませんでした。**
+エラー内容: %v
+
+前回の応答:
+%s
+
+上記のエラーを修正し、指定されたJSONスキーマに厳密に従った有効なJSONオブジェクトのみを出力し直してください。JSONの前後にテキストを追加しないでください。`
+
+// sourcesPrompt is added to the extraction instructions when the input
+// consists of several labelled source files.
+const sourcesPrompt = `**複数の資料について:**
+入力は複数の資料から成り、各資料は「=== 資料 番号/総数: 役割 (ファイル名) ===」の行で始まります。「講義資料」は出題範囲となる内容です。「過去問・サンプル試験」は試験の形式・構成・難易度の手本です。講義資料がある場合は、講義資料の内容から、過去問の形式に倣った新しい試験の設計図を作成してください。過去問の問題をそのまま写さないでください。すべての資料をまとめて1つの試験にしてください。
+
+`
+
+// chunkPromptTemplate is added to the extraction instructions when a long input
+// is split. It receives the number of chunks and the position of this chunk.
+const chunkPromptTemplate = `**分割された入力について:**
+入力テキストは長いため%d個に分割されており、以下はその%d番目です。この部分に含まれる内容だけから構造を抽出してください。分割された結果は後で1つの試験にまとめられます。試験タイトルは資料全体を表すものにしてください。
+
+`
+
+// questionTypeLabels names the question types accepted in GenerationOptions.
+var questionTypeLabels = map[string]string{
+	"multiple_choice": "多肢選択式",
+	"true_false":      "正誤問題",
+	"short_answer":    "短答式",
+	"essay":           "論述式",
+	"calculation":     "計算問題",
+	"proof":           "証明問題",
+}
+
+// optionsPrompt renders the per-request options as additional instructions.
+// It returns an empty string when no option is set.
+func optionsPrompt(opts models.GenerationOptions) string {
+	var lines []string
+	if opts.NumQuestions > 0 {
+		lines = append(lines, fmt.Sprintf("- 小問の総数: %d問 (`sub_questions`/`questions` の合計がちょうどこの数になるようにしてください)", opts.NumQuestions))
+	}
+	switch opts.Difficulty {
+	case "":
+	case "mixed":
+		lines = append(lines, "- 難易度: easy / medium / hard をバランスよく混在させてください")
+	default:
+		lines = append(lines, fmt.Sprintf("- 難易度: すべての小問を `%s` にしてください", opts.Difficulty))
+	}
+	if len(opts.QuestionTypes) > 0 {
+		labels := make([]string, 0, len(opts.QuestionTypes))
+		for _, t := range opts.QuestionTypes {
+			if label, ok := questionTypeLabels[t]; ok {
+				labels = append(labels, fmt.Sprintf("%s (%s)", label, t))
+			} else {
+				labels = append(labels, t)
+			}
+		}
+		lines = append(lines, "- 問題形式: 次の形式のみを使用してください: "+strings.Join(labels, ", "))
+	}
+	if opts.Language != "" {
+		lines = append(lines, fmt.Sprintf("- 出力言語: 試験タイトル・トピック・問題文・解答を含むすべての文字列を言語コード `%s` の言語で書いてください (JSONのキーは変更しないでください)", opts.Language))
+	}
+	if opts.Latex != nil {
+		if *opts.Latex {
+			lines = append(lines, "- 数式の表記: 数式はLaTeX記法で記述してください")
+		} else {
+			lines = append(lines, "- 数式の表記: LaTeX記法を使用せず、プレーンテキストで記述してください")
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "**利用者による指定 (必ず従ってください):**\n" + strings.Join(lines, "\n") + "\n\n"
+}
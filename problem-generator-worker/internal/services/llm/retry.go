@@ -0,0 +1,238 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Stage names used when reporting attempts.
+const (
+	StageExtractStructure   = "extract_structure"
+	StageGenerateProblem    = "generate_problem"
+	StageRegenerateQuestion = "regenerate_question"
+)
+
+// Attempt outcomes.
+const (
+	OutcomeSuccess        = "success"
+	OutcomeTransientError = "transient_error"
+	OutcomeEmptyResponse  = "empty_response"
+	OutcomeInvalidOutput  = "invalid_output"
+	OutcomeError          = "error"
+)
+
+// RetryPolicy controls how a Service retries a single model call.
+// MaxAttempts counts every request sent to one model, including repair re-prompts.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy is used when a Service has no explicit policy.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: 2 * time.Second, MaxDelay: 30 * time.Second}
+
+// RetryPolicyFromEnv reads LLM_MAX_ATTEMPTS, LLM_RETRY_BASE_DELAY and LLM_RETRY_MAX_DELAY.
+func RetryPolicyFromEnv() RetryPolicy {
+	policy := DefaultRetryPolicy
+	if v, err := strconv.Atoi(os.Getenv("LLM_MAX_ATTEMPTS")); err == nil && v > 0 {
+		policy.MaxAttempts = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("LLM_RETRY_BASE_DELAY")); err == nil && v > 0 {
+		policy.BaseDelay = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("LLM_RETRY_MAX_DELAY")); err == nil && v > 0 {
+		policy.MaxDelay = v
+	}
+	return policy
+}
+
+// backoff returns a jittered exponential delay before the next attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	// Wait between half and the full delay so that workers do not retry in lockstep.
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// Attempt describes one request sent to a model.
+type Attempt struct {
+	Stage    string
+	Model    string
+	Number   int
+	Outcome  string
+	Err      error
+	Usage    *Usage
+	Duration time.Duration
+	// Billed is false for models implementing UnbilledModel.
+	Billed bool
+}
+
+// Trace receives a callback for every model attempt made with a context.
+// It is attached with WithTrace, in the style of net/http/httptrace.
+type Trace struct {
+	OnAttempt func(Attempt)
+}
+
+type traceKey struct{}
+
+// WithTrace returns a context that reports attempts to trace.
+func WithTrace(ctx context.Context, trace *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+func reportAttempt(ctx context.Context, a Attempt) {
+	if trace, ok := ctx.Value(traceKey{}).(*Trace); ok && trace.OnAttempt != nil {
+		trace.OnAttempt(a)
+	}
+}
+
+// outputError marks a response that arrived but could not be used. It is
+// answered with a repair re-prompt rather than a plain retry.
+type outputError struct{ err error }
+
+func (e *outputError) Error() string { return e.err.Error() }
+func (e *outputError) Unwrap() error { return e.err }
+
+// call sends parts to the primary model and then, if it keeps failing, to the
+// fallback model. Transient errors and empty responses are retried with backoff;
+// unusable output is answered with a repair prompt. shape is the zero value of
+// the expected output type, passed to models implementing JSONModel. decode
+// must return an error when the cleaned-up JSON does not fit the expected
+// shape. The returned usage covers every attempt.
+func (s *Service) call(ctx context.Context, stage string, primary, fallback Model, parts []Part, shape any, decode func(string) error) (*Usage, error) {
+	policy := s.Retry
+	if policy.MaxAttempts <= 0 {
+		policy = DefaultRetryPolicy
+	}
+
+	total := &Usage{}
+	var lastErr error
+	for _, model := range []Model{primary, fallback} {
+		if model == nil {
+			continue
+		}
+		if lastErr != nil {
+			log.Printf("Falling back to model %s for stage %s after: %v", model.Name(), stage, lastErr)
+		}
+
+		prompt := parts
+	attempts:
+		for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+			start := time.Now()
+			raw, usage, err := generate(ctx, model, shape, prompt)
+			total.Add(usage)
+			if err == nil {
+				err = decodeOutput(raw, decode)
+			}
+
+			reportAttempt(ctx, Attempt{
+				Stage:    stage,
+				Model:    model.Name(),
+				Number:   attempt,
+				Outcome:  outcomeOf(err),
+				Err:      err,
+				Usage:    usage,
+				Duration: time.Since(start),
+				Billed:   Billed(model),
+			})
+			if err == nil {
+				return total, nil
+			}
+			lastErr = err
+			if ctx.Err() != nil {
+				return total, ctx.Err()
+			}
+			if attempt == policy.MaxAttempts {
+				break
+			}
+
+			var outErr *outputError
+			switch {
+			case errors.As(err, &outErr):
+				prompt = append(append([]Part{}, parts...), Text(fmt.Sprintf(repairPromptTemplate, err, raw)))
+			case IsTransient(err), errors.Is(err, ErrEmptyResponse):
+				if err := sleep(ctx, policy.backoff(attempt)); err != nil {
+					return total, err
+				}
+			default:
+				// Not worth retrying on this model; move on to the fallback.
+				break attempts
+			}
+		}
+	}
+	return total, lastErr
+}
+
+func generate(ctx context.Context, model Model, shape any, parts []Part) (string, *Usage, error) {
+	if jm, ok := model.(JSONModel); ok {
+		return jm.GenerateJSON(ctx, shape, parts...)
+	}
+	return model.GenerateText(ctx, parts...)
+}
+
+func decodeOutput(raw string, decode func(string) error) error {
+	jsonOutput, err := parseAndCleanAndFixJSONResponse(raw)
+	if errors.Is(err, ErrEmptyResponse) {
+		return err
+	}
+	if err == nil {
+		err = decode(jsonOutput)
+	}
+	if err != nil {
+		return &outputError{err: err}
+	}
+	return nil
+}
+
+func outcomeOf(err error) string {
+	var outErr *outputError
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.As(err, &outErr):
+		return OutcomeInvalidOutput
+	case errors.Is(err, ErrEmptyResponse):
+		return OutcomeEmptyResponse
+	case IsTransient(err):
+		return OutcomeTransientError
+	default:
+		return OutcomeError
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Add accumulates other into u. A nil other is ignored.
+func (u *Usage) Add(other *Usage) {
+	if other == nil {
+		return
+	}
+	u.PromptTokenCount += other.PromptTokenCount
+	u.CandidatesTokenCount += other.CandidatesTokenCount
+	u.TotalTokenCount += other.TotalTokenCount
+}
@@ -0,0 +1,222 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptedModel answers each call with the next response of its script.
+type scriptedModel struct {
+	name      string
+	responses []scriptedResponse
+	unbilled  bool
+	prompts   [][]Part
+}
+
+type scriptedResponse struct {
+	raw string
+	err error
+}
+
+func (m *scriptedModel) Name() string { return m.name }
+
+func (m *scriptedModel) Unbilled() bool { return m.unbilled }
+
+func (m *scriptedModel) GenerateText(ctx context.Context, parts ...Part) (string, *Usage, error) {
+	m.prompts = append(m.prompts, parts)
+	if len(m.prompts) > len(m.responses) {
+		return "", nil, errors.New("scripted model: no more responses")
+	}
+	r := m.responses[len(m.prompts)-1]
+	return r.raw, &Usage{PromptTokenCount: 10, CandidatesTokenCount: 1, TotalTokenCount: 11}, r.err
+}
+
+var errPermanent = errors.New("permanent failure")
+
+func TestCall(t *testing.T) {
+	valid := scriptedResponse{raw: `{"value": "ok"}`}
+	transient := scriptedResponse{err: Transient(errors.New("503 unavailable"))}
+	empty := scriptedResponse{err: ErrEmptyResponse}
+	malformed := scriptedResponse{raw: `{"value": `}
+	wrongShape := scriptedResponse{raw: `{"other": 1}`}
+
+	tests := []struct {
+		name         string
+		primary      []scriptedResponse
+		fallback     []scriptedResponse
+		wantErr      error
+		wantOutcomes []string
+		wantModels   []string
+		// wantRepairs is the number of calls that carried a repair prompt.
+		wantRepairs int
+	}{
+		{
+			name:         "first attempt succeeds",
+			primary:      []scriptedResponse{valid},
+			wantOutcomes: []string{OutcomeSuccess},
+			wantModels:   []string{"primary"},
+		},
+		{
+			name:         "transient error is retried",
+			primary:      []scriptedResponse{transient, valid},
+			wantOutcomes: []string{OutcomeTransientError, OutcomeSuccess},
+			wantModels:   []string{"primary", "primary"},
+		},
+		{
+			name:         "empty response is retried",
+			primary:      []scriptedResponse{empty, valid},
+			wantOutcomes: []string{OutcomeEmptyResponse, OutcomeSuccess},
+			wantModels:   []string{"primary", "primary"},
+		},
+		{
+			name:         "malformed JSON is repaired",
+			primary:      []scriptedResponse{malformed, valid},
+			wantOutcomes: []string{OutcomeInvalidOutput, OutcomeSuccess},
+			wantModels:   []string{"primary", "primary"},
+			wantRepairs:  1,
+		},
+		{
+			name:         "output rejected by decode is repaired",
+			primary:      []scriptedResponse{wrongShape, wrongShape, valid},
+			wantOutcomes: []string{OutcomeInvalidOutput, OutcomeInvalidOutput, OutcomeSuccess},
+			wantModels:   []string{"primary", "primary", "primary"},
+			wantRepairs:  2,
+		},
+		{
+			name:         "permanent error falls back immediately",
+			primary:      []scriptedResponse{{err: errPermanent}},
+			fallback:     []scriptedResponse{valid},
+			wantOutcomes: []string{OutcomeError, OutcomeSuccess},
+			wantModels:   []string{"primary", "fallback"},
+		},
+		{
+			name:         "fallback after the attempts run out",
+			primary:      []scriptedResponse{transient, transient, transient},
+			fallback:     []scriptedResponse{valid},
+			wantOutcomes: []string{OutcomeTransientError, OutcomeTransientError, OutcomeTransientError, OutcomeSuccess},
+			wantModels:   []string{"primary", "primary", "primary", "fallback"},
+		},
+		{
+			name:         "last error is returned when every model fails",
+			primary:      []scriptedResponse{{err: errPermanent}},
+			fallback:     []scriptedResponse{{err: errPermanent}},
+			wantErr:      errPermanent,
+			wantOutcomes: []string{OutcomeError, OutcomeError},
+			wantModels:   []string{"primary", "fallback"},
+		},
+		{
+			name:         "permanent error without fallback",
+			primary:      []scriptedResponse{transient, {err: errPermanent}},
+			wantErr:      errPermanent,
+			wantOutcomes: []string{OutcomeTransientError, OutcomeError},
+			wantModels:   []string{"primary", "primary"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &scriptedModel{name: "primary", responses: tt.primary}
+			var fallback Model
+			fallbackModel := &scriptedModel{name: "fallback", responses: tt.fallback}
+			if tt.fallback != nil {
+				fallback = fallbackModel
+			}
+			s := &Service{Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Microsecond, MaxDelay: time.Microsecond}}
+
+			var attempts []Attempt
+			ctx := WithTrace(context.Background(), &Trace{OnAttempt: func(a Attempt) { attempts = append(attempts, a) }})
+			var out struct {
+				Value string `json:"value"`
+			}
+			usage, err := s.call(ctx, StageGenerateProblem, primary, fallback, []Part{Text("prompt")}, out, func(jsonOutput string) error {
+				if err := json.Unmarshal([]byte(jsonOutput), &out); err != nil {
+					return err
+				}
+				if out.Value == "" {
+					return errors.New("value is required")
+				}
+				return nil
+			})
+
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("call() error = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("call() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && out.Value != "ok" {
+				t.Errorf("decoded value = %q, want %q", out.Value, "ok")
+			}
+
+			var outcomes, names []string
+			for _, a := range attempts {
+				outcomes = append(outcomes, a.Outcome)
+				names = append(names, a.Model)
+			}
+			if strings.Join(outcomes, ",") != strings.Join(tt.wantOutcomes, ",") {
+				t.Errorf("outcomes = %v, want %v", outcomes, tt.wantOutcomes)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantModels, ",") {
+				t.Errorf("models = %v, want %v", names, tt.wantModels)
+			}
+			if want := int32(11 * len(tt.wantOutcomes)); usage.TotalTokenCount != want {
+				t.Errorf("total tokens = %d, want %d (every attempt counts)", usage.TotalTokenCount, want)
+			}
+
+			repairs := 0
+			for _, parts := range primary.prompts {
+				if len(parts) > 1 {
+					repairs++
+					if !strings.Contains(string(parts[len(parts)-1].(Text)), "前回の応答は使用できませんでした") {
+						t.Errorf("repair prompt does not explain the rejected response: %q", parts[len(parts)-1])
+					}
+				}
+			}
+			if repairs != tt.wantRepairs {
+				t.Errorf("repair prompts = %d, want %d", repairs, tt.wantRepairs)
+			}
+		})
+	}
+}
+
+func TestCallReportsUnbilledAttempts(t *testing.T) {
+	tests := []struct {
+		name     string
+		unbilled bool
+		want     bool
+	}{
+		{name: "billed model", unbilled: false, want: true},
+		{name: "unbilled model", unbilled: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &scriptedModel{name: "m", unbilled: tt.unbilled, responses: []scriptedResponse{{raw: `{}`}}}
+			var got []bool
+			ctx := WithTrace(context.Background(), &Trace{OnAttempt: func(a Attempt) { got = append(got, a.Billed) }})
+			if _, err := (&Service{}).call(ctx, StageGenerateProblem, model, nil, nil, nil, func(string) error { return nil }); err != nil {
+				t.Fatalf("call() error = %v", err)
+			}
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("Attempt.Billed = %v, want [%t]", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	model := &scriptedModel{name: "m", responses: []scriptedResponse{{err: Transient(context.Canceled)}, {raw: `{}`}}}
+	s := &Service{Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}}
+	_, err := s.call(ctx, StageGenerateProblem, model, nil, nil, nil, func(string) error { return nil })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("call() error = %v, want context.Canceled", err)
+	}
+	if len(model.prompts) != 1 {
+		t.Errorf("model was called %d times after cancellation, want 1", len(model.prompts))
+	}
+}
@@ -0,0 +1,44 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Roles of the source files of a job, as stored in problem_sources.role.
+const (
+	SourceLectureNotes = "lecture_notes"
+	SourceSampleExam   = "sample_exam"
+)
+
+// sourceRoleLabels names the source roles in the labels shown to the model.
+var sourceRoleLabels = map[string]string{
+	SourceLectureNotes: "講義資料",
+	SourceSampleExam:   "過去問・サンプル試験",
+}
+
+// sourceMarkerPrefix starts every line written by SourceMarker.
+const sourceMarkerPrefix = "=== 資料 "
+
+// SourceMarker returns the line that introduces source n of total, labelled
+// with its role and file name. Chunks are preferably split before it.
+func SourceMarker(n, total int, role, fileName string) string {
+	label, ok := sourceRoleLabels[role]
+	if !ok {
+		label = role
+	}
+	if fileName != "" {
+		label += " (" + fileName + ")"
+	}
+	return fmt.Sprintf("%s%d/%d: %s ===", sourceMarkerPrefix, n, total, label)
+}
+
+// hasSources reports whether the input consists of labelled source files.
+func hasSources(parts []Part) bool {
+	for _, p := range parts {
+		if t, ok := p.(Text); ok && strings.HasPrefix(strings.TrimLeft(string(t), "\n"), sourceMarkerPrefix) {
+			return true
+		}
+	}
+	return false
+}
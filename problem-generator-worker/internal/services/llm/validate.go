@@ -0,0 +1,137 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/models"
+)
+
+// FieldError is a single problem with a model's output, located by a JSON path
+// such as "questions[2].answer_text".
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError lists every problem found in a decoded model output. It is
+// returned from the decode step, so the model is re-prompted with the full list.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Error()
+	}
+	return "output failed validation: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (e *ValidationError) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ValidateStructure checks an extracted structure: every section has questions,
+// every question has a topic, question indices are unique and difficulties are
+// one of models.Difficulties.
+func ValidateStructure(ps *models.ProblemStructure) error {
+	verr := &ValidationError{}
+	if strings.TrimSpace(ps.ExamMeta.ExamTitle) == "" {
+		verr.add("exam_meta.exam_title", "is required")
+	}
+	if len(ps.Structure.MajorSections) == 0 {
+		verr.add("structure.major_sections", "must contain at least one section")
+	}
+	seen := map[string]string{}
+	for i, section := range ps.Structure.MajorSections {
+		if len(section.SubQuestions) == 0 {
+			verr.add(fmt.Sprintf("structure.major_sections[%d].sub_questions", i), "must contain at least one question")
+		}
+		for j, sq := range section.SubQuestions {
+			path := fmt.Sprintf("structure.major_sections[%d].sub_questions[%d]", i, j)
+			if strings.TrimSpace(sq.Topic) == "" {
+				verr.add(path+".topic", "is required")
+			}
+			checkDifficulty(verr, path+".difficulty", sq.Difficulty, true)
+			if sq.QuestionIndex != "" {
+				if first, ok := seen[sq.QuestionIndex]; ok {
+					verr.add(path+".question_index", "'%s' is already used by %s", sq.QuestionIndex, first)
+				} else {
+					seen[sq.QuestionIndex] = path
+				}
+			}
+		}
+	}
+	return verr.err()
+}
+
+// ValidateGeneration checks that there is one generated question per
+// sub-question of ps, and every question for a question and an answer text and
+// a known difficulty. Which sub-question each question answers is checked
+// after decoding by the processor's reconciliation step.
+func ValidateGeneration(gd *models.GeneratedData, ps *models.ProblemStructure) error {
+	verr := &ValidationError{}
+	expected := 0
+	for _, section := range ps.Structure.MajorSections {
+		expected += len(section.SubQuestions)
+	}
+	if len(gd.Questions) == 0 {
+		verr.add("questions", "must not be empty")
+	} else if len(gd.Questions) != expected {
+		verr.add("questions", "contains %d questions, but the structure has %d sub-questions", len(gd.Questions), expected)
+	}
+	for i := range gd.Questions {
+		validateQuestion(verr, fmt.Sprintf("questions[%d]", i), &gd.Questions[i])
+	}
+	return verr.err()
+}
+
+// ValidateQuestion checks a single regenerated question.
+func ValidateQuestion(q *models.GeneratedQuestion) error {
+	verr := &ValidationError{}
+	validateQuestion(verr, "", q)
+	return verr.err()
+}
+
+func validateQuestion(verr *ValidationError, prefix string, q *models.GeneratedQuestion) {
+	field := func(name string) string {
+		if prefix == "" {
+			return name
+		}
+		return prefix + "." + name
+	}
+	if strings.TrimSpace(q.QuestionText) == "" {
+		verr.add(field("question_text"), "is required")
+	}
+	if strings.TrimSpace(q.AnswerText) == "" {
+		verr.add(field("answer_text"), "is required")
+	}
+	checkDifficulty(verr, field("difficulty"), q.Difficulty, false)
+}
+
+func checkDifficulty(verr *ValidationError, field, value string, optional bool) {
+	if value == "" {
+		if !optional {
+			verr.add(field, "is required")
+		}
+		return
+	}
+	for _, d := range models.Difficulties {
+		if value == d {
+			return
+		}
+	}
+	verr.add(field, "'%s' is not one of %s", value, strings.Join(models.Difficulties, ", "))
+}
@@ -0,0 +1,186 @@
+package llm
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/models"
+)
+
+// invalidFields returns the fields reported by a *ValidationError, or nil.
+func invalidFields(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("error %v is not a *ValidationError", err)
+	}
+	var fields []string
+	for _, f := range verr.Fields {
+		fields = append(fields, f.Field)
+	}
+	return fields
+}
+
+func TestValidateStructure(t *testing.T) {
+	valid := func() *models.ProblemStructure {
+		return &models.ProblemStructure{
+			ExamMeta: models.ExamMeta{ExamTitle: "線形代数"},
+			Structure: models.StructureSection{MajorSections: []models.MajorSection{
+				{SectionTitle: "行列", SubQuestions: []models.SubQuestion{
+					{QuestionIndex: "1-1", Topic: "行列の積", Difficulty: models.DifficultyEasy},
+					{QuestionIndex: "1-2", Topic: "行列式"},
+				}},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(ps *models.ProblemStructure)
+		want   []string
+	}{
+		{name: "valid", modify: func(ps *models.ProblemStructure) {}},
+		{
+			name:   "missing exam title",
+			modify: func(ps *models.ProblemStructure) { ps.ExamMeta.ExamTitle = "  " },
+			want:   []string{"exam_meta.exam_title"},
+		},
+		{
+			name:   "no sections",
+			modify: func(ps *models.ProblemStructure) { ps.Structure.MajorSections = nil },
+			want:   []string{"structure.major_sections"},
+		},
+		{
+			name:   "section without questions",
+			modify: func(ps *models.ProblemStructure) { ps.Structure.MajorSections[0].SubQuestions = nil },
+			want:   []string{"structure.major_sections[0].sub_questions"},
+		},
+		{
+			name:   "missing topic",
+			modify: func(ps *models.ProblemStructure) { ps.Structure.MajorSections[0].SubQuestions[1].Topic = "" },
+			want:   []string{"structure.major_sections[0].sub_questions[1].topic"},
+		},
+		{
+			name: "unknown difficulty",
+			modify: func(ps *models.ProblemStructure) {
+				ps.Structure.MajorSections[0].SubQuestions[0].Difficulty = "trivial"
+			},
+			want: []string{"structure.major_sections[0].sub_questions[0].difficulty"},
+		},
+		{
+			name:   "duplicate question index",
+			modify: func(ps *models.ProblemStructure) { ps.Structure.MajorSections[0].SubQuestions[1].QuestionIndex = "1-1" },
+			want:   []string{"structure.major_sections[0].sub_questions[1].question_index"},
+		},
+		{
+			name: "empty question indices are not duplicates",
+			modify: func(ps *models.ProblemStructure) {
+				ps.Structure.MajorSections[0].SubQuestions[0].QuestionIndex = ""
+				ps.Structure.MajorSections[0].SubQuestions[1].QuestionIndex = ""
+			},
+		},
+		{
+			name: "every problem is reported",
+			modify: func(ps *models.ProblemStructure) {
+				ps.ExamMeta.ExamTitle = ""
+				ps.Structure.MajorSections[0].SubQuestions[0].Topic = ""
+				ps.Structure.MajorSections[0].SubQuestions[1].Difficulty = "?"
+			},
+			want: []string{
+				"exam_meta.exam_title",
+				"structure.major_sections[0].sub_questions[0].topic",
+				"structure.major_sections[0].sub_questions[1].difficulty",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := valid()
+			tt.modify(ps)
+			if got := invalidFields(t, ValidateStructure(ps)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateStructure() fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateGeneration(t *testing.T) {
+	question := func(index string) models.GeneratedQuestion {
+		return models.GeneratedQuestion{
+			QuestionIndex: index,
+			Topic:         "行列の積",
+			Difficulty:    models.DifficultyMedium,
+			QuestionText:  "次の行列の積を求めよ。",
+			AnswerText:    "$AB = I$",
+		}
+	}
+	ps := &models.ProblemStructure{Structure: models.StructureSection{MajorSections: []models.MajorSection{
+		{SubQuestions: []models.SubQuestion{{QuestionIndex: "1-1", Topic: "行列の積"}}},
+		{SubQuestions: []models.SubQuestion{{QuestionIndex: "2-1", Topic: "行列式"}}},
+	}}}
+
+	tests := []struct {
+		name   string
+		modify func(gd *models.GeneratedData)
+		want   []string
+	}{
+		{name: "valid", modify: func(gd *models.GeneratedData) {}},
+		{
+			name:   "no questions",
+			modify: func(gd *models.GeneratedData) { gd.Questions = nil },
+			want:   []string{"questions"},
+		},
+		{
+			name:   "missing question text",
+			modify: func(gd *models.GeneratedData) { gd.Questions[1].QuestionText = "\n" },
+			want:   []string{"questions[1].question_text"},
+		},
+		{
+			name:   "missing answer text",
+			modify: func(gd *models.GeneratedData) { gd.Questions[0].AnswerText = "" },
+			want:   []string{"questions[0].answer_text"},
+		},
+		{
+			name:   "difficulty is required",
+			modify: func(gd *models.GeneratedData) { gd.Questions[0].Difficulty = "" },
+			want:   []string{"questions[0].difficulty"},
+		},
+		{
+			name:   "unknown difficulty",
+			modify: func(gd *models.GeneratedData) { gd.Questions[1].Difficulty = "extreme" },
+			want:   []string{"questions[1].difficulty"},
+		},
+		{
+			name:   "fewer questions than sub-questions",
+			modify: func(gd *models.GeneratedData) { gd.Questions = gd.Questions[:1] },
+			want:   []string{"questions"},
+		},
+		{
+			name:   "more questions than sub-questions",
+			modify: func(gd *models.GeneratedData) { gd.Questions = append(gd.Questions, question("3-1")) },
+			want:   []string{"questions"},
+		},
+		{
+			// Matching questions to sub-questions is the reconciliation step's job.
+			name: "question indices are not checked",
+			modify: func(gd *models.GeneratedData) {
+				gd.Questions = []models.GeneratedQuestion{question("9-9"), question("")}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gd := &models.GeneratedData{Questions: []models.GeneratedQuestion{question("1-1"), question("2-1")}}
+			tt.modify(gd)
+			if got := invalidFields(t, ValidateGeneration(gd, ps)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateGeneration() fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+// Package openai talks to any server implementing the OpenAI chat completions API.
+// Besides OpenAI itself this covers local backends such as Ollama and the llama.cpp server.
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/llm"
+)
+
+// backend describes the environment prefix and defaults of one OpenAI-compatible server.
+type backend struct {
+	envPrefix      string
+	defaultBaseURL string
+	requiresKey    bool
+}
+
+var backends = map[string]backend{
+	"openai":   {envPrefix: "OPENAI", defaultBaseURL: "https://api.openai.com/v1", requiresKey: true},
+	"ollama":   {envPrefix: "OLLAMA", defaultBaseURL: "http://localhost:11434/v1"},
+	"llamacpp": {envPrefix: "LLAMACPP", defaultBaseURL: "http://localhost:8081/v1"},
+}
+
+// Model is a single chat model served by an OpenAI-compatible endpoint.
+type Model struct {
+	name       string
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewService builds an llm.Service for the named backend ("openai", "ollama" or "llamacpp").
+// Configuration is read from <PREFIX>_BASE_URL, <PREFIX>_API_KEY,
+// <PREFIX>_EXTRACTION_MODEL and <PREFIX>_GENERATION_MODEL, plus the optional
+// <PREFIX>_FALLBACK_EXTRACTION_MODEL and <PREFIX>_FALLBACK_GENERATION_MODEL.
+func NewService(backendName string) (*llm.Service, error) {
+	b, ok := backends[backendName]
+	if !ok {
+		return nil, fmt.Errorf("unknown OpenAI-compatible backend '%s'", backendName)
+	}
+	baseURL := os.Getenv(b.envPrefix + "_BASE_URL")
+	if baseURL == "" {
+		baseURL = b.defaultBaseURL
+	}
+	apiKey := os.Getenv(b.envPrefix + "_API_KEY")
+	if b.requiresKey && apiKey == "" {
+		return nil, fmt.Errorf("%s_API_KEY environment variable is not set", b.envPrefix)
+	}
+	extractionModelName := os.Getenv(b.envPrefix + "_EXTRACTION_MODEL")
+	generationModelName := os.Getenv(b.envPrefix + "_GENERATION_MODEL")
+	if extractionModelName == "" || generationModelName == "" {
+		return nil, fmt.Errorf("%s_EXTRACTION_MODEL and %s_GENERATION_MODEL must both be set", b.envPrefix, b.envPrefix)
+	}
+
+	httpClient := &http.Client{Timeout: 10 * time.Minute}
+	newModel := func(name string) *Model {
+		return &Model{name: name, baseURL: strings.TrimRight(baseURL, "/"), apiKey: apiKey, httpClient: httpClient}
+	}
+
+	svc := &llm.Service{
+		Extraction: newModel(extractionModelName),
+		Generation: newModel(generationModelName),
+		NewModel: func(_, name string) (llm.Model, error) {
+			return newModel(name), nil
+		},
+	}
+	if name := os.Getenv(b.envPrefix + "_FALLBACK_EXTRACTION_MODEL"); name != "" {
+		svc.ExtractionFallback = newModel(name)
+	}
+	if name := os.Getenv(b.envPrefix + "_FALLBACK_GENERATION_MODEL"); name != "" {
+		svc.GenerationFallback = newModel(name)
+	}
+
+	log.Printf("OpenAI-compatible service (%s at %s) initialized with Extraction Model: %s and Generation Model: %s", backendName, baseURL, extractionModelName, generationModelName)
+	return svc, nil
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model          string            `json:"model"`
+	Messages       []chatMessage     `json:"messages"`
+	ResponseFormat map[string]string `json:"response_format,omitempty"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int32 `json:"prompt_tokens"`
+		CompletionTokens int32 `json:"completion_tokens"`
+		TotalTokens      int32 `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+func (m *Model) Name() string { return m.name }
+
+// GenerateText sends the parts as a single user message in JSON mode and returns the reply text.
+func (m *Model) GenerateText(ctx context.Context, parts ...llm.Part) (string, *llm.Usage, error) {
+	var sb strings.Builder
+	for _, p := range parts {
+		switch v := p.(type) {
+		case llm.Text:
+			sb.WriteString(string(v))
+			sb.WriteString("\n\n")
+		case llm.Blob:
+			return "", nil, fmt.Errorf("model %s does not accept %s input; provide text instead", m.name, v.MIMEType)
+		}
+	}
+
+	reqBody, err := json.Marshal(chatRequest{
+		Model:          m.name,
+		Messages:       []chatMessage{{Role: "user", Content: sb.String()}},
+		ResponseFormat: map[string]string{"type": "json_object"},
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal chat request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.baseURL+"/chat/completions", bytes.NewReader(reqBody))
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+m.apiKey)
+	}
+
+	res, err := m.httpClient.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read chat response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		err := fmt.Errorf("chat completions request failed with status %d: %s", res.StatusCode, body)
+		if llm.IsTransientStatus(res.StatusCode) {
+			return "", nil, llm.Transient(err)
+		}
+		return "", nil, err
+	}
+
+	var chat chatResponse
+	if err := json.Unmarshal(body, &chat); err != nil {
+		return "", nil, fmt.Errorf("failed to decode chat response: %w", err)
+	}
+	usage := &llm.Usage{
+		PromptTokenCount:     chat.Usage.PromptTokens,
+		CandidatesTokenCount: chat.Usage.CompletionTokens,
+		TotalTokenCount:      chat.Usage.TotalTokens,
+	}
+	if len(chat.Choices) == 0 || chat.Choices[0].Message.Content == "" {
+		return "", usage, llm.ErrEmptyResponse
+	}
+	return chat.Choices[0].Message.Content, usage, nil
+}
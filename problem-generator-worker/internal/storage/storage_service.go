@@ -2,47 +2,199 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
-	"github.com/google/generative-ai-go/genai"
 	"github.com/lib/pq"
+	"github.com/your-username/edumint/problem-generator-worker/internal/blobstore"
 	"github.com/your-username/edumint/problem-generator-worker/internal/models"
+	"github.com/your-username/edumint/problem-generator-worker/internal/services/llm"
 )
 
-type Service struct{ DB *sql.DB }
+type Service struct {
+	DB *sql.DB
+	// Blobs holds the uploaded source files referenced from problem_sources.
+	Blobs blobstore.Store
+}
+
+var (
+	// ErrNotFound is returned when the problem row referenced by a job does not exist.
+	ErrNotFound = errors.New("problem not found")
+	// ErrNoInput is returned when a problem has neither input text nor source
+	// files, or a source file is missing from the blob store.
+	ErrNoInput = errors.New("no input data found")
+	// ErrInvalidOptions is returned when the stored generation options cannot be decoded.
+	ErrInvalidOptions = errors.New("invalid generation options")
+	// ErrQuestionNotFound is returned when a regenerated question is not part of the generated exam.
+	ErrQuestionNotFound = errors.New("question not found")
+	// ErrBudgetExceeded is returned when the tokens used in the current period
+	// reach a budget of the problem's owner, course or organization.
+	ErrBudgetExceeded = errors.New("token budget exceeded")
+)
 
+// UpdateStatus sets the overall job status. A cancelled job is never moved
+// out of 'cancelled'.
 func (s *Service) UpdateStatus(id int, status, errMsg string) error {
-	query := `UPDATE problems SET processing_status = $1, error_message = $2 WHERE id = $3`
+	query := `UPDATE problems SET processing_status = $1, error_message = $2 WHERE id = $3 AND processing_status <> 'cancelled'`
 	_, err := s.DB.Exec(query, status, errMsg, id)
 	return err
 }
 
-func (s *Service) GetInputData(id int) ([]genai.Part, error) {
+// IsCancelled reports whether the user cancelled the job.
+func (s *Service) IsCancelled(id int) (bool, error) {
+	var status string
+	err := s.DB.QueryRow(`SELECT processing_status FROM problems WHERE id = $1`, id).Scan(&status)
+	if err == sql.ErrNoRows {
+		return false, fmt.Errorf("%w: id %d", ErrNotFound, id)
+	}
+	if err != nil {
+		return false, err
+	}
+	return status == "cancelled", nil
+}
+
+// GetInputData returns the input of a problem. Uploaded source files are
+// loaded from the blob store and returned in order, each preceded by an
+// llm.SourceMarker label; otherwise the pasted text is returned.
+func (s *Service) GetInputData(ctx context.Context, id int) ([]llm.Part, error) {
+	sources, err := s.getSources(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) > 0 {
+		return sources, nil
+	}
+
 	var text sql.NullString
-	var file []byte
-	err := s.DB.QueryRow(`SELECT raw_input_text, raw_input_file FROM problems WHERE id = $1`, id).Scan(&text, &file)
+	err = s.DB.QueryRow(`SELECT raw_input_text FROM problems WHERE id = $1`, id).Scan(&text)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: id %d", ErrNotFound, id)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("could not query input data for id %d: %w", id, err)
 	}
 	if text.Valid && text.String != "" {
-		return []genai.Part{genai.Text(text.String)}, nil
+		return []llm.Part{llm.Text(text.String)}, nil
+	}
+	return nil, fmt.Errorf("%w for problem id %d", ErrNoInput, id)
+}
+
+// getSources loads the source files of a problem as labelled input parts.
+func (s *Service) getSources(ctx context.Context, id int) ([]llm.Part, error) {
+	rows, err := s.DB.Query(`SELECT role, COALESCE(file_name, ''), mime_type, content_sha256 FROM problem_sources WHERE problem_id = $1 ORDER BY position`, id)
+	if err != nil {
+		return nil, fmt.Errorf("could not query sources for id %d: %w", id, err)
+	}
+	defer rows.Close()
+
+	type source struct {
+		role, fileName, mimeType, key string
 	}
-	if len(file) > 0 {
-		return []genai.Part{genai.Blob{MIMEType: "application/pdf", Data: file}}, nil
+	var sources []source
+	for rows.Next() {
+		var src source
+		if err := rows.Scan(&src.role, &src.fileName, &src.mimeType, &src.key); err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
 	}
-	return nil, fmt.Errorf("no input data found for problem id %d", id)
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var parts []llm.Part
+	for i, src := range sources {
+		data, err := s.Blobs.Get(ctx, src.key)
+		if errors.Is(err, blobstore.ErrNotFound) {
+			return nil, fmt.Errorf("%w: source '%s' of problem id %d: %v", ErrNoInput, src.fileName, id, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not load source '%s' of problem id %d: %w", src.fileName, id, err)
+		}
+		marker := llm.SourceMarker(i+1, len(sources), src.role, src.fileName) + "\n"
+		if i > 0 {
+			marker = "\n\n" + marker
+		}
+		parts = append(parts, llm.Text(marker), llm.Blob{MIMEType: src.mimeType, Data: data})
+	}
+	return parts, nil
 }
 
-// !! 修正: 引数とクエリを新しいデータ構造に合わせる
-func (s *Service) SaveResult(id int, ps *models.ProblemStructure, gp *models.GeneratedData, st, gt *genai.UsageMetadata) error {
-	majorSectionsJSON, err := json.Marshal(ps.Structure.MajorSections)
+// SetInputMethod records how the input of a problem was passed to the model.
+func (s *Service) SetInputMethod(id int, method string) error {
+	_, err := s.DB.Exec(`UPDATE problems SET input_method = $1 WHERE id = $2`, method, id)
+	return err
+}
+
+// GetGenerationOptions returns the options the job was submitted with.
+func (s *Service) GetGenerationOptions(id int) (models.GenerationOptions, error) {
+	var opts models.GenerationOptions
+	var raw []byte
+	err := s.DB.QueryRow(`SELECT generation_options FROM problems WHERE id = $1`, id).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return opts, fmt.Errorf("%w: id %d", ErrNotFound, id)
+	}
 	if err != nil {
-		return err
+		return opts, fmt.Errorf("could not query generation options for id %d: %w", id, err)
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &opts); err != nil {
+			return opts, fmt.Errorf("%w for problem id %d: %v", ErrInvalidOptions, id, err)
+		}
 	}
+	return opts, nil
+}
 
-	generatedQuestionsJSON, err := json.Marshal(gp)
+// IsStructureApproved reports whether the user approved the structure of a job
+// submitted in review mode.
+func (s *Service) IsStructureApproved(id int) (bool, error) {
+	var approvedAt sql.NullTime
+	err := s.DB.QueryRow(`SELECT structure_approved_at FROM problems WHERE id = $1`, id).Scan(&approvedAt)
+	if err == sql.ErrNoRows {
+		return false, fmt.Errorf("%w: id %d", ErrNotFound, id)
+	}
+	if err != nil {
+		return false, err
+	}
+	return approvedAt.Valid, nil
+}
+
+// Stage status values, mirroring the stage_status enum.
+const (
+	StagePending   = "pending"
+	StageRunning   = "running"
+	StageCompleted = "completed"
+	StageFailed    = "failed"
+	StageSkipped   = "skipped"
+	StageCancelled = "cancelled"
+)
+
+// stageColumns maps processing stages to their status column on problems.
+var stageColumns = map[string]string{
+	llm.StageExtractStructure: "extraction_status",
+	llm.StageGenerateProblem:  "generation_status",
+}
+
+// UpdateStageStatus records the status of a single processing stage.
+func (s *Service) UpdateStageStatus(id int, stage, status string) error {
+	column, ok := stageColumns[stage]
+	if !ok {
+		return fmt.Errorf("unknown stage '%s'", stage)
+	}
+	query := fmt.Sprintf(`UPDATE problems SET %s = $1 WHERE id = $2`, column)
+	_, err := s.DB.Exec(query, status, id)
+	return err
+}
+
+// SaveStructure checkpoints the extracted structure and its token usage and
+// marks the extraction stage as completed, so that a retried job can resume
+// from generation.
+func (s *Service) SaveStructure(id int, ps *models.ProblemStructure, st *llm.Usage) error {
+	majorSectionsJSON, err := json.Marshal(ps.Structure.MajorSections)
 	if err != nil {
 		return err
 	}
@@ -56,17 +208,324 @@ func (s *Service) SaveResult(id int, ps *models.ProblemStructure, gp *models.Gen
 	query := `UPDATE problems SET
 		exam_title = $1, duration_minutes = $2, is_open_book = $3, allowed_materials = $4,
 		question_format_is_latex = $5, answer_format_is_latex = $6, major_sections = $7,
-		generated_questions = $8,
-		structure_prompt_tokens = $9, structure_candidates_tokens = $10,
-		generation_prompt_tokens = $11, generation_candidates_tokens = $12
-		WHERE id = $13`
+		structure_prompt_tokens = $8, structure_candidates_tokens = $9,
+		extraction_status = 'completed'
+		WHERE id = $10`
 
 	_, err = s.DB.Exec(query,
 		ps.ExamMeta.ExamTitle, duration, ps.ExamMeta.OpenBook, pq.StringArray(ps.ExamMeta.AllowedMaterials),
 		ps.ExamMeta.QuestionFormatIsLatex, ps.ExamMeta.AnswerFormatIsLatex, majorSectionsJSON,
-		generatedQuestionsJSON,
-		st.PromptTokenCount, st.CandidatesTokenCount,
-		gt.PromptTokenCount, gt.CandidatesTokenCount, id,
+		st.PromptTokenCount, st.CandidatesTokenCount, id,
 	)
 	return err
 }
+
+// LoadStructure returns the checkpointed structure of a problem. The boolean is
+// false when extraction has not completed yet.
+func (s *Service) LoadStructure(id int) (*models.ProblemStructure, bool, error) {
+	var stageStatus string
+	var examTitle sql.NullString
+	var duration sql.NullInt64
+	var openBook, questionLatex, answerLatex sql.NullBool
+	var allowedMaterials pq.StringArray
+	var majorSections []byte
+
+	query := `SELECT extraction_status, exam_title, duration_minutes, is_open_book, allowed_materials,
+		question_format_is_latex, answer_format_is_latex, major_sections
+		FROM problems WHERE id = $1`
+	err := s.DB.QueryRow(query, id).Scan(&stageStatus, &examTitle, &duration, &openBook, &allowedMaterials,
+		&questionLatex, &answerLatex, &majorSections)
+	if err == sql.ErrNoRows {
+		return nil, false, fmt.Errorf("%w: id %d", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("could not query structure for id %d: %w", id, err)
+	}
+	if stageStatus != StageCompleted && stageStatus != StageSkipped {
+		return nil, false, nil
+	}
+
+	ps := &models.ProblemStructure{
+		ExamMeta: models.ExamMeta{
+			ExamTitle:             examTitle.String,
+			OpenBook:              openBook.Bool,
+			AllowedMaterials:      allowedMaterials,
+			QuestionFormatIsLatex: questionLatex.Bool,
+			AnswerFormatIsLatex:   answerLatex.Bool,
+		},
+	}
+	if duration.Valid {
+		d := int(duration.Int64)
+		ps.ExamMeta.ExamDuration = &d
+	}
+	if err := json.Unmarshal(majorSections, &ps.Structure.MajorSections); err != nil {
+		return nil, false, fmt.Errorf("checkpointed major_sections for id %d are invalid: %w", id, err)
+	}
+	return ps, true, nil
+}
+
+// SaveGeneration stores the generated questions and the coverage report, adds
+// their token usage to the usage recorded by AddGenerationUsage, and marks the
+// generation stage as completed.
+func (s *Service) SaveGeneration(id int, gp *models.GeneratedData, gt *llm.Usage, coverage *models.CoverageReport) error {
+	generatedQuestionsJSON, err := json.Marshal(gp)
+	if err != nil {
+		return err
+	}
+	coverageJSON, err := json.Marshal(coverage)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE problems SET
+		generated_questions = $1,
+		generation_prompt_tokens = COALESCE(generation_prompt_tokens, 0) + $2,
+		generation_candidates_tokens = COALESCE(generation_candidates_tokens, 0) + $3,
+		coverage_report = $4,
+		generation_status = 'completed'
+		WHERE id = $5`
+
+	_, err = s.DB.Exec(query, generatedQuestionsJSON, gt.PromptTokenCount, gt.CandidatesTokenCount, coverageJSON, id)
+	return err
+}
+
+// AddGenerationUsage records tokens spent in the generation stage by an
+// attempt that did not finish, such as questions filled in before a transient
+// error. Unlike sections, these requests have no checkpoint to hold them.
+func (s *Service) AddGenerationUsage(id int, usage *llm.Usage) error {
+	if usage == nil {
+		return nil
+	}
+	query := `UPDATE problems SET
+		generation_prompt_tokens = COALESCE(generation_prompt_tokens, 0) + $1,
+		generation_candidates_tokens = COALESCE(generation_candidates_tokens, 0) + $2
+		WHERE id = $3`
+	_, err := s.DB.Exec(query, usage.PromptTokenCount, usage.CandidatesTokenCount, id)
+	return err
+}
+
+// SectionResult is the checkpointed generation result of one major section.
+// StructureHash identifies the section it was generated from.
+type SectionResult struct {
+	Position      int
+	StructureHash string
+	Generated     *models.GeneratedData
+	Usage         llm.Usage
+}
+
+// LoadSectionResults returns the sections of a problem that were already
+// generated successfully, keyed by their position in the structure.
+func (s *Service) LoadSectionResults(id int) (map[int]*SectionResult, error) {
+	query := `SELECT position, section_hash, generated_questions, prompt_tokens, candidates_tokens
+		FROM section_generations
+		WHERE problem_id = $1 AND status = 'completed'`
+	rows, err := s.DB.Query(query, id)
+	if err != nil {
+		return nil, fmt.Errorf("could not query section results for id %d: %w", id, err)
+	}
+	defer rows.Close()
+
+	results := map[int]*SectionResult{}
+	for rows.Next() {
+		r := &SectionResult{Generated: &models.GeneratedData{}}
+		var structureHash sql.NullString
+		var raw []byte
+		if err := rows.Scan(&r.Position, &structureHash, &raw, &r.Usage.PromptTokenCount, &r.Usage.CandidatesTokenCount); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, r.Generated); err != nil {
+			return nil, fmt.Errorf("checkpointed section %d of id %d is invalid: %w", r.Position, id, err)
+		}
+		r.StructureHash = structureHash.String
+		r.Usage.TotalTokenCount = r.Usage.PromptTokenCount + r.Usage.CandidatesTokenCount
+		results[r.Position] = r
+	}
+	return results, rows.Err()
+}
+
+// StartSection marks one section of a problem as being generated from the
+// section with the given structure hash.
+func (s *Service) StartSection(id, position int, sectionIndex, structureHash string) error {
+	query := `INSERT INTO section_generations (problem_id, position, section_index, section_hash, status, started_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, 'running', NOW())
+		ON CONFLICT (problem_id, position) DO UPDATE SET
+			section_index = EXCLUDED.section_index, section_hash = EXCLUDED.section_hash,
+			status = 'running', error_message = NULL, started_at = NOW(), completed_at = NULL`
+	_, err := s.DB.Exec(query, id, position, sectionIndex, structureHash)
+	return err
+}
+
+// SaveSectionResult checkpoints the questions generated for one section and
+// their token usage.
+func (s *Service) SaveSectionResult(id, position int, gd *models.GeneratedData, usage *llm.Usage) error {
+	generatedJSON, err := json.Marshal(gd)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE section_generations SET
+		status = 'completed', error_message = NULL, generated_questions = $1,
+		prompt_tokens = prompt_tokens + $2, candidates_tokens = candidates_tokens + $3, completed_at = NOW()
+		WHERE problem_id = $4 AND position = $5`
+	_, err = s.DB.Exec(query, generatedJSON, usage.PromptTokenCount, usage.CandidatesTokenCount, id, position)
+	return err
+}
+
+// FailSection records a section whose generation failed (or was cancelled)
+// along with the tokens spent on it.
+func (s *Service) FailSection(id, position int, status, errMsg string, usage *llm.Usage) error {
+	if usage == nil {
+		usage = &llm.Usage{}
+	}
+	query := `UPDATE section_generations SET
+		status = $1, error_message = NULLIF($2, ''),
+		prompt_tokens = prompt_tokens + $3, candidates_tokens = candidates_tokens + $4, completed_at = NOW()
+		WHERE problem_id = $5 AND position = $6`
+	_, err := s.DB.Exec(query, status, errMsg, usage.PromptTokenCount, usage.CandidatesTokenCount, id, position)
+	return err
+}
+
+// LoadGeneration returns the generated exam of a problem.
+func (s *Service) LoadGeneration(id int) (*models.GeneratedData, error) {
+	var raw []byte
+	err := s.DB.QueryRow(`SELECT generated_questions FROM problems WHERE id = $1`, id).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: id %d", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not query generated questions for id %d: %w", id, err)
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("%w: problem id %d has no generated questions", ErrQuestionNotFound, id)
+	}
+	var gd models.GeneratedData
+	if err := json.Unmarshal(raw, &gd); err != nil {
+		return nil, fmt.Errorf("generated questions for id %d are invalid: %w", id, err)
+	}
+	return &gd, nil
+}
+
+// Regeneration is a request to generate one question of a problem again.
+type Regeneration struct {
+	ID            int
+	ProblemID     int
+	QuestionIndex string
+	Instructions  string
+}
+
+// GetRegeneration loads a question regeneration request.
+func (s *Service) GetRegeneration(id int) (*Regeneration, error) {
+	r := &Regeneration{ID: id}
+	var instructions sql.NullString
+	query := `SELECT problem_id, question_index, instructions FROM question_regenerations WHERE id = $1`
+	err := s.DB.QueryRow(query, id).Scan(&r.ProblemID, &r.QuestionIndex, &instructions)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: regeneration id %d", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not query regeneration %d: %w", id, err)
+	}
+	r.Instructions = instructions.String
+	return r, nil
+}
+
+// UpdateRegenerationStatus sets the status (a stage status) of a regeneration request.
+func (s *Service) UpdateRegenerationStatus(id int, status, errMsg string) error {
+	query := `UPDATE question_regenerations SET status = $1, error_message = NULLIF($2, '') WHERE id = $3`
+	_, err := s.DB.Exec(query, status, errMsg, id)
+	return err
+}
+
+// SaveRegeneratedQuestion replaces one question of the generated exam and
+// completes the regeneration request with its token usage. The previous
+// question is kept on the request for reference.
+func (s *Service) SaveRegeneratedQuestion(r *Regeneration, q *models.GeneratedQuestion, usage *llm.Usage) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the row so concurrent regenerations of different questions do not overwrite each other.
+	var raw []byte
+	if err := tx.QueryRow(`SELECT generated_questions FROM problems WHERE id = $1 FOR UPDATE`, r.ProblemID).Scan(&raw); err != nil {
+		return err
+	}
+	var gd models.GeneratedData
+	if err := json.Unmarshal(raw, &gd); err != nil {
+		return fmt.Errorf("generated questions for id %d are invalid: %w", r.ProblemID, err)
+	}
+	i := gd.FindQuestion(r.QuestionIndex)
+	if i < 0 {
+		return fmt.Errorf("%w: '%s' in problem id %d", ErrQuestionNotFound, r.QuestionIndex, r.ProblemID)
+	}
+	previousJSON, err := json.Marshal(gd.Questions[i])
+	if err != nil {
+		return err
+	}
+	questionJSON, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	gd.Questions[i] = *q
+	generatedJSON, err := json.Marshal(gd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE problems SET generated_questions = $1 WHERE id = $2`, generatedJSON, r.ProblemID); err != nil {
+		return err
+	}
+	query := `UPDATE question_regenerations SET
+		status = 'completed', error_message = NULL,
+		previous_question = $1, new_question = $2,
+		prompt_tokens = $3, candidates_tokens = $4, completed_at = NOW()
+		WHERE id = $5`
+	if _, err := tx.Exec(query, previousJSON, questionJSON, usage.PromptTokenCount, usage.CandidatesTokenCount, r.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordAttempt stores one model attempt made while processing a problem.
+func (s *Service) RecordAttempt(id int, a llm.Attempt) error {
+	var errMsg sql.NullString
+	if a.Err != nil {
+		errMsg = sql.NullString{String: a.Err.Error(), Valid: true}
+	}
+	var promptTokens, candidatesTokens sql.NullInt64
+	if a.Usage != nil {
+		promptTokens = sql.NullInt64{Int64: int64(a.Usage.PromptTokenCount), Valid: true}
+		candidatesTokens = sql.NullInt64{Int64: int64(a.Usage.CandidatesTokenCount), Valid: true}
+	}
+
+	query := `INSERT INTO llm_attempts
+		(problem_id, stage, model, attempt, outcome, error_message, prompt_tokens, candidates_tokens, duration_ms, billed)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	_, err := s.DB.Exec(query, id, a.Stage, a.Model, a.Number, a.Outcome, errMsg,
+		promptTokens, candidatesTokens, a.Duration.Milliseconds(), a.Billed)
+	return err
+}
+
+// CheckBudget returns an error wrapping ErrBudgetExceeded if the owner, course
+// or organization of a problem has used up one of their budgets in the current
+// period, the same condition under which the gateway refuses new jobs.
+// Budgets are defined in token_budgets.
+func (s *Service) CheckBudget(id int) error {
+	var scope, period string
+	var scopeID int
+	var limit, used int64
+	var resetsAt time.Time
+	query := `SELECT b.scope, b.scope_id, b.period, b.token_limit, b.used_tokens, b.resets_at
+		FROM problems p, token_budget_usage(p.owner_id, p.course_id) b
+		WHERE p.id = $1 AND b.used_tokens >= b.token_limit
+		ORDER BY b.resets_at DESC LIMIT 1`
+	err := s.DB.QueryRow(query, id).Scan(&scope, &scopeID, &period, &limit, &used, &resetsAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %d of the %s budget of %d tokens for %s %d are used (resets at %s)",
+		ErrBudgetExceeded, used, period, limit, scope, scopeID, resetsAt.UTC().Format(time.RFC3339))
+}
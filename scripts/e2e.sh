@@ -0,0 +1,365 @@
+#!/bin/sh
+# Runs a few jobs through the gateway against the fake LLM provider and checks
+# their final status. Start the stack first with docker-compose.e2e.yml.
+set -eu
+
+API_URL="${API_URL:-http://localhost:8080/api/v1}"
+TIMEOUT="${TIMEOUT:-60}"
+# API_KEY is the AUTH_BOOTSTRAP_API_KEY of docker-compose.e2e.yml; OIDC_URL is its local issuer.
+API_KEY="${API_KEY:-edm_e2e-bootstrap-key-0123456789}"
+OIDC_URL="${OIDC_URL:-http://localhost:8090/default}"
+
+# Every request below is authenticated with the bootstrap key unless it calls "command curl".
+curl() {
+	command curl -H "Authorization: Bearer $API_KEY" "$@"
+}
+
+# submit <input text> [extra JSON fields] prints the queued problem ID.
+submit() {
+	curl -sf -X POST "$API_URL/generate" -H 'Content-Type: application/json' --data "{\"text\": \"$1\"${2:+, $2}}" |
+		sed -n 's/.*"problem_id":\([0-9]*\).*/\1/p'
+}
+
+# wait_for <problem id> prints the final status of the job, or awaiting_review
+# for jobs that paused for review.
+wait_for() {
+	i=0
+	while [ "$i" -lt "$TIMEOUT" ]; do
+		status=$(curl -sf "$API_URL/problems/$1/status" | sed -n 's/.*"status":"\([a-z_]*\)".*/\1/p')
+		case "$status" in
+		completed | failed | cancelled | awaiting_review)
+			echo "$status"
+			return
+			;;
+		esac
+		sleep 1
+		i=$((i + 1))
+	done
+	echo "timeout"
+}
+
+failures=0
+
+# expect <name> <input text> <expected status>
+expect() {
+	id=$(submit "$2")
+	if [ -z "$id" ]; then
+		echo "FAIL $1: job was not accepted"
+		failures=$((failures + 1))
+		return
+	fi
+	got=$(wait_for "$id")
+	if [ "$got" = "$3" ]; then
+		echo "ok   $1 (problem $id: $got)"
+	else
+		echo "FAIL $1 (problem $id): expected $3, got $got"
+		failures=$((failures + 1))
+	fi
+}
+
+expect "happy path" "線形代数の講義ノート" completed
+
+# Regenerate one question of the completed happy path job ($id is set by expect).
+curl -sf -X POST "$API_URL/problems/$id/questions/1-2/regenerate" -H 'Content-Type: application/json' \
+	--data '{"instructions": "もっと難しくしてください"}' >/dev/null
+i=0
+regen=""
+while [ "$i" -lt "$TIMEOUT" ]; do
+	regen=$(curl -sf "$API_URL/problems/$id/regenerations" | sed -n 's/.*"status":"\([a-z]*\)".*/\1/p')
+	case "$regen" in completed | failed) break ;; esac
+	sleep 1
+	i=$((i + 1))
+done
+case "$regen:$(curl -sf "$API_URL/problems/$id/status")" in
+completed:*'別の問題'*) echo "ok   single question regeneration (problem $id)" ;;
+*)
+	echo "FAIL single question regeneration (problem $id): regeneration status '$regen'"
+	failures=$((failures + 1))
+	;;
+esac
+expect "malformed structure" "fake:extract=malformed" failed
+expect "empty structure response" "fake:extract=empty" failed
+expect "generation error" "fake:generate=error" failed
+expect "malformed generation" "fake:generate=malformed" failed
+expect "transient error exhausts retries" "fake:extract=unavailable" failed
+
+# A generation that drops a sub-question and invents another is reconciled with the structure.
+expect "partial generation" "fake:generate=partial" completed
+result=$(curl -sf "$API_URL/problems/$id/status")
+if echo "$result" | grep -q '"filled":\[{[^]]*"2-2"' && echo "$result" | grep -q '"complete":true'; then
+	echo "ok   missing question filled in (problem $id)"
+else
+	echo "FAIL missing question filled in (problem $id): unexpected coverage report"
+	failures=$((failures + 1))
+fi
+
+id=$(submit "オプション付きのジョブ" '"num_questions": 4, "difficulty": "hard", "language": "en", "latex": false, "model": "fake-custom"')
+got=$(wait_for "$id")
+attempts=$(curl -sf "$API_URL/admin/problems/$id/attempts")
+case "$got:$attempts" in
+completed:*'"model":"fake-custom"'*) echo "ok   generation options (problem $id: $got)" ;;
+*)
+	echo "FAIL generation options (problem $id): got $got, attempts $attempts"
+	failures=$((failures + 1))
+	;;
+esac
+
+code=$(curl -s -o /dev/null -w '%{http_code}' -X POST "$API_URL/generate" -H 'Content-Type: application/json' \
+	--data '{"text": "x", "num_questions": 999, "difficulty": "impossible"}')
+if [ "$code" = "400" ]; then
+	echo "ok   invalid options are rejected"
+else
+	echo "FAIL invalid options: expected 400, got $code"
+	failures=$((failures + 1))
+fi
+
+id=$(curl -sf -X POST "$API_URL/generate/blueprint" -H 'Content-Type: application/json' --data '{
+	"exam_meta": {"exam_title": "設計図からの試験", "open_book": false, "question_format_is_latex": true, "answer_format_is_latex": true},
+	"structure": {"major_sections": [{"section_index": "1", "section_title": "微分", "sub_questions": [
+		{"question_index": "1-1", "topic": "導関数の定義", "difficulty": "easy"},
+		{"question_index": "1-2", "topic": "連鎖律", "difficulty": "medium"}]}]}
+}' | sed -n 's/.*"problem_id":\([0-9]*\).*/\1/p')
+got=$(wait_for "$id")
+attempts=$(curl -sf "$API_URL/admin/problems/$id/attempts")
+case "$got:$attempts" in
+*'"stage":"extract_structure"'*) echo "FAIL blueprint (problem $id): extraction ran: $attempts"; failures=$((failures + 1)) ;;
+completed:*) echo "ok   blueprint skips extraction (problem $id: $got)" ;;
+*)
+	echo "FAIL blueprint (problem $id): expected completed, got $got"
+	failures=$((failures + 1))
+	;;
+esac
+
+id=$(submit "レビューするジョブ" '"review": true')
+got=$(wait_for "$id")
+if [ "$got" = "awaiting_review" ]; then
+	structure=$(curl -sf "$API_URL/problems/$id/structure" | sed 's/"exam_title":"[^"]*"/"exam_title":"レビュー済みの試験"/')
+	curl -sf -X PUT "$API_URL/problems/$id/structure" -H 'Content-Type: application/json' --data "$structure" >/dev/null
+	curl -sf -X POST "$API_URL/problems/$id/structure/approve" >/dev/null
+	got=$(wait_for "$id")
+fi
+case "$got:$(curl -sf "$API_URL/problems/$id/status")" in
+completed:*'レビュー済みの試験'*) echo "ok   review and approval (problem $id: $got)" ;;
+*)
+	echo "FAIL review and approval (problem $id): expected completed with the edited title, got $got"
+	failures=$((failures + 1))
+	;;
+esac
+
+id=$(submit "キャンセルされるジョブ")
+curl -sf -X POST "$API_URL/problems/$id/cancel" >/dev/null
+got=$(wait_for "$id")
+if [ "$got" = "cancelled" ]; then
+	echo "ok   cancellation (problem $id: $got)"
+else
+	echo "FAIL cancellation (problem $id): expected cancelled, got $got"
+	failures=$((failures + 1))
+fi
+
+# Submitting the same input again with cache=full reuses the completed result without a new job run.
+first=$(submit "キャッシュされる入力" '"num_questions": 2')
+wait_for "$first" >/dev/null
+id=$(submit "キャッシュされる入力" '"num_questions": 2, "cache": "full"')
+result=$(curl -sf "$API_URL/problems/$id/status")
+# The newest history entry is the cached job.
+saved=$(curl -sf "$API_URL/admin/history" | sed -n 's/^\[{"id":'"$id"',[^}]*"saved_tokens":\([0-9]*\)}.*/\1/p')
+case "$result" in
+*'"status":"completed"'*'"cache_hit":"full"'* | *'"cache_hit":"full"'*'"status":"completed"'*)
+	if [ "${saved:-0}" -gt 0 ]; then
+		echo "ok   cached result reused (problem $id from $first, $saved tokens saved)"
+	else
+		echo "FAIL cached result (problem $id): no saved tokens recorded"
+		failures=$((failures + 1))
+	fi
+	;;
+*)
+	echo "FAIL cached result (problem $id): $result"
+	failures=$((failures + 1))
+	;;
+esac
+
+# In review mode the cache only reuses the structure, and the job still waits for approval.
+id=$(submit "キャッシュされる入力" '"num_questions": 2, "cache": "full", "review": true')
+result=$(curl -sf "$API_URL/problems/$id/status")
+case "$result" in
+*'"status":"awaiting_review"'*'"cache_hit":"structure"'* | *'"cache_hit":"structure"'*'"status":"awaiting_review"'*)
+	echo "ok   cached structure awaits review (problem $id)"
+	;;
+*)
+	echo "FAIL cached structure in review mode (problem $id): $result"
+	failures=$((failures + 1))
+	;;
+esac
+
+# Several source files with roles are stored in order and extracted together.
+tmp=$(mktemp -d)
+printf '# 第1回 微分\n導関数の定義と連鎖律\n' >"$tmp/notes.md"
+printf '問1 次の関数を微分せよ。\n' >"$tmp/past.txt"
+id=$(curl -sf -X POST "$API_URL/generate" -F "file=@$tmp/notes.md" -F "file=@$tmp/past.txt" -F "roles=lecture_notes,sample_exam" |
+	sed -n 's/.*"problem_id":\([0-9]*\).*/\1/p')
+rm -r "$tmp"
+got=$(wait_for "$id")
+sources=$(curl -sf "$API_URL/problems/$id/sources")
+case "$got:$sources" in
+completed:*'"role":"lecture_notes"'*'"role":"sample_exam"'*) echo "ok   multiple source files (problem $id: $got)" ;;
+*)
+	echo "FAIL multiple source files (problem $id): got $got, sources $sources"
+	failures=$((failures + 1))
+	;;
+esac
+
+# Requests without credentials, or with a revoked key, are rejected.
+code=$(command curl -s -o /dev/null -w '%{http_code}' "$API_URL/admin/history")
+if [ "$code" = "401" ]; then
+	echo "ok   unauthenticated request is rejected"
+else
+	echo "FAIL unauthenticated request: expected 401, got $code"
+	failures=$((failures + 1))
+fi
+
+key=$(curl -sf -X POST "$API_URL/api-keys" -H 'Content-Type: application/json' --data '{"name": "e2e"}')
+key_id=$(echo "$key" | sed -n 's/.*"id":\([0-9]*\).*/\1/p')
+key=$(echo "$key" | sed -n 's/.*"key":"\([^"]*\)".*/\1/p')
+before=$(command curl -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer $key" "$API_URL/me")
+curl -sf -X DELETE "$API_URL/api-keys/$key_id" >/dev/null
+after=$(command curl -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer $key" "$API_URL/me")
+if [ "$before:$after" = "200:401" ]; then
+	echo "ok   API key creation and revocation"
+else
+	echo "FAIL API key lifecycle: expected 200 then 401, got $before then $after"
+	failures=$((failures + 1))
+fi
+
+# Event streams accept a single-use stream ticket in the URL, but never an API key.
+# The stream stays open, so curl times out after reading the status code.
+stream() {
+	command curl -s -o /dev/null -w '%{http_code}' --max-time 2 -H 'Accept: text/event-stream' "$API_URL/admin/events?$1" || true
+}
+ticket=$(curl -sf -X POST "$API_URL/stream-tickets" -H 'Content-Type: application/json' --data '{"path": "/api/v1/admin/events"}' |
+	sed -n 's/.*"ticket":"\([^"]*\)".*/\1/p')
+first=$(stream "ticket=$ticket")
+again=$(stream "ticket=$ticket")
+raw=$(stream "access_token=$API_KEY")
+if [ "$first:$again:$raw" = "200:401:401" ]; then
+	echo "ok   stream tickets are single-use and API keys are rejected in URLs"
+else
+	echo "FAIL stream tickets: expected 200:401:401, got $first:$again:$raw"
+	failures=$((failures + 1))
+fi
+
+token=$(command curl -sf -X POST "$OIDC_URL/token" -d grant_type=client_credentials -d client_id=e2e-client -d client_secret=secret -d scope=edumint |
+	sed -n 's/.*"access_token" *: *"\([^"]*\)".*/\1/p')
+me=$(command curl -sf -H "Authorization: Bearer $token" "$API_URL/me")
+case "$me" in
+*'"method":"oidc"'*) echo "ok   OIDC token from the local issuer" ;;
+*)
+	echo "FAIL OIDC token: $me"
+	failures=$((failures + 1))
+	;;
+esac
+
+# The OIDC caller is a new student: admin endpoints are forbidden and the
+# bootstrap admin's problems are hidden, while their own problems are visible.
+student() {
+	command curl -H "Authorization: Bearer $token" "$@"
+}
+admin_code=$(student -s -o /dev/null -w '%{http_code}' "$API_URL/admin/history")
+other_code=$(student -s -o /dev/null -w '%{http_code}' "$API_URL/problems/$id/status")
+own=$(student -sf -X POST "$API_URL/generate" -H 'Content-Type: application/json' --data '{"text": "学生のジョブ"}' |
+	sed -n 's/.*"problem_id":\([0-9]*\).*/\1/p')
+own_code=$(student -s -o /dev/null -w '%{http_code}' "$API_URL/problems/$own/status")
+if [ "$admin_code:$other_code:$own_code" = "403:404:200" ]; then
+	echo "ok   student sees only their own problems"
+else
+	echo "FAIL student access: expected 403:404:200, got $admin_code:$other_code:$own_code"
+	failures=$((failures + 1))
+fi
+
+# An admin promotes the student to instructor.
+user_id=$(echo "$me" | sed -n 's/.*"user_id":\([0-9]*\).*/\1/p')
+curl -sf -X PUT "$API_URL/admin/users/$user_id/role" -H 'Content-Type: application/json' --data '{"role": "instructor"}' >/dev/null
+case "$(student -sf "$API_URL/me")" in
+*'"role":"instructor"'*) echo "ok   role change by an admin" ;;
+*)
+	echo "FAIL role change: user $user_id is not an instructor"
+	failures=$((failures + 1))
+	;;
+esac
+
+# The admin creates an organization with two courses and enrolls the OIDC user
+# in one of them. Their problem in that course shows up in the course's history
+# and usage, while the other course and the course staff endpoints stay closed.
+json_id() {
+	sed -n 's/.*"id":\([0-9]*\).*/\1/p'
+}
+org=$(curl -sf -X POST "$API_URL/admin/organizations" -H 'Content-Type: application/json' \
+	--data '{"name": "E2E大学", "slug": "e2e-'"$(date +%s)"'"}' | json_id)
+course=$(curl -sf -X POST "$API_URL/organizations/$org/courses" -H 'Content-Type: application/json' --data '{"code": "MATH101", "name": "線形代数"}' | json_id)
+other=$(curl -sf -X POST "$API_URL/organizations/$org/courses" -H 'Content-Type: application/json' --data '{"code": "MATH102", "name": "解析学"}' | json_id)
+curl -sf -X PUT "$API_URL/organizations/$org/members/$user_id" -H 'Content-Type: application/json' --data '{"role": "member"}' >/dev/null
+curl -sf -X PUT "$API_URL/organizations/$org/courses/$course/members/$user_id" -H 'Content-Type: application/json' --data '{"role": "student"}' >/dev/null
+in_course=$(student -sf -X POST "$API_URL/generate" -H 'Content-Type: application/json' --data '{"text": "コースのジョブ", "course_id": '"$course"'}' |
+	sed -n 's/.*"problem_id":\([0-9]*\).*/\1/p')
+other_code=$(student -s -o /dev/null -w '%{http_code}' -X POST "$API_URL/generate" -H 'Content-Type: application/json' --data '{"text": "x", "course_id": '"$other"'}')
+staff_code=$(student -s -o /dev/null -w '%{http_code}' "$API_URL/courses/$course/history")
+history=$(curl -sf "$API_URL/admin/history?course_id=$course")
+usage=$(curl -sf "$API_URL/admin/usage?course_id=$course")
+case "$other_code:$staff_code:$history:$usage" in
+404:404:'[{"id":'"$in_course"',"course_id":'"$course"','*:*'"problems":1,'*) echo "ok   course scoping (problem $in_course in course $course)" ;;
+*)
+	echo "FAIL course scoping: other course $other_code, staff endpoint $staff_code, history $history, usage $usage"
+	failures=$((failures + 1))
+	;;
+esac
+
+# An admin of one organization cannot pull in or look at users of another:
+# members are added by invitation, and only the invited email can accept it.
+curl -sf -X PUT "$API_URL/organizations/$org/members/$user_id" -H 'Content-Type: application/json' --data '{"role": "admin"}' >/dev/null
+admin_id=$(curl -sf "$API_URL/me" | sed -n 's/.*"user_id":\([0-9]*\).*/\1/p')
+org_b=$(curl -sf -X POST "$API_URL/admin/organizations" -H 'Content-Type: application/json' \
+	--data '{"name": "別の大学", "slug": "e2e-b-'"$(date +%s)"'"}' | json_id)
+curl -sf -X PUT "$API_URL/organizations/$org_b/members/$admin_id" -H 'Content-Type: application/json' --data '{"role": "member"}' >/dev/null
+add_code=$(student -s -o /dev/null -w '%{http_code}' -X PUT "$API_URL/organizations/$org/members/$admin_id" -H 'Content-Type: application/json' --data '{"role": "member"}')
+view_code=$(student -s -o /dev/null -w '%{http_code}' "$API_URL/organizations/$org_b/members")
+members=$(student -sf "$API_URL/organizations/$org/members")
+invitation=$(curl -sf -X POST "$API_URL/organizations/$org_b/invitations" -H 'Content-Type: application/json' --data '{"email": "Student@e2e.example"}' |
+	sed -n 's/.*"token":"\([^"]*\)".*/\1/p')
+wrong_code=$(curl -s -o /dev/null -w '%{http_code}' -X POST "$API_URL/invitations/accept" -H 'Content-Type: application/json' --data '{"token": "'"$invitation"'"}')
+accepted=$(student -sf -X POST "$API_URL/invitations/accept" -H 'Content-Type: application/json' --data '{"token": "'"$invitation"'"}')
+case "$add_code:$view_code:$wrong_code:$members:$accepted" in
+*'"user_id":'"$admin_id"','*)
+	echo "FAIL tenant isolation: user $admin_id of organization $org_b is listed in organization $org: $members"
+	failures=$((failures + 1))
+	;;
+404:404:404:*:*'"organization_id":'"$org_b"','*) echo "ok   organization admins add users only by invitation" ;;
+*)
+	echo "FAIL tenant isolation: add $add_code, view $view_code, misaddressed accept $wrong_code, accept $accepted"
+	failures=$((failures + 1))
+	;;
+esac
+
+# A small daily budget for the course rejects new jobs in it with 429 until
+# the admin removes the budget again.
+budget=$(curl -sf -X PUT "$API_URL/admin/budgets" -H 'Content-Type: application/json' \
+	--data '{"scope": "course", "scope_id": '"$course"', "period": "daily", "token_limit": 1000}' | json_id)
+limited=$(student -s -i -X POST "$API_URL/generate" -H 'Content-Type: application/json' --data '{"text": "予算超過のジョブ", "course_id": '"$course"'}')
+budgets=$(student -sf "$API_URL/budgets?course_id=$course")
+curl -sf -X DELETE "$API_URL/admin/budgets/$budget" >/dev/null
+case "$limited:$budgets" in
+'HTTP/1.1 429'*'Retry-After: '*'Token budget'*:*'"scope":"course","scope_id":'"$course"','*) echo "ok   course token budget rejects jobs with 429" ;;
+*)
+	echo "FAIL token budget: $limited / $budgets"
+	failures=$((failures + 1))
+	;;
+esac
+
+dead=$(curl -sf "$API_URL/admin/dead-letters")
+case "$dead" in
+*'"problem_id"'*) echo "ok   dead-letter queue is populated" ;;
+*)
+	echo "FAIL dead-letter queue is empty: $dead"
+	failures=$((failures + 1))
+	;;
+esac
+
+[ "$failures" -eq 0 ] || exit 1